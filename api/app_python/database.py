@@ -1,34 +1,54 @@
 import sqlite3
 import os
+import time
 from joblib import Memory
 from collections import defaultdict
 
 memory = Memory("./cache")
 
 DP = "/app/data/database.db"
-DB_TIMESTAMP = lambda: int(os.path.getmtime(DP)) // 1000
+# When the bot uses PostgreSQL (storage.driver: postgres), the same DSN goes here
+# so the API reads that database instead of the SQLite file.
+PG_DSN = os.environ.get("HEARSAY_POSTGRES_DSN", "")
 
-def get_connection() -> sqlite3.Connection:
+if PG_DSN:
+    import psycopg
+
+    DB_TIMESTAMP = lambda: int(time.time()) // 1000
+    SINCE_DAYS = "CURRENT_TIMESTAMP - make_interval(days => ?)"
+else:
+    DB_TIMESTAMP = lambda: int(os.path.getmtime(DP)) // 1000
+    SINCE_DAYS = "datetime('now', '-' || ? || ' days')"
+
+def get_connection():
+    if PG_DSN:
+        return psycopg.connect(PG_DSN)
     return sqlite3.connect(DP)
 
+def execute(conn, query: str, params: tuple = ()):
+    # Queries are written with SQLite's ? placeholders.
+    if PG_DSN:
+        query = query.replace("?", "%s")
+    return conn.execute(query, params)
+
 def get_nicks_with_x_plus_messages(x: int) -> list[str]:
-    with sqlite3.connect(DP) as conn:
-        res = conn.execute("SELECT nick FROM messages GROUP BY nick HAVING COUNT(*) > ?", (x,))
+    with get_connection() as conn:
+        res = execute(conn, "SELECT nick FROM messages GROUP BY nick HAVING COUNT(*) > ?", (x,))
         return [u[0] for u in res]
-    
+
 @memory.cache
 def get_messages_with_x_plus_messages(x: int, cf: int = 0, DBT: int = DB_TIMESTAMP()) -> dict[str, list[str]]:
     author_message = defaultdict(list)
 
-    base_query = """
+    base_query = f"""
         WITH eligible_authors AS (
             SELECT m.nick
             FROM messages m
             JOIN users u ON m.nick = u.nick
-            WHERE u.opt = 1
+            WHERE u.opt = ?
             GROUP BY m.nick
-            HAVING COUNT(*) >= ? 
-               AND (? = 0 OR MAX(m.time) > datetime('now', '-' || ? || ' days'))
+            HAVING COUNT(*) >= ?
+               AND (? = 0 OR MAX(m.time) > {SINCE_DAYS})
         ),
         ranked_messages AS (
             SELECT m.nick,
@@ -41,10 +61,10 @@ def get_messages_with_x_plus_messages(x: int, cf: int = 0, DBT: int = DB_TIMESTA
         FROM ranked_messages
         WHERE rn <= 10000
     """
-    params = (x, cf, cf)
+    params = (True, x, cf, cf)
 
-    with sqlite3.connect(DP) as conn:
-        res = conn.execute(base_query, params)
+    with get_connection() as conn:
+        res = execute(conn, base_query, params)
         for nick, message in res:
             author_message[nick].append(message)
 
@@ -53,17 +73,17 @@ def get_messages_with_x_plus_messages(x: int, cf: int = 0, DBT: int = DB_TIMESTA
 
 @memory.cache
 def get_messages_from_nick(nick: str, DBT: int = DB_TIMESTAMP()) -> list[str]:
-    with sqlite3.connect(DP) as conn:
-        res = conn.execute("SELECT message FROM messages WHERE nick = ? ORDER BY id DESC LIMIT 10000", (nick,))
+    with get_connection() as conn:
+        res = execute(conn, "SELECT message FROM messages WHERE nick = ? ORDER BY id DESC LIMIT 10000", (nick,))
         return [msg[0] for msg in res]
 
 def is_nick_eligible(count: int, nick: str) -> bool:
-    with sqlite3.connect(DP) as conn:
-        res = conn.execute("""SELECT
+    with get_connection() as conn:
+        res = execute(conn, """SELECT
                            COUNT(*)
                            FROM messages m
-                           JOIN users u WHERE u.nick = m.nick
-                           AND opt = 1
+                           JOIN users u ON u.nick = m.nick
+                           WHERE u.opt = ?
                            AND m.nick = (?)
-                           """, (nick,))
-        return int(res.fetchone()[0]) >= count
\ No newline at end of file
+                           """, (True, nick))
+        return int(res.fetchone()[0]) >= count
@@ -79,7 +79,9 @@ def retrain(
         "time": elapsed,
         "url": url,
         "accuracy": accuracy,
-        "f1": f1
+        "f1": f1,
+        "labels": len(pipeline.named_steps["clf"].classes_),
+        "samples": len(X)
     })
 
 
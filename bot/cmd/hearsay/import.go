@@ -0,0 +1,139 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"hearsay/data"
+	"hearsay/internal/config"
+	"hearsay/internal/storage"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// runImport implements `hearsay import --format <format> --channel <channel> --file <path>`.
+func runImport(args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	format := flags.String("format", "", "log format: "+strings.Join(data.Formats(), ", "))
+	channel := flags.String("channel", "", "channel the log was recorded in")
+	path := flags.String("file", "", "log file to import")
+	tz := flags.String("tz", "Local", "time zone of timestamps without an offset, e.g. Europe/Stockholm")
+	date := flags.String("date", "", "day of the log (2006-01-02) for formats that only log times; defaults to a date in the file name")
+	year := flags.Int("year", time.Now().Year(), "year for formats that omit it")
+	dryRun := flags.Bool("dry-run", false, "parse and count without writing to the database")
+	chunkSize := flags.Int("chunk", 1000, "messages committed per transaction")
+	checkpointPath := flags.String("checkpoint", "", "progress file for resuming an interrupted import (default <file>.checkpoint)")
+	mapPath := flags.String("map", "", "YAML file mapping author IDs, or nicks in line logs, to identity keys; required for matrix and discord exports")
+	flags.Parse(args)
+
+	if *format == "" || *channel == "" || *path == "" {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if !slices.Contains(data.Formats(), *format) {
+		log.Fatalf("Unknown log format %q. Known formats: %s\n", *format, strings.Join(data.Formats(), ", "))
+	}
+	if data.IsExport(*format) && *mapPath == "" {
+		log.Fatalf("Importing a %s export needs --map to say which author is which nick.\n", *format)
+	}
+
+	loc, err := time.LoadLocation(*tz)
+	if err != nil {
+		log.Fatalf("Unknown time zone %q: %s\n", *tz, err.Error())
+	}
+	opts := data.ImportOptions{
+		Format:  *format,
+		Channel: *channel,
+		Parser:  data.ParserOptions{Location: loc, Year: *year},
+		DryRun:  *dryRun,
+
+		ChunkSize:  *chunkSize,
+		Checkpoint: *checkpointPath,
+	}
+	if opts.Checkpoint == "" {
+		opts.Checkpoint = *path + ".checkpoint"
+	}
+	if *mapPath != "" {
+		if opts.Authors, err = data.LoadAuthorMap(*mapPath); err != nil {
+			log.Fatalf("Failed to load author map: %s\n", err.Error())
+		}
+	}
+	if *date != "" {
+		if opts.Parser.Date, err = time.ParseInLocation("2006-01-02", *date, loc); err != nil {
+			log.Fatalf("Invalid --date %q: %s\n", *date, err.Error())
+		}
+	} else if day, ok := data.DateFromPath(*path, loc); ok {
+		opts.Parser.Date = day
+	}
+
+	if err := config.ReadConfig("config.yaml", false); err != nil {
+		log.Fatalln("Failed to load configuration.")
+	}
+	store, err := storage.InitStore(config.StorageDriver, config.StorageDSN)
+	if err != nil {
+		log.Fatalf("Failed to open %s database: %s\n", config.StorageDriver, err.Error())
+	}
+	defer store.Close()
+
+	if err := storage.LoadAliases(store); err != nil {
+		log.Fatalf("Failed loading aliases: %s\n", err.Error())
+	}
+	if err := storage.LoadOptIns(store); err != nil {
+		log.Fatalf("Failed loading opt-ins: %s\n", err.Error())
+	}
+
+	f, err := os.Open(*path)
+	if err != nil {
+		log.Fatalf("Failed to open %s: %s\n", *path, err.Error())
+	}
+	defer f.Close()
+
+	if _, err := os.Stat(opts.Checkpoint); err == nil && !*dryRun {
+		log.Printf("Resuming from checkpoint %s.\n", opts.Checkpoint)
+	}
+
+	size := int64(0)
+	if info, err := f.Stat(); err == nil {
+		size = info.Size()
+	}
+	lastReport := time.Now()
+	opts.Progress = func(summary data.ImportSummary, read int64) {
+		if time.Since(lastReport) < 10*time.Second {
+			return
+		}
+		lastReport = time.Now()
+		percent := 0.0
+		if size > 0 {
+			percent = float64(read) / float64(size) * 100
+		}
+		log.Printf("%s: %.1f%% read, %d lines, %d imported, %d duplicates, %d errors\n", *path, percent, summary.Lines, summary.Imported, summary.Duplicates, summary.Errors)
+	}
+
+	summary, err := data.Import(f, opts, store, func(line int, err error) {
+		log.Printf("%s:%d: %s\n", *path, line, err.Error())
+	})
+	if err != nil {
+		log.Printf("Import of %s failed: %s\n", *path, err.Error())
+		if !*dryRun {
+			log.Printf("Run the same command again to resume from %s.\n", opts.Checkpoint)
+		}
+	}
+
+	imported := "imported"
+	if *dryRun {
+		imported = "would import"
+	}
+	unit := "lines"
+	unmapped := ""
+	if data.IsExport(*format) {
+		unit = "records"
+		unmapped = fmt.Sprintf(", %d unmapped", summary.Unmapped)
+	}
+	fmt.Printf("%s: %d %s, %d messages parsed, %d skipped, %d errors, %d duplicates%s, %d not opted in, %s %d\n",
+		*path, summary.Lines, unit, summary.Parsed, summary.Skipped, summary.Errors, summary.Duplicates, unmapped, summary.NotOptedIn, imported, summary.Imported)
+	if err != nil {
+		os.Exit(1)
+	}
+}
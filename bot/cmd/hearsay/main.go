@@ -2,9 +2,14 @@ package main
 
 import (
 	"context"
+	"hearsay/internal/commands"
 	"hearsay/internal/config"
 	"hearsay/internal/core"
+	"hearsay/internal/nlpclient"
+	"hearsay/internal/recent"
+	"hearsay/internal/retrain"
 	"hearsay/internal/storage"
+	"hearsay/internal/takeout"
 	"log"
 	"os"
 	"os/signal"
@@ -13,6 +18,19 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			runMigrate(os.Args[2:])
+			return
+		case "import":
+			runImport(os.Args[2:])
+			return
+		default:
+			log.Fatalf("Unknown subcommand %q. Usage: hearsay [migrate [up|status] | import --format <format> --channel <channel> --file <path>]\n", os.Args[1])
+		}
+	}
+
 	log.Println("hearsay is starting...")
 
 	configPath := "config.yaml"
@@ -30,41 +48,100 @@ func main() {
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 
-	db, err := storage.InitDatabase()
+	store, err := storage.InitStore(config.StorageDriver, config.StorageDSN)
 	if err != nil {
-		log.Fatalln("Failed DB init.")
+		log.Fatalf("Failed DB init: %s\n", err.Error())
 	} else {
-		log.Println("Passed DB init.")
+		log.Printf("Passed DB init (%s).\n", config.StorageDriver)
 	}
+	defer store.Close()
 
-	defer db.Close()
+	if err = storage.LoadOptIns(store); err != nil {
+		log.Fatalf("Failed loading opt-out map: %s\n", err.Error())
+	} else {
+		log.Println("Passed opt-out loading.")
+	}
+
+	if err = storage.LoadAliases(store); err != nil {
+		log.Fatalf("Failed loading aliases: %s\n", err.Error())
+	} else {
+		log.Println("Passed alias loading.")
+	}
+
+	if err = storage.LoadLegacyNicks(store); err != nil {
+		log.Fatalf("Failed loading legacy nicks: %s\n", err.Error())
+	}
 
-	/*err = data.ImportLogs(db, "data/logs.txt")
+	spool, err := storage.OpenSpool(config.SpoolPath)
 	if err != nil {
-		fmt.Println("err returned", err.Error())
+		log.Fatalf("Failed opening spool %s: %s\n", config.SpoolPath, err.Error())
 	}
-	os.Exit(0)*/
 
-	if err = storage.LoadOptIns(db); err != nil {
-		log.Fatalf("Failed loading opt-out map: %s\n", err.Error())
-	} else {
-		log.Println("Passed opt-out loading.")
+	ingestor := storage.NewIngestor(store, spool, config.MaxMessagePool, time.Duration(config.FlushInterval)*time.Second, config.IngestQueueSize)
+	// Runs before store.Close so queued messages are flushed on the way out.
+	defer ingestor.Close()
+
+	nlp := nlpclient.New(config.APIURL, time.Duration(config.APITimeout)*time.Second,
+		time.Duration(config.APIRetrainTimeout)*time.Second, config.APIRetries)
+	commands.UseNLPClient(nlp)
+	retrain.Configure(nlpclient.RetrainOptions{MinMessages: config.MessageQuota, GPU: config.GPU}, config.Admins)
+	if err = retrain.Restore(store); err != nil {
+		log.Fatalf("Failed restoring retrain history: %s\n", err.Error())
+	}
+	go retrain.Run(ctx, nlp, store)
+	if config.AutoRetrain {
+		window, err := retrain.ParseWindow(config.AutoRetrainWindow)
+		if err != nil {
+			log.Fatalf("Invalid scheduler.auto_retrain section: %s\n", err.Error())
+		}
+		go retrain.Schedule(ctx, store, retrain.Policy{
+			NewMessages: config.AutoRetrainMessages,
+			Quota:       config.MessageQuota,
+			People:      config.PeopleQuota,
+			Window:      window,
+			Announce:    config.AutoRetrainAnnounce,
+		})
+		log.Println("Automatic retraining is enabled.")
 	}
+	// Deletions are due whether or not the bot can reach the network.
+	log.Println("Loading deletion scheduler...")
+	go commands.DeletionWrapper(store, ctx)
 
-	serverDisconnect := make(chan struct{})
+	recent.Configure(config.RecentBufferSize, time.Duration(config.RecentWindow)*time.Second)
+	go recent.Run(ctx)
+
+	if config.ExportListen != "" {
+		takeout.Configure(config.ExportDir, config.ExportURL, time.Duration(config.ExportTTL)*time.Second)
+		go func() {
+			if err := takeout.Serve(ctx, config.ExportListen); err != nil {
+				log.Printf("Export server stopped: %s\n", err.Error())
+			}
+		}()
+		log.Printf("Serving data exports on %s.\n", config.ExportListen)
+	}
+
+	// HearsayConnect reconnects on its own and only returns once ctx is canceled.
+	connectionDone := make(chan struct{})
 	go func() {
-		core.HearsayConnect(config.Server, config.Channel, ctx, db)
-		close(serverDisconnect)
+		core.HearsayConnect(config.Server, config.Channel, ctx, store, ingestor)
+		close(connectionDone)
 	}()
 
 	select {
 	case <-sigs:
 		log.Println("Termination signal received. Shutting down...")
 		cancel()
-		time.Sleep(2 * time.Second)
 
-	case <-serverDisconnect:
+		select {
+		case <-connectionDone:
+		case <-time.After(5 * time.Second):
+			log.Println("Timed out waiting for the IRC connection to close.")
+		}
+
+	case <-connectionDone:
+		// Only reachable if the client could not be set up at all.
+		ingestor.Close()
+		store.Close()
 		os.Exit(1)
 	}
-
 }
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"hearsay/internal/config"
+	"hearsay/internal/storage"
+	"log"
+)
+
+// runMigrate implements `hearsay migrate [up|status]`.
+func runMigrate(args []string) {
+	action := "up"
+	if len(args) > 0 {
+		action = args[0]
+	}
+
+	if err := config.ReadConfig("config.yaml", false); err != nil {
+		log.Fatalln("Failed to load configuration.")
+	}
+
+	store, err := storage.OpenStore(config.StorageDriver, config.StorageDSN)
+	if err != nil {
+		log.Fatalf("Failed to open %s database: %s\n", config.StorageDriver, err.Error())
+	}
+	defer store.Close()
+
+	switch action {
+	case "up":
+		applied, err := store.Migrate()
+		if err != nil {
+			log.Fatalf("Migration failed after applying %d migrations: %s\n", applied, err.Error())
+		}
+		log.Printf("Applied %d migrations.\n", applied)
+
+	case "status":
+		states, err := store.MigrationStatus()
+		if err != nil {
+			log.Fatalf("Failed to read migration status: %s\n", err.Error())
+		}
+		for _, state := range states {
+			applied := "pending"
+			if state.Applied.Valid {
+				applied = "applied " + state.Applied.Time.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%4d  %-20s %s\n", state.Version, state.Name, applied)
+		}
+
+	default:
+		log.Fatalf("Unknown migrate action %q. Usage: hearsay migrate [up|status]\n", action)
+	}
+}
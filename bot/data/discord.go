@@ -0,0 +1,63 @@
+package data
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// discordMessage is the part of a DiscordChatExporter JSON message that hearsay needs:
+//
+//	{"messages": [{"type": "Default", "timestamp": "2020-01-01T12:00:00.123+00:00",
+//	  "content": "hello", "author": {"id": "80351110224678912", "name": "alice", "isBot": false}}]}
+type discordMessage struct {
+	Type      string `json:"type"`
+	Timestamp string `json:"timestamp"`
+	Content   string `json:"content"`
+	Author    struct {
+		ID    string `json:"id"`
+		IsBot bool   `json:"isBot"`
+	} `json:"author"`
+}
+
+type discordSource struct {
+	messages *arrayStream
+}
+
+func init() {
+	RegisterExport("discord", func(r io.Reader) (source, error) {
+		messages, err := openArray(r, "messages")
+		if err != nil {
+			return nil, err
+		}
+		return &discordSource{messages}, nil
+	})
+}
+
+func (s *discordSource) Next() (Entry, bool, error) {
+	var message discordMessage
+	if err := s.messages.next(&message); err != nil {
+		return Entry{}, false, err
+	}
+
+	// Joins, pins and the like have other types; attachments alone have no content.
+	if (message.Type != "Default" && message.Type != "Reply") || message.Author.IsBot {
+		return Entry{}, false, nil
+	}
+	content := strings.Join(strings.Fields(message.Content), " ")
+	if content == "" {
+		return Entry{}, false, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, message.Timestamp)
+	if err != nil {
+		return Entry{}, false, &RecordError{fmt.Errorf("bad timestamp %q", message.Timestamp)}
+	}
+
+	return Entry{
+		Nick:    message.Author.ID,
+		Content: content,
+		Time:    t,
+	}, true, nil
+}
@@ -0,0 +1,106 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A source yields the records of a log one at a time. Next returns io.EOF after
+// the last record and a *RecordError for a record that could not be understood;
+// any other error means the rest of the log cannot be read.
+type source interface {
+	Next() (entry Entry, ok bool, err error)
+}
+
+// RecordError is a problem with a single record, which is reported and skipped.
+type RecordError struct {
+	Err error
+}
+
+func (e *RecordError) Error() string {
+	return e.Err.Error()
+}
+
+var exports = map[string]func(io.Reader) (source, error){}
+
+// RegisterExport makes a structured chat export format available to Import. Exports
+// identify authors by ID in Entry.Nick, which the import maps to identities.
+func RegisterExport(format string, open func(io.Reader) (source, error)) {
+	exports[format] = open
+}
+
+// IsExport reports whether format is a structured export that needs an author map.
+func IsExport(format string) bool {
+	_, ok := exports[format]
+	return ok
+}
+
+// LoadAuthorMap reads a YAML file mapping export author IDs to hearsay identities:
+//
+//	"@alice:matrix.org": alice
+//	"80351110224678912": bob
+func LoadAuthorMap(path string) (map[string]string, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	authors := make(map[string]string)
+	if err := yaml.Unmarshal(raw, &authors); err != nil {
+		return nil, fmt.Errorf("reading author map %s: %w", path, err)
+	}
+
+	return authors, nil
+}
+
+// arrayStream decodes the elements of one top-level array in a JSON object
+// without holding the whole export in memory.
+type arrayStream struct {
+	dec *json.Decoder
+}
+
+// openArray advances dec to the first element of the array under key.
+func openArray(r io.Reader, key string) (*arrayStream, error) {
+	dec := json.NewDecoder(r)
+	if token, err := dec.Token(); err != nil {
+		return nil, err
+	} else if token != json.Delim('{') {
+		return nil, fmt.Errorf("expected a JSON object")
+	}
+
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		if token == key {
+			if token, err := dec.Token(); err != nil {
+				return nil, err
+			} else if token != json.Delim('[') {
+				return nil, fmt.Errorf("expected %q to be an array", key)
+			}
+			return &arrayStream{dec}, nil
+		}
+
+		// Skip over whatever value belongs to any other key.
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, fmt.Errorf("no %q array found", key)
+}
+
+// next decodes the next element into v, returning io.EOF at the end of the array.
+func (a *arrayStream) next(v any) error {
+	if !a.dec.More() {
+		return io.EOF
+	}
+
+	return a.dec.Decode(v)
+}
@@ -0,0 +1,173 @@
+package data
+
+import (
+	"bytes"
+	"hearsay/internal/storage"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// importFixture imports testdata/<format>.json, in which katt is opted in, morph_
+// is mapped but not opted in and one author is missing from the map.
+func importFixture(t *testing.T, format string, authors map[string]string) (ImportSummary, *storage.MemoryStore) {
+	t.Helper()
+	f, err := os.Open("testdata/" + format + ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	store := newImportStore(t)
+	opts := ImportOptions{Format: format, Channel: "#antisocial", Authors: authors, ChunkSize: 2}
+	// One byte at a time, so nothing depends on the export being read in one go.
+	summary, err := Import(iotest.OneByteReader(f), opts, store, func(int, error) {})
+	if err != nil {
+		t.Fatalf("Import of %s export failed: %s", format, err)
+	}
+	return summary, store
+}
+
+func storedMessages(t *testing.T, store storage.Store, key string) []Entry {
+	t.Helper()
+	entries := []Entry{}
+	err := store.WalkMessages(key, func(m storage.Message) error {
+		entries = append(entries, Entry{m.Nick, m.Content, m.Timestamp.UTC()})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func TestImportMatrix(t *testing.T) {
+	summary, store := importFixture(t, "matrix", map[string]string{"@katt:matrix.org": "katt", "@morph:matrix.org": "morph_"})
+
+	want := ImportSummary{Lines: 9, Parsed: 5, Skipped: 4, Unmapped: 1, NotOptedIn: 1, Imported: 3}
+	if summary != want {
+		t.Errorf("Summary = %+v, want %+v", summary, want)
+	}
+	// The reply keeps only katt's own words, and the edit and image are left out.
+	messages := []Entry{
+		{"katt", "hello there", at(2025, time.January, 6, 9, 15, 0)},
+		{"katt", "hi yourself", at(2025, time.January, 6, 9, 16, 0)},
+		{"katt", "first line second line", at(2025, time.January, 6, 9, 19, 0)},
+	}
+	if got := storedMessages(t, store, "katt"); !reflect.DeepEqual(got, messages) {
+		t.Errorf("Imported messages = %+v, want %+v", got, messages)
+	}
+}
+
+func TestImportDiscord(t *testing.T) {
+	summary, store := importFixture(t, "discord", map[string]string{"111": "katt", "222": "morph_"})
+
+	want := ImportSummary{Lines: 8, Parsed: 4, Skipped: 3, Errors: 1, Unmapped: 1, NotOptedIn: 1, Imported: 2}
+	if summary != want {
+		t.Errorf("Summary = %+v, want %+v", summary, want)
+	}
+	messages := []Entry{
+		{"katt", "hello there", time.Date(2025, time.January, 6, 9, 15, 0, 123000000, time.UTC)},
+		{"katt", "a reply", at(2025, time.January, 6, 9, 16, 0)},
+	}
+	if got := storedMessages(t, store, "katt"); !reflect.DeepEqual(got, messages) {
+		t.Errorf("Imported messages = %+v, want %+v", got, messages)
+	}
+}
+
+// TestExportStreaming reads a truncated export: the messages before the cut are
+// yielded before the decoder runs out of input.
+func TestExportStreaming(t *testing.T) {
+	raw, err := os.ReadFile("testdata/matrix.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cut := bytes.Index(raw, []byte(`"@morph:matrix.org"`))
+	src, err := exports["matrix"](bytes.NewReader(raw[:cut]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := 0
+	for {
+		entry, ok, err := src.Next()
+		if err != nil {
+			if err == io.EOF {
+				t.Fatal("Truncated export ended cleanly")
+			}
+			break
+		}
+		if ok {
+			entries++
+			if entry.Nick != "@katt:matrix.org" {
+				t.Errorf("Entry from %q before the cut, want only katt's", entry.Nick)
+			}
+		}
+	}
+	if entries != 2 {
+		t.Errorf("Read %d messages before the cut, want 2", entries)
+	}
+}
+
+func TestOpenArray(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want []int
+		ok   bool
+	}{
+		{"array after other keys", `{"a": {"messages": "nested"}, "b": [1, [2]], "messages": [1, 2, 3], "c": 4}`, []int{1, 2, 3}, true},
+		{"empty array", `{"messages": []}`, []int{}, true},
+		{"missing", `{"other": [1]}`, nil, false},
+		{"not an array", `{"messages": {"1": 1}}`, nil, false},
+		{"not an object", `[1, 2]`, nil, false},
+		{"not JSON", `hello`, nil, false},
+	}
+	for _, tt := range tests {
+		array, err := openArray(strings.NewReader(tt.json), "messages")
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: openArray error = %v, want ok %v", tt.name, err, tt.ok)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		got := []int{}
+		for {
+			var v int
+			if err := array.next(&v); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("%s: next: %s", tt.name, err)
+			}
+			got = append(got, v)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: elements = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStripReplyFallback(t *testing.T) {
+	tests := []struct {
+		body string
+		want string
+	}{
+		{"hello", "hello"},
+		{"> <@bob:example.org> hi\n\nhello", "hello"},
+		{"> <@bob:example.org> hi\n> more\n\nhello\nagain", "hello again"},
+		// Only the leading quote is the fallback.
+		{"hello\n> quoted by hand", "hello > quoted by hand"},
+		{"> only a quote", ""},
+	}
+	for _, tt := range tests {
+		if got := stripReplyFallback(tt.body); got != tt.want {
+			t.Errorf("stripReplyFallback(%q) = %q, want %q", tt.body, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package data
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// hexchatParser reads HexChat's log format, which leaves the year out of every
+// line but the header:
+//
+//	**** BEGIN LOGGING AT Mon Jan  2 15:04:05 2006
+//	Jan 02 15:04:05 <nick>	message
+//	Jan 02 15:04:05 -->	nick (user@host) has joined #channel
+type hexchatParser struct {
+	opts  ParserOptions
+	year  int
+	month time.Month
+}
+
+func init() {
+	Register("hexchat", func(opts ParserOptions) Parser {
+		return &hexchatParser{opts: opts, year: opts.Year}
+	})
+}
+
+func (p *hexchatParser) Parse(line string) (Entry, bool, error) {
+	if strings.HasPrefix(line, "****") {
+		if rest, ok := strings.CutPrefix(line, "**** BEGIN LOGGING AT "); ok {
+			if t, err := time.Parse("Mon Jan _2 15:04:05 2006", rest); err == nil {
+				p.year, p.month = t.Year(), t.Month()
+			}
+		}
+		return Entry{}, false, nil
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) < 4 {
+		return Entry{}, false, fmt.Errorf("expected a Jan 02 15:04:05 timestamp")
+	}
+	stamp, err := time.Parse("Jan _2 15:04:05", strings.Join(fields[:3], " "))
+	if err != nil {
+		return Entry{}, false, fmt.Errorf("bad timestamp %q", strings.Join(fields[:3], " "))
+	}
+	// Logs run through the new year without another header.
+	if p.month == time.December && stamp.Month() == time.January {
+		p.year++
+	}
+	p.month = stamp.Month()
+
+	nick, content, ok := splitBracketed(afterFields(line, 3))
+	if !ok {
+		return Entry{}, false, nil
+	}
+
+	t := time.Date(p.year, stamp.Month(), stamp.Day(), stamp.Hour(), stamp.Minute(), stamp.Second(), 0, p.opts.Location)
+	return Entry{nick, content, t}, true, nil
+}
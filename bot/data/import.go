@@ -0,0 +1,247 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hearsay/internal/identity"
+	"hearsay/internal/storage"
+	"io"
+	"os"
+)
+
+type ImportOptions struct {
+	Format  string
+	Channel string
+	Parser  ParserOptions
+	// Authors maps the author IDs of structured exports, or the nicks of line
+	// logs, to hearsay identity keys. Export authors missing from it are not
+	// imported; nicks missing from it are used as they are.
+	Authors map[string]string
+	// DryRun parses and counts everything but writes nothing, not even a checkpoint.
+	DryRun bool
+	// ChunkSize is how many messages are committed per transaction.
+	ChunkSize int
+	// Checkpoint, if set, is a file updated after every chunk so an interrupted
+	// import resumes where it left off. It is removed once the import completes.
+	Checkpoint string
+	// Progress, if set, is called after every chunk with the number of bytes read.
+	Progress func(summary ImportSummary, read int64)
+}
+
+// ImportSummary counts what happened to every line of a log, or every message of an export.
+type ImportSummary struct {
+	Lines      int
+	Parsed     int
+	Skipped    int
+	Errors     int
+	Duplicates int
+	Unmapped   int
+	NotOptedIn int
+	// Imported is how many messages were written, or would be written on a dry run.
+	Imported int
+}
+
+// checkpoint is what the checkpoint file holds: the summary as of the last
+// committed chunk, whose Lines is where to resume.
+type checkpoint struct {
+	Format  string        `json:"format"`
+	Channel string        `json:"channel"`
+	Summary ImportSummary `json:"summary"`
+}
+
+type countingReader struct {
+	r    io.Reader
+	read int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.read += int64(n)
+	return n, err
+}
+
+// Import streams a log or export in the given format into the store, a chunk at
+// a time, keeping the messages of opted-in users that are not stored yet. Records
+// that cannot be parsed are passed to report with their line or message number and
+// do not stop the import. Aliases and opt-ins must have been loaded beforehand.
+func Import(r io.Reader, opts ImportOptions, store storage.Store, report func(line int, err error)) (ImportSummary, error) {
+	var summary ImportSummary
+	counter := &countingReader{r: r}
+	var src source
+	open, isExport := exports[opts.Format]
+	if isExport {
+		var err error
+		if src, err = open(counter); err != nil {
+			return summary, err
+		}
+	} else {
+		parser, err := NewParser(opts.Format, opts.Parser)
+		if err != nil {
+			return summary, err
+		}
+		src = newLineSource(counter, parser)
+	}
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = 1000
+	}
+
+	resumeAt := 0
+	if opts.Checkpoint != "" && !opts.DryRun {
+		cp, err := readCheckpoint(opts.Checkpoint)
+		if err != nil {
+			return summary, err
+		}
+		if cp != nil {
+			if cp.Format != opts.Format || cp.Channel != opts.Channel {
+				return summary, fmt.Errorf("checkpoint %s belongs to a %s import into %s; remove it to start over", opts.Checkpoint, cp.Format, cp.Channel)
+			}
+			summary = cp.Summary
+			resumeAt = cp.Summary.Lines
+		}
+	}
+
+	// Within a dry run nothing is written, so repeats across chunks are caught here.
+	var seen map[string]struct{}
+	if opts.DryRun {
+		seen = make(map[string]struct{})
+	}
+
+	chunk := make([]storage.Message, 0, opts.ChunkSize)
+	flush := func() error {
+		if len(chunk) > 0 {
+			if opts.DryRun {
+				stored, err := store.CountStored(chunk)
+				if err != nil {
+					return err
+				}
+				summary.Duplicates += stored
+				summary.Imported += len(chunk) - stored
+			} else {
+				written, err := store.SubmitMessagesOnce(chunk)
+				if err != nil {
+					return err
+				}
+				summary.Duplicates += len(chunk) - written
+				summary.Imported += written
+			}
+			chunk = chunk[:0]
+		}
+
+		if opts.Checkpoint != "" && !opts.DryRun {
+			if err := writeCheckpoint(opts.Checkpoint, checkpoint{opts.Format, opts.Channel, summary}); err != nil {
+				return err
+			}
+		}
+		if opts.Progress != nil {
+			opts.Progress(summary, counter.read)
+		}
+		return nil
+	}
+
+	for n := 1; ; n++ {
+		entry, ok, err := src.Next()
+		if err == io.EOF {
+			break
+		}
+		var recordErr *RecordError
+		if err != nil && !errors.As(err, &recordErr) {
+			return summary, err
+		}
+		if n <= resumeAt {
+			// Already imported, but line parsers had to see it to know the date.
+			continue
+		}
+
+		summary.Lines++
+		if recordErr != nil {
+			summary.Errors++
+			report(n, recordErr.Err)
+			continue
+		}
+		if !ok {
+			summary.Skipped++
+			continue
+		}
+		summary.Parsed++
+
+		nick := entry.Nick
+		if mapped, ok := opts.Authors[nick]; ok {
+			nick = mapped
+		} else if isExport {
+			summary.Unmapped++
+			continue
+		}
+		// Unmapped nicks of line logs are taken to be their owners' keys from before
+		// accounts were tracked, which lead to their current keys once claimed.
+		nick = identity.Canonical(nick)
+		if !storage.IsOptedIn(nick) {
+			summary.NotOptedIn++
+			continue
+		}
+
+		message := storage.Message{
+			Nick:      nick,
+			Content:   entry.Content,
+			Channel:   opts.Channel,
+			Timestamp: entry.Time,
+		}
+		if seen != nil {
+			hash := message.Hash()
+			if _, ok := seen[hash]; ok {
+				summary.Duplicates++
+				continue
+			}
+			seen[hash] = struct{}{}
+		}
+
+		chunk = append(chunk, message)
+		if len(chunk) >= opts.ChunkSize {
+			if err := flush(); err != nil {
+				return summary, err
+			}
+		}
+	}
+
+	if err := flush(); err != nil {
+		return summary, err
+	}
+	if opts.Checkpoint != "" && !opts.DryRun {
+		if err := os.Remove(opts.Checkpoint); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return summary, err
+		}
+	}
+
+	return summary, nil
+}
+
+func readCheckpoint(path string) (*checkpoint, error) {
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var cp checkpoint
+	if err := json.Unmarshal(raw, &cp); err != nil {
+		return nil, fmt.Errorf("reading checkpoint %s: %w", path, err)
+	}
+
+	return &cp, nil
+}
+
+// writeCheckpoint replaces the checkpoint file atomically so a crash never leaves half of one.
+func writeCheckpoint(path string, cp checkpoint) error {
+	raw, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
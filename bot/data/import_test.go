@@ -0,0 +1,153 @@
+package data
+
+import (
+	"errors"
+	"hearsay/internal/storage"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// interruptedStore accepts a number of chunks and then fails, like a database
+// going away in the middle of an import.
+type interruptedStore struct {
+	*storage.MemoryStore
+	chunks int
+}
+
+func (s *interruptedStore) SubmitMessagesOnce(messages []storage.Message) (int, error) {
+	if s.chunks == 0 {
+		return 0, errors.New("database went away")
+	}
+	s.chunks--
+	return s.MemoryStore.SubmitMessagesOnce(messages)
+}
+
+// importLog is a plain log in which katt says "lol" three times, twice at the
+// same moment, morph_ is not opted in and two lines are malformed.
+const importLog = `2025-01-06T09:15:00Z katt lol
+2025-01-06T09:16:00Z katt lol
+2025-01-06T09:16:00Z katt lol
+2025-01-06T09:17:00Z morph_ hi
+2025-01-06T09:18:00Z katt
+yesterday katt hello
+2025-01-06T09:19:00Z katt bye
+`
+
+func newImportStore(t *testing.T) *storage.MemoryStore {
+	t.Helper()
+	store := storage.NewMemoryStore()
+	if err := store.SetOpt("katt", true); err != nil {
+		t.Fatal(err)
+	}
+	storage.SetOptIn("katt", true)
+	t.Cleanup(func() { storage.SetOptIn("katt", false) })
+	return store
+}
+
+func importString(t *testing.T, log string, opts ImportOptions, store storage.Store) (ImportSummary, error) {
+	t.Helper()
+	opts.Format = "plain"
+	opts.Channel = "#antisocial"
+	return Import(strings.NewReader(log), opts, store, func(int, error) {})
+}
+
+func storedContents(t *testing.T, store storage.Store, key string) []string {
+	t.Helper()
+	contents := []string{}
+	err := store.WalkMessages(key, func(m storage.Message) error {
+		contents = append(contents, m.Content)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return contents
+}
+
+func TestImportResumesAfterInterruption(t *testing.T) {
+	store := newImportStore(t)
+	opts := ImportOptions{ChunkSize: 2, Checkpoint: filepath.Join(t.TempDir(), "import.checkpoint")}
+
+	_, err := importString(t, importLog, opts, &interruptedStore{store, 1})
+	if err == nil {
+		t.Fatal("Import into a store failing after one chunk succeeded")
+	}
+	if got := storedContents(t, store, "katt"); len(got) != 2 {
+		t.Fatalf("Messages after the first chunk = %v, want two", got)
+	}
+
+	summary, err := importString(t, importLog, opts, store)
+	if err != nil {
+		t.Fatalf("Resumed import failed: %s", err)
+	}
+	want := ImportSummary{Lines: 7, Parsed: 5, Errors: 2, Duplicates: 1, NotOptedIn: 1, Imported: 3}
+	if summary != want {
+		t.Errorf("Summary after resuming = %+v, want %+v", summary, want)
+	}
+	// "lol" at two different times is kept twice; the exact repeat is not.
+	if got := storedContents(t, store, "katt"); strings.Join(got, ",") != "lol,lol,bye" {
+		t.Errorf("Messages after resuming = %v, want [lol lol bye]", got)
+	}
+	if cp, err := readCheckpoint(opts.Checkpoint); cp != nil || err != nil {
+		t.Errorf("Checkpoint %+v, %v left behind after completing", cp, err)
+	}
+}
+
+func TestImportResumesByLineCount(t *testing.T) {
+	store := newImportStore(t)
+	opts := ImportOptions{ChunkSize: 1, Checkpoint: filepath.Join(t.TempDir(), "import.checkpoint")}
+
+	// The first two lines were imported and have since been deleted: resuming
+	// starts after them rather than comparing what is stored.
+	cp := checkpoint{"plain", "#antisocial", ImportSummary{Lines: 2, Parsed: 2, Imported: 2}}
+	if err := writeCheckpoint(opts.Checkpoint, cp); err != nil {
+		t.Fatal(err)
+	}
+
+	summary, err := importString(t, importLog, opts, store)
+	if err != nil {
+		t.Fatalf("Import failed: %s", err)
+	}
+	if summary.Lines != 7 || summary.Imported != 4 {
+		t.Errorf("Summary = %+v, want 7 lines and 4 imported", summary)
+	}
+	if got := storedContents(t, store, "katt"); strings.Join(got, ",") != "lol,bye" {
+		t.Errorf("Messages = %v, want [lol bye]", got)
+	}
+
+	// A checkpoint of another import is refused.
+	cp.Channel = "#other"
+	if err := writeCheckpoint(opts.Checkpoint, cp); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := importString(t, importLog, opts, store); err == nil {
+		t.Error("Import resumed from the checkpoint of another channel")
+	}
+}
+
+func TestImportDryRun(t *testing.T) {
+	store := newImportStore(t)
+	if _, err := store.SubmitMessagesOnce([]storage.Message{{Nick: "katt", Channel: "#antisocial", Content: "bye",
+		Timestamp: at(2025, time.January, 6, 9, 19, 0)}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// With chunks of one, repeats within the log are only caught by the dry run itself.
+	opts := ImportOptions{ChunkSize: 1, DryRun: true, Checkpoint: filepath.Join(t.TempDir(), "import.checkpoint")}
+	summary, err := importString(t, importLog, opts, store)
+	if err != nil {
+		t.Fatalf("Dry run failed: %s", err)
+	}
+	want := ImportSummary{Lines: 7, Parsed: 5, Errors: 2, Duplicates: 2, NotOptedIn: 1, Imported: 2}
+	if summary != want {
+		t.Errorf("Dry run summary = %+v, want %+v", summary, want)
+	}
+	if got := storedContents(t, store, "katt"); len(got) != 1 {
+		t.Errorf("Messages after a dry run = %v, want only the one stored before", got)
+	}
+	if cp, err := readCheckpoint(opts.Checkpoint); cp != nil || err != nil {
+		t.Errorf("Dry run wrote checkpoint %+v, %v", cp, err)
+	}
+}
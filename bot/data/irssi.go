@@ -0,0 +1,61 @@
+package data
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// irssiParser reads irssi's default log format:
+//
+//	--- Log opened Mon Jan 02 15:04:05 2006
+//	--- Day changed Tue Jan 03 2006
+//	15:04 <@nick> message
+type irssiParser struct {
+	opts ParserOptions
+	day  time.Time
+}
+
+func init() {
+	Register("irssi", func(opts ParserOptions) Parser {
+		return &irssiParser{opts: opts, day: opts.Date}
+	})
+}
+
+func (p *irssiParser) Parse(line string) (Entry, bool, error) {
+	if rest, ok := strings.CutPrefix(line, "--- Log opened "); ok {
+		day, err := time.ParseInLocation("Mon Jan _2 15:04:05 2006", rest, p.opts.Location)
+		if err != nil {
+			return Entry{}, false, err
+		}
+		p.day = day
+		return Entry{}, false, nil
+	}
+	if rest, ok := strings.CutPrefix(line, "--- Day changed "); ok {
+		day, err := time.ParseInLocation("Mon Jan _2 2006", rest, p.opts.Location)
+		if err != nil {
+			return Entry{}, false, err
+		}
+		p.day = day
+		return Entry{}, false, nil
+	}
+	if strings.HasPrefix(line, "---") {
+		return Entry{}, false, nil
+	}
+
+	stamp, rest, _ := strings.Cut(line, " ")
+	clock, err := parseClock(stamp)
+	if err != nil {
+		return Entry{}, false, fmt.Errorf("bad timestamp %q", stamp)
+	}
+
+	nick, content, ok := splitBracketed(strings.TrimLeft(rest, " "))
+	if !ok {
+		return Entry{}, false, nil
+	}
+	if p.day.IsZero() {
+		return Entry{}, false, ErrNoDate
+	}
+
+	return Entry{nick, content, on(p.day, clock, p.opts.Location)}, true, nil
+}
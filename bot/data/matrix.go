@@ -0,0 +1,73 @@
+package data
+
+import (
+	"io"
+	"strings"
+	"time"
+)
+
+// matrixEvent is the part of an event in an Element room export ("Export chat" as JSON)
+// that hearsay needs:
+//
+//	{"messages": [{"type": "m.room.message", "sender": "@alice:matrix.org",
+//	  "origin_server_ts": 1700000000000, "content": {"msgtype": "m.text", "body": "hello"}}]}
+type matrixEvent struct {
+	Type      string `json:"type"`
+	Sender    string `json:"sender"`
+	Timestamp int64  `json:"origin_server_ts"`
+	Content   struct {
+		MsgType   string `json:"msgtype"`
+		Body      string `json:"body"`
+		RelatesTo struct {
+			RelType string `json:"rel_type"`
+		} `json:"m.relates_to"`
+	} `json:"content"`
+}
+
+type matrixSource struct {
+	events *arrayStream
+}
+
+func init() {
+	RegisterExport("matrix", func(r io.Reader) (source, error) {
+		events, err := openArray(r, "messages")
+		if err != nil {
+			return nil, err
+		}
+		return &matrixSource{events}, nil
+	})
+}
+
+func (s *matrixSource) Next() (Entry, bool, error) {
+	var event matrixEvent
+	if err := s.events.next(&event); err != nil {
+		return Entry{}, false, err
+	}
+
+	// Only plain text messages count; edits repeat the message they replace.
+	if event.Type != "m.room.message" || event.Content.MsgType != "m.text" || event.Content.RelatesTo.RelType == "m.replace" {
+		return Entry{}, false, nil
+	}
+
+	body := stripReplyFallback(event.Content.Body)
+	if body == "" {
+		return Entry{}, false, nil
+	}
+
+	return Entry{
+		Nick:    event.Sender,
+		Content: body,
+		Time:    time.UnixMilli(event.Timestamp),
+	}, true, nil
+}
+
+// stripReplyFallback removes the quoted "> <@bob:example.org> ..." lines replies start
+// with, so only the author's own words remain, and flattens the rest onto one line.
+func stripReplyFallback(body string) string {
+	lines := strings.Split(body, "\n")
+	for len(lines) > 0 && strings.HasPrefix(lines[0], "> ") {
+		lines = lines[1:]
+	}
+
+	return strings.Join(strings.Fields(strings.Join(lines, " ")), " ")
+}
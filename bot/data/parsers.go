@@ -0,0 +1,178 @@
+package data
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Entry is one chat message read from a log.
+type Entry struct {
+	// Nick is the author as the log names them; for structured exports it is their ID.
+	Nick    string
+	Content string
+	Time    time.Time
+}
+
+// ParserOptions is what a parser may need to place a line in time.
+type ParserOptions struct {
+	// Location is used for timestamps that carry no offset.
+	Location *time.Location
+	// Date is the day of the log for formats that only log times of day.
+	Date time.Time
+	// Year is used by formats that omit it until the log says otherwise.
+	Year int
+}
+
+// A Parser reads a log one line at a time and may keep state between lines,
+// such as the current day. ok is false for lines that are not messages,
+// like joins, topic changes and day markers.
+type Parser interface {
+	Parse(line string) (entry Entry, ok bool, err error)
+}
+
+var ErrNoDate = errors.New("no date known for this line; pass --date")
+
+var parsers = map[string]func(ParserOptions) Parser{}
+
+// Register makes a log format available to NewParser. It is meant to be called from init.
+func Register(format string, factory func(ParserOptions) Parser) {
+	parsers[format] = factory
+}
+
+func NewParser(format string, opts ParserOptions) (Parser, error) {
+	factory, ok := parsers[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown log format %q (known: %s)", format, strings.Join(Formats(), ", "))
+	}
+	if opts.Location == nil {
+		opts.Location = time.Local
+	}
+	if opts.Year == 0 {
+		opts.Year = time.Now().Year()
+	}
+
+	return factory(opts), nil
+}
+
+// lineSource feeds a line-based log through a Parser.
+type lineSource struct {
+	scanner *bufio.Scanner
+	parser  Parser
+}
+
+func newLineSource(r io.Reader, parser Parser) *lineSource {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	return &lineSource{scanner, parser}
+}
+
+func (s *lineSource) Next() (Entry, bool, error) {
+	if !s.scanner.Scan() {
+		if err := s.scanner.Err(); err != nil {
+			return Entry{}, false, err
+		}
+		return Entry{}, false, io.EOF
+	}
+
+	line := strings.TrimRight(s.scanner.Text(), "\r")
+	if strings.TrimSpace(line) == "" {
+		return Entry{}, false, nil
+	}
+
+	entry, ok, err := s.parser.Parse(line)
+	if err != nil {
+		return Entry{}, false, &RecordError{err}
+	}
+
+	return entry, ok, nil
+}
+
+// Formats lists every known log and export format.
+func Formats() []string {
+	formats := make([]string, 0, len(parsers)+len(exports))
+	for format := range parsers {
+		formats = append(formats, format)
+	}
+	for format := range exports {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+
+	return formats
+}
+
+var fileDate = regexp.MustCompile(`(\d{4})-?(\d{2})-?(\d{2})`)
+
+// DateFromPath finds a date like 2025-01-02 or 20250102 in a log's file name,
+// which is how ZNC and Textual name their per-day logs.
+func DateFromPath(path string, loc *time.Location) (time.Time, bool) {
+	match := fileDate.FindStringSubmatch(filepath.Base(path))
+	if match == nil {
+		return time.Time{}, false
+	}
+
+	date, err := time.ParseInLocation("20060102", match[1]+match[2]+match[3], loc)
+	return date, err == nil
+}
+
+// stripNick removes the spacing and channel mode prefixes clients put in front of nicks.
+func stripNick(nick string) string {
+	return strings.TrimLeft(strings.TrimSpace(nick), "~&@%+")
+}
+
+// parseClock parses a time of day with or without seconds.
+func parseClock(value string) (time.Time, error) {
+	clock, err := time.Parse("15:04:05", value)
+	if err != nil {
+		clock, err = time.Parse("15:04", value)
+	}
+
+	return clock, err
+}
+
+// afterFields returns line without its first n space-separated fields,
+// keeping the spacing of whatever follows.
+func afterFields(line string, n int) string {
+	rest := line
+	for i := 0; i < n; i++ {
+		rest = strings.TrimLeft(rest, " \t")
+		end := strings.IndexAny(rest, " \t")
+		if end < 0 {
+			return ""
+		}
+		rest = rest[end:]
+	}
+
+	return strings.TrimLeft(rest, " \t")
+}
+
+// on places a time of day on a date.
+func on(date time.Time, clock time.Time, loc *time.Location) time.Time {
+	return time.Date(date.Year(), date.Month(), date.Day(), clock.Hour(), clock.Minute(), clock.Second(), 0, loc)
+}
+
+// splitBracketed splits "<nick> message" into its nick and message.
+// ok is false for anything else, such as actions and server notices.
+func splitBracketed(rest string) (string, string, bool) {
+	if !strings.HasPrefix(rest, "<") {
+		return "", "", false
+	}
+	end := strings.Index(rest, ">")
+	if end < 0 {
+		return "", "", false
+	}
+
+	nick := stripNick(rest[1:end])
+	if nick == "" {
+		return "", "", false
+	}
+
+	return nick, strings.TrimLeft(rest[end+1:], " \t"), true
+}
@@ -0,0 +1,264 @@
+package data
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// errAny stands for any error a parser may return for a malformed line.
+var errAny = errors.New("any error")
+
+type parsed struct {
+	entry Entry
+	ok    bool
+	err   error
+}
+
+func message(nick string, content string, t time.Time) parsed {
+	return parsed{Entry{nick, content, t}, true, nil}
+}
+
+var (
+	skipped   = parsed{}
+	malformed = parsed{err: errAny}
+)
+
+func at(year int, month time.Month, day int, hour int, minute int, second int) time.Time {
+	return time.Date(year, month, day, hour, minute, second, 0, time.UTC)
+}
+
+// TestParsers feeds each format's lines through one parser in order, so day
+// markers carry over to the lines after them.
+func TestParsers(t *testing.T) {
+	day := at(2025, time.January, 6, 0, 0, 0)
+	tests := []struct {
+		name   string
+		format string
+		opts   ParserOptions
+		lines  []string
+		want   []parsed
+	}{
+		{
+			name:   "irssi",
+			format: "irssi",
+			lines: []string{
+				"--- Log opened Mon Jan 06 09:00:00 2025",
+				"09:15 <@katt> hello there",
+				"09:16 -!- morph_ [m@example.org] has joined #antisocial",
+				"09:17  * katt waves",
+				"--- Day changed Tue Jan 07 2025",
+				"00:01 < morph_> late <again>",
+				"--- Log closed Tue Jan 07 00:05:00 2025",
+				"not a log line",
+				"--- Day changed sometime",
+			},
+			want: []parsed{
+				skipped,
+				message("katt", "hello there", at(2025, time.January, 6, 9, 15, 0)),
+				skipped,
+				skipped,
+				skipped,
+				message("morph_", "late <again>", at(2025, time.January, 7, 0, 1, 0)),
+				skipped,
+				malformed,
+				malformed,
+			},
+		},
+		{
+			name:   "irssi without a date",
+			format: "irssi",
+			lines:  []string{"09:15 <katt> hello", "09:16 -!- morph_ has quit"},
+			want:   []parsed{{err: ErrNoDate}, skipped},
+		},
+		{
+			name:   "weechat",
+			format: "weechat",
+			lines: []string{
+				"2025-01-06 09:15:00\t@katt\thello\tthere",
+				"2025-01-06 09:15:01\t-->\tmorph_ (m@example.org) has joined #antisocial",
+				"2025-01-06 09:15:02\t<--\tmorph_ (m@example.org) has quit",
+				"2025-01-06 09:15:03\t--\tkatt is now known as katt_",
+				"2025-01-06 09:15:04\t *\tkatt_ waves",
+				"2025-01-06 09:15\tkatt\thello",
+				"no tabs here",
+			},
+			want: []parsed{
+				message("katt", "hello\tthere", at(2025, time.January, 6, 9, 15, 0)),
+				skipped,
+				skipped,
+				skipped,
+				skipped,
+				malformed,
+				malformed,
+			},
+		},
+		{
+			// Only WeeChat's exact event prefixes are events, not nicks that start with them.
+			name:   "weechat nicks with dashes",
+			format: "weechat",
+			lines: []string{
+				"2025-01-06 09:15:00\t-mike-\tdashes",
+				"2025-01-06 09:15:01\t--katt\tmore dashes",
+				"2025-01-06 09:15:02\t+-->x\tarrows",
+			},
+			want: []parsed{
+				message("-mike-", "dashes", at(2025, time.January, 6, 9, 15, 0)),
+				message("--katt", "more dashes", at(2025, time.January, 6, 9, 15, 1)),
+				message("-->x", "arrows", at(2025, time.January, 6, 9, 15, 2)),
+			},
+		},
+		{
+			name:   "znc",
+			format: "znc",
+			opts:   ParserOptions{Date: day},
+			lines: []string{
+				"[09:15:00] <katt> hello",
+				"[09:15:01] *** Joins: morph_ (m@example.org)",
+				"[09:15:02] * katt waves",
+				"[09:16] <%morph_> no seconds",
+				"09:15 <katt> hello",
+				"[9 o'clock] <katt> hello",
+			},
+			want: []parsed{
+				message("katt", "hello", at(2025, time.January, 6, 9, 15, 0)),
+				skipped,
+				skipped,
+				message("morph_", "no seconds", at(2025, time.January, 6, 9, 16, 0)),
+				malformed,
+				malformed,
+			},
+		},
+		{
+			name:   "znc without a date",
+			format: "znc",
+			lines:  []string{"[09:15:00] <katt> hello"},
+			want:   []parsed{{err: ErrNoDate}},
+		},
+		{
+			// The year comes from the header and rolls over with the log.
+			name:   "hexchat",
+			format: "hexchat",
+			opts:   ParserOptions{Year: 2020},
+			lines: []string{
+				"**** BEGIN LOGGING AT Mon Dec 30 23:59:00 2024",
+				"Dec 31 23:59:00 <katt>\thello",
+				"Jan 01 00:00:05 -->\tmorph_ (m@example.org) has joined #antisocial",
+				"Jan 01 00:00:10 *\tkatt waves",
+				"Jan 01 00:01:00 <@morph_>\thappy new year",
+				"**** ENDING LOGGING AT Wed Jan  1 00:02:00 2025",
+				"Jan 01",
+				"Foo 01 00:00:00 <katt>\thello",
+			},
+			want: []parsed{
+				skipped,
+				message("katt", "hello", at(2024, time.December, 31, 23, 59, 0)),
+				skipped,
+				skipped,
+				message("morph_", "happy new year", at(2025, time.January, 1, 0, 1, 0)),
+				skipped,
+				malformed,
+				malformed,
+			},
+		},
+		{
+			name:   "hexchat without a header",
+			format: "hexchat",
+			opts:   ParserOptions{Year: 2023},
+			lines:  []string{"Mar 14 15:09:26 <katt>\tpi"},
+			want:   []parsed{message("katt", "pi", at(2023, time.March, 14, 15, 9, 26))},
+		},
+		{
+			name:   "textual",
+			format: "textual",
+			opts:   ParserOptions{Date: day},
+			lines: []string{
+				"[2025-01-06T09:15:00+0100] <katt> hello",
+				"[2025-01-06T09:15:30Z] <+morph_> rfc 3339",
+				"[09:16:00] <morph_> time of day",
+				"[09:17:00] morph_ (m@example.org) joined the channel",
+				"[09:18:00] • katt: waves",
+				"09:15 <katt> hello",
+				"[yesterday] <katt> hello",
+			},
+			want: []parsed{
+				message("katt", "hello", at(2025, time.January, 6, 8, 15, 0)),
+				message("morph_", "rfc 3339", at(2025, time.January, 6, 9, 15, 30)),
+				message("morph_", "time of day", at(2025, time.January, 6, 9, 16, 0)),
+				skipped,
+				skipped,
+				malformed,
+				malformed,
+			},
+		},
+		{
+			name:   "textual without a date",
+			format: "textual",
+			lines:  []string{"[09:16:00] <katt> hello", "[2025-01-06T09:15:00Z] <katt> dated"},
+			want:   []parsed{{err: ErrNoDate}, message("katt", "dated", at(2025, time.January, 6, 9, 15, 0))},
+		},
+		{
+			name:   "plain",
+			format: "plain",
+			opts:   ParserOptions{Year: 2024},
+			lines: []string{
+				"2025-01-06T09:15:00Z katt hello there",
+				"2025-01-06 09:15:01 <@katt> bracketed",
+				"Jan 6 09:15:02 morph_  keeps  its  spacing",
+				"2025-01-06T09:15:03Z katt",
+				"yesterday katt hello",
+			},
+			want: []parsed{
+				message("katt", "hello there", at(2025, time.January, 6, 9, 15, 0)),
+				message("katt", "bracketed", at(2025, time.January, 6, 9, 15, 1)),
+				message("morph_", "keeps  its  spacing", at(2024, time.January, 6, 9, 15, 2)),
+				malformed,
+				malformed,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.opts.Location == nil {
+				tt.opts.Location = time.UTC
+			}
+			parser, err := NewParser(tt.format, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i, line := range tt.lines {
+				entry, ok, err := parser.Parse(line)
+				want := tt.want[i]
+				switch {
+				case want.err == errAny && err == nil, want.err != errAny && !errors.Is(err, want.err):
+					t.Errorf("Parse(%q) error = %v, want %v", line, err, want.err)
+				case ok != want.ok:
+					t.Errorf("Parse(%q) ok = %v, want %v", line, ok, want.ok)
+				case entry.Nick != want.entry.Nick || entry.Content != want.entry.Content || !entry.Time.Equal(want.entry.Time):
+					t.Errorf("Parse(%q) = %+v, want %+v", line, entry, want.entry)
+				}
+			}
+		})
+	}
+}
+
+func TestDateFromPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want time.Time
+		ok   bool
+	}{
+		{"logs/#antisocial_20250106.log", at(2025, time.January, 6, 0, 0, 0), true},
+		{"Textual/#antisocial/2025-01-06.txt", at(2025, time.January, 6, 0, 0, 0), true},
+		{"2025-01-06/antisocial.log", time.Time{}, false},
+		{"antisocial.log", time.Time{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := DateFromPath(tt.path, time.UTC)
+		if ok != tt.ok || !got.Equal(tt.want) {
+			t.Errorf("DateFromPath(%q) = %v, %v, want %v, %v", tt.path, got, ok, tt.want, tt.ok)
+		}
+	}
+}
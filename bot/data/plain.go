@@ -0,0 +1,51 @@
+package data
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// plainParser reads one message per line as "<timestamp> <nick> <message>", the
+// nick optionally in angle brackets. The timestamp is RFC 3339, 2006-01-02 15:04:05,
+// or Jan 2 15:04:05 with the year from --year.
+type plainParser struct {
+	opts ParserOptions
+}
+
+func init() {
+	Register("plain", func(opts ParserOptions) Parser {
+		return &plainParser{opts}
+	})
+}
+
+func (p *plainParser) Parse(line string) (Entry, bool, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return Entry{}, false, nil
+	}
+
+	var t time.Time
+	var stampFields int
+	var err error
+	if t, err = time.Parse(time.RFC3339, fields[0]); err == nil {
+		stampFields = 1
+	} else if len(fields) >= 2 {
+		if t, err = time.ParseInLocation("2006-01-02 15:04:05", fields[0]+" "+fields[1], p.opts.Location); err == nil {
+			stampFields = 2
+		} else if len(fields) >= 3 {
+			if t, err = time.ParseInLocation("Jan 2 15:04:05 2006", fmt.Sprintf("%s %s %s %d", fields[0], fields[1], fields[2], p.opts.Year), p.opts.Location); err == nil {
+				stampFields = 3
+			}
+		}
+	}
+	if err != nil {
+		return Entry{}, false, fmt.Errorf("no recognised timestamp")
+	}
+	if len(fields) < stampFields+2 {
+		return Entry{}, false, fmt.Errorf("expected a nick and a message after the timestamp")
+	}
+
+	nick := stripNick(strings.Trim(fields[stampFields], "<>"))
+	return Entry{nick, afterFields(line, stampFields+1), t}, true, nil
+}
@@ -0,0 +1,52 @@
+package data
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// textualParser reads Textual's plain text logs, with either full ISO 8601
+// timestamps or times of day in per-day files:
+//
+//	[2006-01-02T15:04:05-0700] <nick> message
+//	[15:04:05] <nick> message
+type textualParser struct {
+	opts ParserOptions
+}
+
+func init() {
+	Register("textual", func(opts ParserOptions) Parser {
+		return &textualParser{opts}
+	})
+}
+
+func (p *textualParser) Parse(line string) (Entry, bool, error) {
+	stamp, rest, ok := strings.Cut(line, "] ")
+	if !ok || !strings.HasPrefix(stamp, "[") {
+		return Entry{}, false, fmt.Errorf("expected a [timestamp]")
+	}
+	stamp = stamp[1:]
+
+	t, err := time.Parse("2006-01-02T15:04:05-0700", stamp)
+	if err != nil {
+		t, err = time.Parse(time.RFC3339, stamp)
+	}
+	if err != nil {
+		clock, clockErr := parseClock(stamp)
+		if clockErr != nil {
+			return Entry{}, false, fmt.Errorf("bad timestamp %q", stamp)
+		}
+		if p.opts.Date.IsZero() {
+			return Entry{}, false, ErrNoDate
+		}
+		t = on(p.opts.Date, clock, p.opts.Location)
+	}
+
+	nick, content, ok := splitBracketed(rest)
+	if !ok {
+		return Entry{}, false, nil
+	}
+
+	return Entry{nick, content, t}, true, nil
+}
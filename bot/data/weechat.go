@@ -0,0 +1,48 @@
+package data
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// weechatParser reads WeeChat's logger format, tab-separated:
+//
+//	2006-01-02 15:04:05	@nick	message
+//
+// Joins, parts and other events use prefixes such as -->, <-- and --.
+type weechatParser struct {
+	opts ParserOptions
+}
+
+// weechatEvents are the default prefixes WeeChat logs in place of a nick for
+// joins, parts and quits, network messages, errors and actions.
+var weechatEvents = map[string]bool{"-->": true, "<--": true, "--": true, "=!=": true, " *": true}
+
+func init() {
+	Register("weechat", func(opts ParserOptions) Parser {
+		return &weechatParser{opts}
+	})
+}
+
+func (p *weechatParser) Parse(line string) (Entry, bool, error) {
+	parts := strings.SplitN(line, "\t", 3)
+	if len(parts) < 3 {
+		return Entry{}, false, fmt.Errorf("expected three tab-separated fields")
+	}
+
+	t, err := time.ParseInLocation("2006-01-02 15:04:05", parts[0], p.opts.Location)
+	if err != nil {
+		return Entry{}, false, fmt.Errorf("bad timestamp %q", parts[0])
+	}
+
+	if weechatEvents[parts[1]] {
+		return Entry{}, false, nil
+	}
+	nick := stripNick(parts[1])
+	if nick == "" {
+		return Entry{}, false, nil
+	}
+
+	return Entry{nick, parts[2], t}, true, nil
+}
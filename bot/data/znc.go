@@ -0,0 +1,42 @@
+package data
+
+import (
+	"fmt"
+	"strings"
+)
+
+// zncParser reads ZNC's log module format. Files hold a single day, taken from
+// --date or the file name:
+//
+//	[15:04:05] <nick> message
+//	[15:04:05] *** Joins: nick (user@host)
+type zncParser struct {
+	opts ParserOptions
+}
+
+func init() {
+	Register("znc", func(opts ParserOptions) Parser {
+		return &zncParser{opts}
+	})
+}
+
+func (p *zncParser) Parse(line string) (Entry, bool, error) {
+	stamp, rest, ok := strings.Cut(line, "] ")
+	if !ok || !strings.HasPrefix(stamp, "[") {
+		return Entry{}, false, fmt.Errorf("expected a [15:04:05] timestamp")
+	}
+	clock, err := parseClock(stamp[1:])
+	if err != nil {
+		return Entry{}, false, fmt.Errorf("bad timestamp %q", stamp[1:])
+	}
+
+	nick, content, ok := splitBracketed(rest)
+	if !ok {
+		return Entry{}, false, nil
+	}
+	if p.opts.Date.IsZero() {
+		return Entry{}, false, ErrNoDate
+	}
+
+	return Entry{nick, content, on(p.opts.Date, clock, p.opts.Location)}, true, nil
+}
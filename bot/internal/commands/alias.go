@@ -0,0 +1,103 @@
+package commands
+
+import (
+	"fmt"
+	"hearsay/internal/config"
+	"hearsay/internal/identity"
+	"hearsay/internal/storage"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A link has to be requested from one nick and confirmed from the other within this window.
+const aliasConfirmWindow = 10 * time.Minute
+
+type pendingLink struct {
+	fromNick string
+	fromKey  string
+	toNick   string
+	expires  time.Time
+}
+
+var pendingLinksMu sync.Mutex
+var pendingLinks = make(map[string]pendingLink) // keyed by the lowercased requesting nick
+
+func linkAlias(args []string, author string, store storage.Store) string {
+	if len(args) != 2 {
+		return fmt.Sprintf("%s: Usage: %salias link <nick>", author, config.CommandPrefix)
+	}
+	other := args[1]
+	if strings.EqualFold(other, author) {
+		return author + ": You cannot link a nick to itself"
+	}
+
+	authorKey := identity.Key(author)
+	if authorKey == identity.Key(other) {
+		return fmt.Sprintf("%s: %s is already linked to you", author, other)
+	}
+
+	pendingLinksMu.Lock()
+	pending, ok := pendingLinks[strings.ToLower(other)]
+	if ok && time.Now().Before(pending.expires) && strings.EqualFold(pending.toNick, author) {
+		delete(pendingLinks, strings.ToLower(other))
+		pendingLinksMu.Unlock()
+
+		// The nick that was asked to confirm keeps its identity; the requester becomes its alias.
+		err := storage.LinkAlias(pending.fromKey, authorKey, config.MaxProfiles, store)
+		if err == storage.ErrTooManyProfiles {
+			return fmt.Sprintf("%s: You and %s have more than %d profiles between you. Delete some before linking", author, other, config.MaxProfiles)
+		}
+		if err != nil {
+			log.Printf("Failed to link alias %s to %s: %s\n", pending.fromKey, authorKey, err.Error())
+			return author + ": The requested action was met with an error"
+		}
+		log.Printf("Linked alias %s to %s.\n", pending.fromKey, authorKey)
+		return fmt.Sprintf("%s: %s is now linked to you. Its messages, profiles and future activity count towards %s", author, other, authorKey)
+	}
+
+	pendingLinks[strings.ToLower(author)] = pendingLink{
+		fromNick: author,
+		fromKey:  authorKey,
+		toNick:   other,
+		expires:  time.Now().Add(aliasConfirmWindow),
+	}
+	pendingLinksMu.Unlock()
+
+	return fmt.Sprintf("%s: To confirm, type %salias link %s from %s within %d minutes. Your data will then be merged into theirs",
+		author, config.CommandPrefix, author, other, int(aliasConfirmWindow.Minutes()))
+}
+
+func listAliases(args []string, author string, store storage.Store) string {
+	key := identity.Key(author)
+	list, err := store.ListAliases(key)
+	if err != nil {
+		log.Printf("Failed to list aliases of %s: %s\n", key, err.Error())
+		return author + ": Failed to fetch results"
+	}
+
+	if len(list) == 0 {
+		return fmt.Sprintf("%s: You are %s and have no linked aliases", author, key)
+	}
+	return fmt.Sprintf("%s: You are %s. Linked aliases: %s", author, key, strings.Join(list, ", "))
+}
+
+func aliasHandler(args []string, author string, store storage.Store) string {
+	if len(args) < 1 {
+		return listAliases(args, author, store)
+	}
+
+	type fn func([]string, string, storage.Store) string
+	argumentFuncMap := map[string]fn{
+		"link": linkAlias,
+		"list": listAliases,
+	}
+	if aliasFunction, ok := argumentFuncMap[args[0]]; ok {
+		return aliasFunction(args, author, store)
+	}
+
+	return fmt.Sprintf("%s: Invalid argument: %s. See %shelp alias.", author, args[0], config.CommandPrefix)
+}
+
+var aliasHelp string = `Link another nick of yours so that its messages, profiles and opt status count as yours. The link must be requested from one nick and confirmed from the other; the nick that confirms keeps its identity. Profiles whose names clash get a number appended. Usage: ` + config.CommandPrefix + `alias [list] | link <nick>`
@@ -1,25 +1,66 @@
 package commands
 
-import "database/sql"
+import "hearsay/internal/storage"
 
-type CommandFunc func(args []string, author string, db *sql.DB) string
+type CommandFunc func(args []string, author string, store storage.Store) string
 type Command struct {
 	Handler     CommandFunc
 	Description string
+	Scope       Scope
+	// Sensitive commands act on a user's consent or data and may require a services account.
+	Sensitive bool
 }
 
+// Scope says where a command may be run: in a channel, in a private query or both.
+type Scope int
+
+const (
+	ScopeChannel Scope = 1 << iota
+	ScopePrivate
+	ScopeBoth = ScopeChannel | ScopePrivate
+)
+
 var Commands = make(map[string]Command)
 
+// Subcommands that are stricter than their parent command.
+var subcommandScopes = map[string]map[string]Scope{
+	"profile": {"append": ScopePrivate, "show": ScopePrivate, "recent": ScopePrivate},
+}
+
 func init() {
-	Commands["attribute"] = Command{attributeHandler, attributeHelp}
-	Commands["opt"] = Command{optHandler, optHelp}
-	Commands["forget"] = Command{forgetHandler, forgetHelp}
-	Commands["unforget"] = Command{unforgetHandler, unforgetHelp}
-	Commands["help"] = Command{helpHandler, helpHelp}
-	Commands["readability"] = Command{readabilityHandler, readabilityHelp}
-	Commands["retrain"] = Command{retrainHandler, retrainHelp}
-	Commands["about"] = Command{aboutHandler, aboutHelp}
-	Commands["me"] = Command{meHandler, meHelp}
-	Commands["sentiment"] = Command{sentimentHandler, sentimentHelp}
-	Commands["profile"] = Command{profileHandler, profileHelp}
+	Commands["attribute"] = Command{attributeHandler, attributeHelp, ScopeBoth, false}
+	Commands["opt"] = Command{optHandler, optHelp, ScopeBoth, true}
+	Commands["forget"] = Command{forgetHandler, forgetHelp, ScopeBoth, true}
+	Commands["unforget"] = Command{unforgetHandler, unforgetHelp, ScopeBoth, true}
+	// The link goes wherever the reply does, so it is only handed out in private.
+	Commands["export"] = Command{exportHandler, exportHelp, ScopePrivate, true}
+	Commands["help"] = Command{helpHandler, helpHelp, ScopeBoth, false}
+	Commands["readability"] = Command{readabilityHandler, readabilityHelp, ScopeBoth, false}
+	Commands["retrain"] = Command{retrainHandler, retrainHelp, ScopeBoth, false}
+	Commands["models"] = Command{modelsHandler, modelsHelp, ScopeBoth, false}
+	Commands["about"] = Command{aboutHandler, aboutHelp, ScopeBoth, false}
+	Commands["me"] = Command{meHandler, meHelp, ScopeBoth, false}
+	Commands["sentiment"] = Command{sentimentHandler, sentimentHelp, ScopeBoth, false}
+	Commands["profile"] = Command{profileHandler, profileHelp, ScopeBoth, true}
+	Commands["alias"] = Command{aliasHandler, aliasHelp, ScopeBoth, true}
+}
+
+// ScopeFor returns the scope of name invoked with args, taking subcommand policies into account.
+func ScopeFor(name string, args []string) Scope {
+	scope := Commands[name].Scope
+	if len(args) > 0 {
+		if sub, ok := subcommandScopes[name][args[0]]; ok {
+			scope &= sub
+		}
+	}
+
+	return scope
+}
+
+// Allows reports whether the scope permits running in a private query (private) or a channel.
+func (s Scope) Allows(private bool) bool {
+	if private {
+		return s&ScopePrivate != 0
+	}
+	return s&ScopeChannel != 0
 }
@@ -0,0 +1,31 @@
+package commands
+
+import (
+	"fmt"
+	"hearsay/internal/config"
+	"hearsay/internal/identity"
+	"hearsay/internal/storage"
+	"hearsay/internal/takeout"
+	"log"
+	"time"
+)
+
+func exportHandler(args []string, author string, store storage.Store) string {
+	if !takeout.Enabled() {
+		return author + ": Data exports are not enabled on this bot"
+	}
+
+	key := identity.Key(author)
+	url, expires, err := takeout.Publish(key, store)
+	if err == storage.ErrNotFound {
+		return author + ": Your nick was not found in the database"
+	} else if err != nil {
+		log.Printf("Failed to export data: %s\n", err.Error())
+		return author + ": Something went wrong"
+	}
+
+	minutes := int(time.Until(expires).Round(time.Minute).Minutes())
+	return fmt.Sprintf("%s: Your data is ready for download at %s. The link expires in %d minutes; don't share it.", author, url, minutes)
+}
+
+var exportHelp string = `Download everything hearsay stores about you (your user record, messages, profiles and consent history) as a ZIP archive. The link is sent in a private message and expires shortly. Usage: ` + config.CommandPrefix + `export`
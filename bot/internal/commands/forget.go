@@ -2,34 +2,42 @@ package commands
 
 import (
 	"context"
-	"database/sql"
+	"flag"
+	"fmt"
 	"hearsay/internal/config"
+	"hearsay/internal/identity"
+	"hearsay/internal/retrain"
+	"hearsay/internal/storage"
+	"hearsay/internal/takeout"
+	"io"
 	"log"
 	"strconv"
+	"sync/atomic"
 	"time"
 
 	irc "github.com/fluffle/goirc/client"
 )
 
-func forgetHandler(args []string, author string, db *sql.DB) string {
-	var deletionTime sql.NullTime
-	res := db.QueryRow("SELECT deletion FROM users WHERE nick = ?", author)
-	if err := res.Scan(&deletionTime); err != nil {
-		if err == sql.ErrNoRows {
+func forgetHandler(args []string, author string, store storage.Store) string {
+	key := identity.Key(author)
+	if len(args) > 0 {
+		return selectiveForget(args, author, key, store)
+	}
+
+	_, scheduled, err := store.DeletionScheduled(key)
+	if err != nil {
+		if err == storage.ErrNotFound {
 			return author + ": Your nick was not found in the database"
 		}
 		log.Printf("Failed to query deletion time: %s\n", err.Error())
 		return author + ": The requested action was met with an error"
 	}
 
-	if deletionTime.Valid {
+	if scheduled {
 		return author + ": Your data is already scheduled for deletion"
 	}
 
-	deletionDate := time.Now()
-	deletionDate = deletionDate.AddDate(0, 0, config.DeletionDays)
-	deletionDate = deletionDate.Truncate(24 * time.Hour) // Truncating anything past the day.
-	_, err := db.Exec("UPDATE users SET deletion = ? WHERE nick = ?", deletionDate, author)
+	err = store.ScheduleDeletion(key, deletionDate(clock()))
 	if err != nil {
 		log.Printf("Failed to schedule deletion: %s\n", err.Error())
 		return author + ": The requested action was met with an error"
@@ -38,40 +46,203 @@ func forgetHandler(args []string, author string, db *sql.DB) string {
 	return author + ": Your data is scheduled for deletion and will complete in " + strconv.Itoa(config.DeletionDays) + " days. To cancel this request, type +unforget"
 }
 
-var forgetHelp string = `Permanently purge all your data. Usage: ` + config.CommandPrefix + `forget`
+// clock is the time deletions are scheduled and carried out against. It is a
+// variable so that tests can move time along.
+var clock = time.Now
+
+// deletionDate is when a deletion requested at now is carried out: the start of the
+// day DeletionDays ahead, in the bot's time zone rather than UTC.
+func deletionDate(now time.Time) time.Time {
+	year, month, day := now.Date()
+	return time.Date(year, month, day+config.DeletionDays, 0, 0, 0, 0, now.Location())
+}
+
+// selectiveForget handles `forget [--channel <channel>] [--since <time>] [--until <time>] [--last <n>] [--preview]`,
+// which schedules the deletion of only the matching messages.
+func selectiveForget(args []string, author string, key string, store storage.Store) string {
+	// No value takes quoting, and shlex would read a channel name as a comment.
+	fs := flag.NewFlagSet("forgetArgs", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	channel := fs.String("channel", "", "...")
+	since := fs.String("since", "", "...")
+	until := fs.String("until", "", "...")
+	last := fs.Int("last", 0, "...")
+	preview := fs.Bool("preview", false, "...")
+	if err := fs.Parse(args); err != nil || fs.NArg() > 0 || *last < 0 {
+		return author + ": Improper argument(s). See " + config.CommandPrefix + "help forget for usage."
+	}
+
+	now := clock()
+	// Messages collected after the request are never part of it, so the preview stays true.
+	filter := storage.MessageFilter{Channel: *channel, Until: now, Last: *last}
+	if *since != "" {
+		var err error
+		if filter.Since, err = parseForgetTime(*since, false); err != nil {
+			return fmt.Sprintf("%s: Invalid --since %q. Use 2006-01-02 or 2006-01-02T15:04", author, *since)
+		}
+	}
+	if *until != "" {
+		t, err := parseForgetTime(*until, true)
+		if err != nil {
+			return fmt.Sprintf("%s: Invalid --until %q. Use 2006-01-02 or 2006-01-02T15:04", author, *until)
+		}
+		if t.Before(filter.Until) {
+			filter.Until = t
+		}
+	}
+
+	if filter.Channel == "" && filter.Since.IsZero() && *until == "" && filter.Last == 0 {
+		if !*preview {
+			return author + ": Improper argument(s). See " + config.CommandPrefix + "help forget for usage."
+		}
+		count, err := store.CountMessages(key)
+		if err != nil {
+			log.Printf("Failed to count messages: %s\n", err.Error())
+			return author + ": The requested action was met with an error"
+		}
+		return fmt.Sprintf("%s: %sforget would remove all %d of your messages along with your profiles and opt status", author, config.CommandPrefix, count)
+	}
+
+	count, err := store.CountMatching(key, filter)
+	if err != nil {
+		log.Printf("Failed to count messages to forget: %s\n", err.Error())
+		return author + ": The requested action was met with an error"
+	}
+	if *preview {
+		return fmt.Sprintf("%s: This would remove %d of your messages. Run the same command without --preview to schedule it", author, count)
+	}
+	if count == 0 {
+		return author + ": None of your messages match"
+	}
+
+	if err := store.ScheduleForget(key, filter, deletionDate(now)); err != nil {
+		log.Printf("Failed to schedule selective deletion: %s\n", err.Error())
+		return author + ": The requested action was met with an error"
+	}
+
+	return fmt.Sprintf("%s: %d of your messages are scheduled for deletion and will be removed in %d days. To cancel this request, type %sunforget", author, count, config.DeletionDays, config.CommandPrefix)
+}
+
+// parseForgetTime reads a date or a date and time of day in the bot's time zone. A bare
+// date given as the end of a range covers that whole day.
+func parseForgetTime(value string, end bool) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02T15:04", value, time.Local); err == nil {
+		return t, nil
+	}
+
+	t, err := time.ParseInLocation(time.DateOnly, value, time.Local)
+	if err == nil && end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, err
+}
 
-func deletionExecuter(db *sql.DB) []string {
-	// TODO: Transform to transaction.
+var forgetHelp string = `Permanently purge all your data, or with flags only the matching messages: those in a channel, from a date or time (2006-01-02 or 2006-01-02T15:04) onwards, up to one, or your last N. Add --preview to see how many messages would be removed without removing them. Deletions can be cancelled with ` + config.CommandPrefix + `unforget until they are carried out. Usage: ` + config.CommandPrefix + `forget [--channel <channel>] [--since <time>] [--until <time>] [--last <n>] [--preview]`
+
+func deletionExecuter(store storage.Store, now time.Time) []string {
 	deletedNicks := []string{}
-	res, err := db.Query("SELECT nick FROM users WHERE DATE(deletion) = DATE('now')")
+	nicks, err := store.DeletionsDue(now)
 	if err != nil {
-		log.Printf("Failed to query today's deletions: %s\n", err.Error())
+		log.Printf("Failed to query due deletions: %s\n", err.Error())
 		return make([]string, 0)
 	}
-	defer res.Close()
 
-	for res.Next() {
-		var nick string
-		err := res.Scan(&nick)
+	for _, nick := range nicks {
+		// The model may have been trained on whoever had messages, so it must be retrained without them.
+		count, err := store.CountMessages(nick)
 		if err != nil {
-			log.Printf("Failed to scan to-be-deleted nick: %s\n", err.Error())
+			log.Printf("Failed to count messages of nick due for deletion: %s\n", err.Error())
 		}
-
-		_, err = db.Exec("DELETE FROM users WHERE nick = ?", nick)
+		hadMessages := err != nil || count > 0
+		err = store.DeleteUser(nick)
 		if err != nil {
 			log.Printf("Failed to delete nick from users table: %s\n", err.Error())
 		} else {
+			takeout.Revoke(nick)
+			storage.SetOptIn(nick, false)
 			deletedNicks = append(deletedNicks, nick)
+			if hadMessages {
+				retrain.Invalidate("a user's data was purged")
+			}
 		}
 	}
 
 	return deletedNicks
 }
 
-func DeletionWrapper(db *sql.DB, c *irc.Conn, ctx context.Context) {
+// forgetExecuter carries out the selective deletions that are due and returns how
+// many messages were removed for each nick.
+func forgetExecuter(store storage.Store, now time.Time) map[string]int {
+	forgotten := make(map[string]int)
+	forgets, err := store.ForgetsDue(now)
+	if err != nil {
+		log.Printf("Failed to query due selective deletions: %s\n", err.Error())
+		return forgotten
+	}
+
+	for _, forget := range forgets {
+		deleted, err := store.Forget(forget.ID)
+		if err != nil {
+			log.Printf("Failed to carry out selective deletion %d: %s\n", forget.ID, err.Error())
+			continue
+		}
+		forgotten[forget.Key] += deleted
+		if deleted > 0 {
+			retrain.Invalidate("a user's messages were purged")
+		}
+	}
+
+	return forgotten
+}
+
+// purge carries out everything due at now, including deletions that fell due while
+// the bot was not running, and tells the users concerned.
+func purge(store storage.Store, c *irc.Conn, now time.Time) {
+	deletedNicks := deletionExecuter(store, now)
+	forgotten := forgetExecuter(store, now)
+	if len(deletedNicks) > 0 || len(forgotten) > 0 {
+		retrain.NoteDeletion()
+	}
+	if c == nil || !c.Connected() {
+		// The reconnect loop may be backing off; the purge itself already happened.
+		log.Printf("Purged %d nicks and %d selections while disconnected; skipping notifications.\n", len(deletedNicks), len(forgotten))
+		return
+	}
+
+	// Deletions are kept by identity key, which is only a nick to message when
+	// someone who has it is in one of our channels right now.
+	// TODO: If the user isn't online, postpone the reminder until they are.
+	for _, key := range deletedNicks {
+		if nick, ok := identity.Online(key); ok {
+			c.Privmsg(nick, "Your data has been successfully purged")
+		}
+	}
+	for key, deleted := range forgotten {
+		if nick, ok := identity.Online(key); ok {
+			c.Privmsgf(nick, "%d of your messages have been successfully purged", deleted)
+		}
+	}
+}
+
+// conn is the connection purge notifications are sent over, once there is one.
+var conn atomic.Pointer[irc.Conn]
+
+// UseConnection makes the deletion scheduler notify users over c. The connection
+// object outlives reconnects, so this is only needed once.
+func UseConnection(c *irc.Conn) {
+	conn.Store(c)
+}
+
+// DeletionWrapper purges whatever is due now and then every midnight until ctx is
+// canceled. It runs whether or not the bot is connected, so deletions are never
+// held up by a network that cannot be reached.
+func DeletionWrapper(store storage.Store, ctx context.Context) {
+	purge(store, conn.Load(), clock())
+
 	for {
-		now := time.Now()
-		next := now.Truncate(24 * time.Hour).Add(24 * time.Hour)
+		now := clock()
+		year, month, day := now.Date()
+		next := time.Date(year, month, day+1, 0, 0, 0, 0, now.Location())
 		log.Printf("Next deletion cycle scheduled for %v\n", next)
 
 		select {
@@ -79,12 +250,8 @@ func DeletionWrapper(db *sql.DB, c *irc.Conn, ctx context.Context) {
 			log.Println("Shutting down scheduler.")
 			return
 
-		case <-time.After(time.Until(next)):
-			deletedNicks := deletionExecuter(db)
-			for _, nick := range deletedNicks {
-				// TODO: If the user isn't online, postpone the reminder until they are.
-				c.Privmsg(nick, "Your data has been successfully purged")
-			}
+		case <-time.After(next.Sub(now)):
+			purge(store, conn.Load(), clock())
 		}
 	}
 }
@@ -0,0 +1,342 @@
+package commands
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hearsay/internal/config"
+	"hearsay/internal/identity"
+	"hearsay/internal/storage"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	irc "github.com/fluffle/goirc/client"
+)
+
+// newForgetStore returns a migrated SQLite store and a second handle on the same
+// file for looking at what the Store interface does not expose.
+func newForgetStore(t *testing.T) (*storage.SQLStore, *sql.DB) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "hearsay.db")
+	store, err := storage.InitStore("sqlite3", path)
+	if err != nil {
+		t.Fatalf("InitStore: %s", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	db, err := sql.Open("sqlite3", "file:"+path+"?_foreign_keys=on")
+	if err != nil {
+		t.Fatalf("opening %s: %s", path, err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return store, db
+}
+
+// setClock makes clock return whatever now points at for the rest of the test.
+func setClock(t *testing.T, now *time.Time) {
+	t.Helper()
+	previous := clock
+	clock = func() time.Time { return *now }
+	t.Cleanup(func() { clock = previous })
+}
+
+func setDeletionDays(t *testing.T, days int) {
+	t.Helper()
+	previous := config.DeletionDays
+	config.DeletionDays = days
+	t.Cleanup(func() { config.DeletionDays = previous })
+}
+
+// addUser opts nick in and stores count messages for them, sent before at.
+func addUser(t *testing.T, store storage.Store, nick string, count int, at time.Time) string {
+	t.Helper()
+	key := identity.Key(nick)
+	if err := store.SetOpt(key, true); err != nil {
+		t.Fatalf("SetOpt(%s): %s", key, err)
+	}
+	storage.SetOptIn(key, true)
+	t.Cleanup(func() { storage.SetOptIn(key, false) })
+
+	messages := make([]storage.Message, count)
+	for i := range messages {
+		channel := "#hearsay"
+		if i%2 == 1 {
+			channel = "#other"
+		}
+		messages[i] = storage.Message{Nick: key, Content: fmt.Sprintf("%s says %d", nick, i), Channel: channel, Timestamp: at.Add(-time.Duration(count-i) * time.Hour)}
+	}
+	if err := store.SubmitMessages(messages); err != nil {
+		t.Fatalf("SubmitMessages(%s): %s", key, err)
+	}
+
+	return key
+}
+
+type auditRow struct {
+	nick     string
+	kind     string
+	messages int
+	profiles int
+}
+
+func deletionAudit(t *testing.T, db *sql.DB) []auditRow {
+	t.Helper()
+	res, err := db.Query("SELECT nick, kind, messages, profiles FROM deletion_audit ORDER BY id")
+	if err != nil {
+		t.Fatalf("reading deletion_audit: %s", err)
+	}
+	defer res.Close()
+
+	rows := []auditRow{}
+	for res.Next() {
+		var row auditRow
+		if err := res.Scan(&row.nick, &row.kind, &row.messages, &row.profiles); err != nil {
+			t.Fatalf("reading deletion_audit: %s", err)
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func userExists(t *testing.T, store storage.Store, key string) bool {
+	t.Helper()
+	_, err := store.User(key)
+	if err == storage.ErrNotFound {
+		return false
+	} else if err != nil {
+		t.Fatalf("User(%s): %s", key, err)
+	}
+	return true
+}
+
+func TestDeletionDate(t *testing.T) {
+	setDeletionDays(t, 1)
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("time zone data unavailable: %s", err)
+	}
+
+	tests := []struct {
+		now  time.Time
+		want time.Time
+	}{
+		{time.Date(2025, 3, 1, 23, 59, 0, 0, berlin), time.Date(2025, 3, 2, 0, 0, 0, 0, berlin)},
+		{time.Date(2025, 3, 31, 0, 0, 0, 0, berlin), time.Date(2025, 4, 1, 0, 0, 0, 0, berlin)},
+		{time.Date(2025, 12, 31, 12, 0, 0, 0, berlin), time.Date(2026, 1, 1, 0, 0, 0, 0, berlin)},
+		// Midnight in the bot's time zone, not UTC, across the switch to summer time.
+		{time.Date(2025, 3, 29, 22, 30, 0, 0, berlin), time.Date(2025, 3, 30, 0, 0, 0, 0, berlin)},
+	}
+	for _, tt := range tests {
+		if got := deletionDate(tt.now); !got.Equal(tt.want) {
+			t.Errorf("deletionDate(%v) = %v, want %v", tt.now, got, tt.want)
+		}
+	}
+}
+
+func TestPurgeCatchesUpOverdueDeletions(t *testing.T) {
+	store, db := newForgetStore(t)
+	setDeletionDays(t, 2)
+	now := time.Date(2025, 3, 1, 15, 0, 0, 0, time.Local)
+	setClock(t, &now)
+	c := irc.SimpleClient("hearsay")
+
+	keys := map[string]string{}
+	for i, nick := range []string{"alice", "bob", "carol"} {
+		keys[nick] = addUser(t, store, nick, 3+i, now)
+	}
+
+	// alice and bob ask on consecutive days, carol two days after bob.
+	forgetHandler(nil, "alice", store)
+	now = now.AddDate(0, 0, 1)
+	forgetHandler(nil, "bob", store)
+	now = now.AddDate(0, 0, 2)
+	forgetHandler(nil, "carol", store)
+
+	for nick, want := range map[string]time.Time{
+		"alice": time.Date(2025, 3, 3, 0, 0, 0, 0, time.Local),
+		"bob":   time.Date(2025, 3, 4, 0, 0, 0, 0, time.Local),
+		"carol": time.Date(2025, 3, 6, 0, 0, 0, 0, time.Local),
+	} {
+		at, scheduled, err := store.DeletionScheduled(keys[nick])
+		if err != nil || !scheduled || !at.Equal(want) {
+			t.Fatalf("deletion of %s scheduled for %v (%v, %v), want %v", nick, at, scheduled, err, want)
+		}
+	}
+
+	// The bot was down over both of the first two midnights and comes back on 5 March.
+	now = time.Date(2025, 3, 5, 9, 30, 0, 0, time.Local)
+	purge(store, c, clock())
+
+	for nick, want := range map[string]bool{"alice": false, "bob": false, "carol": true} {
+		if got := userExists(t, store, keys[nick]); got != want {
+			t.Errorf("after catching up, %s exists = %v, want %v", nick, got, want)
+		}
+	}
+	if storage.IsOptedIn(keys["alice"]) || storage.IsOptedIn(keys["bob"]) {
+		t.Errorf("purged users are still opted in")
+	}
+	want := []auditRow{{keys["alice"], "full", 3, 0}, {keys["bob"], "full", 4, 0}}
+	if got := deletionAudit(t, db); !slices.Equal(got, want) {
+		t.Errorf("deletion_audit = %v, want %v", got, want)
+	}
+
+	// One second before carol's midnight nothing happens; at midnight she goes.
+	now = time.Date(2025, 3, 5, 23, 59, 59, 0, time.Local)
+	purge(store, c, clock())
+	if !userExists(t, store, keys["carol"]) {
+		t.Fatalf("carol was purged before her deletion date")
+	}
+	now = time.Date(2025, 3, 6, 0, 0, 0, 0, time.Local)
+	purge(store, c, clock())
+	if userExists(t, store, keys["carol"]) {
+		t.Fatalf("carol was not purged at her deletion date")
+	}
+	if count, err := store.CountMessages(keys["carol"]); err != nil || count != 0 {
+		t.Errorf("carol has %d messages left (%v)", count, err)
+	}
+
+	want = append(want, auditRow{keys["carol"], "full", 5, 0})
+	if got := deletionAudit(t, db); !slices.Equal(got, want) {
+		t.Errorf("deletion_audit = %v, want %v", got, want)
+	}
+
+	// Running the purge again is harmless.
+	purge(store, c, clock())
+	if got := deletionAudit(t, db); len(got) != len(want) {
+		t.Errorf("a second purge added audit rows: %v", got)
+	}
+}
+
+// TestDeletionWrapperWithoutConnection purges what is due before the bot has ever
+// connected, as when main starts the scheduler while the network is unreachable.
+func TestDeletionWrapperWithoutConnection(t *testing.T) {
+	store, _ := newForgetStore(t)
+	now := time.Date(2025, 3, 5, 9, 30, 0, 0, time.Local)
+	setClock(t, &now)
+	key := addUser(t, store, "dora", 2, now)
+	if err := store.ScheduleDeletion(key, now.Add(-time.Hour)); err != nil {
+		t.Fatalf("ScheduleDeletion: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	DeletionWrapper(store, ctx)
+	if userExists(t, store, key) {
+		t.Errorf("dora was not purged without a connection")
+	}
+}
+
+func TestDeletionExecuterUsesOneTransactionPerUser(t *testing.T) {
+	store, db := newForgetStore(t)
+	setDeletionDays(t, 1)
+	now := time.Date(2025, 6, 10, 12, 0, 0, 0, time.Local)
+	setClock(t, &now)
+
+	alice := addUser(t, store, "alice", 2, now)
+	bob := addUser(t, store, "bob", 3, now)
+	carol := addUser(t, store, "carol", 4, now)
+	if err := store.CreateProfile(bob, "work"); err != nil {
+		t.Fatalf("CreateProfile: %s", err)
+	}
+	for _, key := range []string{alice, bob, carol} {
+		if err := store.ScheduleDeletion(key, deletionDate(now)); err != nil {
+			t.Fatalf("ScheduleDeletion(%s): %s", key, err)
+		}
+	}
+
+	// Writing bob's audit row fails halfway through his deletion.
+	_, err := db.Exec(fmt.Sprintf(`CREATE TRIGGER fail_audit BEFORE INSERT ON deletion_audit
+	WHEN NEW.nick = '%s' BEGIN SELECT RAISE(ABORT, 'audit unavailable'); END`, bob))
+	if err != nil {
+		t.Fatalf("creating trigger: %s", err)
+	}
+
+	now = now.AddDate(0, 0, 1)
+	deleted := deletionExecuter(store, clock())
+	slices.Sort(deleted)
+	if want := []string{alice, carol}; !slices.Equal(deleted, want) {
+		t.Fatalf("deletionExecuter deleted %v, want %v", deleted, want)
+	}
+
+	// bob's deletion was rolled back as a whole; the others were not affected.
+	if !userExists(t, store, bob) {
+		t.Fatalf("bob's users row is gone although his deletion failed")
+	}
+	if count, err := store.CountMessages(bob); err != nil || count != 3 {
+		t.Errorf("bob has %d messages (%v), want 3", count, err)
+	}
+	if count, err := store.CountProfiles(bob); err != nil || count != 1 {
+		t.Errorf("bob has %d profiles (%v), want 1", count, err)
+	}
+	if _, scheduled, _ := store.DeletionScheduled(bob); !scheduled {
+		t.Errorf("bob's deletion is no longer scheduled")
+	}
+	want := []auditRow{{alice, "full", 2, 0}, {carol, "full", 4, 0}}
+	if got := deletionAudit(t, db); !slices.Equal(got, want) {
+		t.Errorf("deletion_audit = %v, want %v", got, want)
+	}
+
+	// Once the audit works again, the next cycle picks bob up.
+	if _, err := db.Exec("DROP TRIGGER fail_audit"); err != nil {
+		t.Fatalf("dropping trigger: %s", err)
+	}
+	now = now.AddDate(0, 0, 1)
+	if deleted := deletionExecuter(store, clock()); !slices.Equal(deleted, []string{bob}) {
+		t.Fatalf("deletionExecuter deleted %v, want [%s]", deleted, bob)
+	}
+	want = append(want, auditRow{bob, "full", 3, 1})
+	if got := deletionAudit(t, db); !slices.Equal(got, want) {
+		t.Errorf("deletion_audit = %v, want %v", got, want)
+	}
+}
+
+func TestForgetExecuterAcrossDays(t *testing.T) {
+	store, db := newForgetStore(t)
+	setDeletionDays(t, 1)
+	now := time.Date(2025, 3, 1, 20, 0, 0, 0, time.Local)
+	setClock(t, &now)
+	c := irc.SimpleClient("hearsay")
+
+	alice := addUser(t, store, "alice", 6, now)
+	bob := addUser(t, store, "bob", 4, now)
+
+	if reply := forgetHandler([]string{"--channel", "#other"}, "alice", store); !strings.Contains(reply, "3 of your messages are scheduled") {
+		t.Fatalf("forget --channel replied %q", reply)
+	}
+	// Sent after the request, so never part of it.
+	late := storage.Message{Nick: alice, Content: "too late", Channel: "#other", Timestamp: now.Add(time.Hour)}
+	if err := store.SubmitMessages([]storage.Message{late}); err != nil {
+		t.Fatalf("SubmitMessages: %s", err)
+	}
+
+	// Nothing is due before midnight.
+	now = time.Date(2025, 3, 1, 23, 59, 0, 0, time.Local)
+	if forgotten := forgetExecuter(store, clock()); len(forgotten) != 0 {
+		t.Fatalf("forgetExecuter ran early: %v", forgotten)
+	}
+
+	// Overdue by two days when the bot comes back.
+	now = time.Date(2025, 3, 4, 8, 0, 0, 0, time.Local)
+	purge(store, c, clock())
+
+	if count, err := store.CountMessages(alice); err != nil || count != 4 {
+		t.Errorf("alice has %d messages (%v), want 4", count, err)
+	}
+	if count, err := store.CountMessages(bob); err != nil || count != 4 {
+		t.Errorf("bob has %d messages (%v), want 4", count, err)
+	}
+	if !userExists(t, store, alice) {
+		t.Errorf("a selective deletion removed alice's users row")
+	}
+	want := []auditRow{{alice, "selective", 3, 0}}
+	if got := deletionAudit(t, db); !slices.Equal(got, want) {
+		t.Errorf("deletion_audit = %v, want %v", got, want)
+	}
+	if forgotten := forgetExecuter(store, clock()); len(forgotten) != 0 {
+		t.Errorf("the selective deletion ran twice: %v", forgotten)
+	}
+}
@@ -1,13 +1,13 @@
 package commands
 
 import (
-	"database/sql"
 	"fmt"
 	"hearsay/internal/config"
+	"hearsay/internal/storage"
 	"strings"
 )
 
-func helpHandler(args []string, author string, db *sql.DB) string {
+func helpHandler(args []string, author string, store storage.Store) string {
 	if len(args) == 0 {
 		var listOfCommands []string
 		for v := range Commands {
@@ -0,0 +1,136 @@
+package commands
+
+import (
+	"fmt"
+	"hearsay/internal/config"
+	"hearsay/internal/storage"
+	"log"
+	"strconv"
+	"strings"
+)
+
+const maxModelsListed = 10
+
+func modelsHandler(args []string, author string, store storage.Store) string {
+	if len(args) > 0 && args[0] == "compare" {
+		return compareModels(args[1:], author, store)
+	}
+
+	count := 5
+	if len(args) > 0 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 || len(args) > 1 {
+			return author + ": Improper argument(s). See " + config.CommandPrefix + "help models for usage."
+		}
+		count = min(n, maxModelsListed)
+	}
+
+	runs, err := store.ModelRuns(count)
+	if err != nil {
+		log.Printf("Failed to list model runs: %s\n", err.Error())
+		return author + ": Something went wrong"
+	}
+	if len(runs) == 0 {
+		return author + ": The model has not been retrained yet"
+	}
+
+	lines := make([]string, 0, len(runs))
+	for _, run := range runs {
+		lines = append(lines, summarizeRun(run))
+	}
+	return author + ": " + strings.Join(lines, " | ")
+}
+
+// summarizeRun describes a run in one line, for listing several.
+func summarizeRun(run storage.ModelRun) string {
+	summary := fmt.Sprintf("\x02#%d\x02 %s %s", run.ID, run.Started.Local().Format("2006-01-02 15:04"), runOrigin(run))
+	if flags := runFlags(run); flags != "" {
+		summary += " (" + flags + ")"
+	}
+	if !run.Succeeded {
+		return summary + ": failed"
+	}
+
+	summary += fmt.Sprintf(": %d labels, %d samples, fit in %.1fs", run.Labels, run.Samples, run.FitSeconds)
+	if run.Accuracy != nil && run.F1 != nil {
+		summary += fmt.Sprintf(", accuracy %.4f, F1 %.4f", *run.Accuracy, *run.F1)
+	}
+	return summary
+}
+
+// compareModels handles `models compare <id> <id>`, which shows how two successful runs differ.
+func compareModels(args []string, author string, store storage.Store) string {
+	if len(args) != 2 {
+		return author + ": Improper argument(s). See " + config.CommandPrefix + "help models for usage."
+	}
+
+	var runs [2]storage.ModelRun
+	for i, arg := range args {
+		id, err := strconv.ParseInt(strings.TrimPrefix(arg, "#"), 10, 64)
+		if err != nil {
+			return author + ": Improper argument(s). See " + config.CommandPrefix + "help models for usage."
+		}
+		runs[i], err = store.ModelRun(id)
+		if err == storage.ErrNotFound {
+			return fmt.Sprintf("%s: There is no model run #%d", author, id)
+		} else if err != nil {
+			log.Printf("Failed to look up model run %d: %s\n", id, err.Error())
+			return author + ": Something went wrong"
+		}
+		if !runs[i].Succeeded {
+			return fmt.Sprintf("%s: Model run #%d failed, so there is nothing to compare", author, id)
+		}
+	}
+	a, b := runs[0], runs[1]
+
+	parts := []string{
+		fmt.Sprintf("\x02#%d\x02 -> \x02#%d\x02", a.ID, b.ID),
+		fmt.Sprintf("labels %d -> %d (%+d)", a.Labels, b.Labels, b.Labels-a.Labels),
+		fmt.Sprintf("samples %d -> %d (%+d)", a.Samples, b.Samples, b.Samples-a.Samples),
+		fmt.Sprintf("fit %.1fs -> %.1fs", a.FitSeconds, b.FitSeconds),
+	}
+	if a.Accuracy != nil && b.Accuracy != nil && a.F1 != nil && b.F1 != nil {
+		parts = append(parts,
+			fmt.Sprintf("accuracy %.4f -> %.4f (%+.4f)", *a.Accuracy, *b.Accuracy, *b.Accuracy-*a.Accuracy),
+			fmt.Sprintf("F1 %.4f -> %.4f (%+.4f)", *a.F1, *b.F1, *b.F1-*a.F1))
+	} else {
+		parts = append(parts, "scores need both runs to have been retrained with --cm")
+	}
+	parts = append(parts, fmt.Sprintf("data until %s -> %s", dataUntil(a), dataUntil(b)))
+	if flagsA, flagsB := runFlags(a), runFlags(b); flagsA != flagsB {
+		parts = append(parts, fmt.Sprintf("flags %q -> %q", flagsA, flagsB))
+	}
+
+	return author + ": " + strings.Join(parts, " | ")
+}
+
+func runOrigin(run storage.ModelRun) string {
+	if run.Requester == "" {
+		return "automatic"
+	}
+	return "by " + run.Requester
+}
+
+// runFlags renders the flags a run was requested with the way they are typed.
+func runFlags(run storage.ModelRun) string {
+	var flags []string
+	if run.ConfusionMatrix {
+		flags = append(flags, "--cm")
+	}
+	if run.Bert {
+		flags = append(flags, "--bert")
+	}
+	if run.PastDays > 0 {
+		flags = append(flags, fmt.Sprintf("--past %d", run.PastDays))
+	}
+	return strings.Join(flags, " ")
+}
+
+func dataUntil(run storage.ModelRun) string {
+	if run.DataUntil == nil {
+		return "unknown"
+	}
+	return run.DataUntil.Local().Format("2006-01-02 15:04")
+}
+
+var modelsHelp string = `List the most recent retrains (5 by default, up to ` + strconv.Itoa(maxModelsListed) + `) with who ran them, their flags and how the model came out, or compare two of them by number. Accuracy and F1 scores are only known for retrains run with --cm. Usage: ` + config.CommandPrefix + `models [count] | compare <#> <#>`
@@ -0,0 +1,74 @@
+package commands
+
+import (
+	"fmt"
+	"hearsay/internal/config"
+	"hearsay/internal/identity"
+	"hearsay/internal/nlpclient"
+	"hearsay/internal/storage"
+	"log"
+	"strings"
+	"time"
+)
+
+// nlp is how commands reach the NLP API. It starts out with the default
+// configuration; main replaces it once config.yaml has been read.
+var nlp = nlpclient.New(config.APIURL, time.Duration(config.APITimeout)*time.Second,
+	time.Duration(config.APIRetrainTimeout)*time.Second, config.APIRetries)
+
+// UseNLPClient makes commands talk to the NLP API through c.
+func UseNLPClient(c *nlpclient.Client) {
+	nlp = c
+}
+
+// apiFailure logs a failed call to the NLP API and returns the reply for author.
+func apiFailure(author string, command string, err error) string {
+	log.Printf("NLP API call in %s for %s failed: %s\n", command, author, err.Error())
+	if nlpclient.TimedOut(err) {
+		return author + ": The NLP API took too long to answer"
+	}
+
+	return author + ": Failed to fetch results"
+}
+
+// withheld reports whether a label the model knows belongs to someone who has since
+// been purged or opted out. Until a retrain removes them, they are never named.
+// Labels are checked as the model stores them, without the "_" the API appends
+// in its formatted replies.
+func withheld(label string) bool {
+	return !storage.IsOptedIn(identity.Canonical(label))
+}
+
+// formattedLabel returns the label in a nick formatted by the API as "label_".
+func formattedLabel(nick string) (string, bool) {
+	return strings.CutSuffix(nick, "_")
+}
+
+// withholdRemoved drops withheld nicks from a list formatted by the API, such as
+// "nick_, other_" or "nick_ (0.56), other_ (0.09)". Anything else is left alone.
+func withholdRemoved(list string) string {
+	kept := []string{}
+	for _, entry := range strings.Split(list, ", ") {
+		// Labels may end in "_" themselves, so only the last "_ (" is the API's.
+		label, ok := formattedLabel(entry)
+		if i := strings.LastIndex(entry, "_ ("); i >= 0 {
+			label, ok = entry[:i], true
+		}
+		if !ok {
+			return list
+		}
+		if !withheld(label) {
+			kept = append(kept, entry)
+		}
+	}
+	return strings.Join(kept, ", ")
+}
+
+// attributionReply answers author with an attribution, unless it names someone withheld.
+func attributionReply(author string, result nlpclient.Attribution) string {
+	if withheld(result.Author) {
+		return author + ": The most likely author has had their data removed. The model must be retrained before it can attribute this"
+	}
+
+	return fmt.Sprintf("%s: Predicted author: %s_. Confidence scores: %s", author, result.Author, withholdRemoved(result.Confidence))
+}
@@ -0,0 +1,47 @@
+package commands
+
+import (
+	"hearsay/internal/nlpclient"
+	"hearsay/internal/storage"
+	"strings"
+	"testing"
+)
+
+func TestWithholdRemoved(t *testing.T) {
+	storage.SetOptIn("alice", true)
+	storage.SetOptIn("katt_", true)
+	defer storage.SetOptIn("alice", false)
+	defer storage.SetOptIn("katt_", false)
+
+	// katt_ is opted in, katt (without the underscore) is not.
+	tests := []struct {
+		list string
+		want string
+	}{
+		{"alice_, katt__, katt_", "alice_, katt__"},
+		{"alice_ (0.40), katt__ (0.50), katt_ (0.10)", "alice_ (0.40), katt__ (0.50)"},
+		{"bob_ (0.90)", ""},
+		// Not a list of nicks: left as the API wrote it.
+		{"Train the attribution model first", "Train the attribution model first"},
+	}
+	for _, tt := range tests {
+		if got := withholdRemoved(tt.list); got != tt.want {
+			t.Errorf("withholdRemoved(%q) = %q, want %q", tt.list, got, tt.want)
+		}
+	}
+}
+
+func TestAttributionReply(t *testing.T) {
+	storage.SetOptIn("katt_", true)
+	defer storage.SetOptIn("katt_", false)
+
+	reply := attributionReply("alice", nlpclient.Attribution{Author: "katt_", Confidence: "katt__ (0.50), katt_ (0.10)"})
+	if want := "alice: Predicted author: katt__. Confidence scores: katt__ (0.50)"; reply != want {
+		t.Errorf("attributionReply for katt_ = %q, want %q", reply, want)
+	}
+
+	reply = attributionReply("alice", nlpclient.Attribution{Author: "katt", Confidence: "katt_ (0.50)"})
+	if !strings.Contains(reply, "data removed") {
+		t.Errorf("attributionReply for the opted-out katt = %q, want it withheld", reply)
+	}
+}
@@ -1,28 +1,29 @@
 package commands
 
 import (
-	"database/sql"
 	"fmt"
 	"hearsay/internal/config"
+	"hearsay/internal/identity"
+	"hearsay/internal/retrain"
 	"hearsay/internal/storage"
 	"log"
 )
 
-func optHandler(args []string, author string, db *sql.DB) string {
+func optHandler(args []string, author string, store storage.Store) string {
 	opt := map[string]bool{
 		"in":  true,
 		"out": false,
 	}
+	key := identity.Key(author)
 
 	if len(args) == 0 {
 		optReverse := map[bool]string{
 			true:  "in",
 			false: "out",
 		}
-		var optBool bool
-		err := db.QueryRow("SELECT opt FROM users WHERE nick = ?", author).Scan(&optBool)
+		optBool, err := store.OptStatus(key)
 
-		if err == sql.ErrNoRows {
+		if err == storage.ErrNotFound {
 			return author + ": Your nick was not found in the database"
 		} else if err != nil {
 			log.Printf("%s", err.Error())
@@ -36,23 +37,18 @@ func optHandler(args []string, author string, db *sql.DB) string {
 		return author + ": Improper argument(s). See " + config.CommandPrefix + "help opt for usage."
 	}
 
-	res, err := db.Exec("UPDATE users SET opt = ? WHERE nick = ?", opt[args[0]], author)
+	err := store.SetOpt(key, opt[args[0]])
 	if err != nil {
 		log.Printf("Failed updating opt preference: %s\n", err.Error())
 		return author + ": Something went wrong"
 	}
 
-	rA, _ := res.RowsAffected()
-	if rA == 0 {
-		log.Println("In opt command: user not found")
-		return author + ": Your nick was not found in the database"
-	}
-
-	if opt[args[0]] {
-		delete(storage.OptIns, author)
-	} else {
-		storage.OptIns[author] = struct{}{}
+	if !opt[args[0]] && storage.IsOptedIn(key) {
+		if count, err := store.CountMessages(key); err != nil || count > 0 {
+			retrain.Invalidate("someone opted out")
+		}
 	}
+	storage.SetOptIn(key, opt[args[0]])
 	return author + ": You have successfully opted " + args[0] + "."
 }
 
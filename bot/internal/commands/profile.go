@@ -1,86 +1,82 @@
 package commands
 
 import (
-	"bytes"
-	"database/sql"
-	"encoding/json"
+	"context"
 	"fmt"
 	"hearsay/internal/config"
+	"hearsay/internal/identity"
 	"hearsay/internal/storage"
-	"io"
 	"log"
-	"net/http"
+	"strconv"
 	"strings"
 )
 
-var maxProfiles int = 3
-
-func exceedsMaxProfiles(author string, db *sql.DB) bool {
-	var res int
-	err := db.QueryRow("SELECT COUNT(*) FROM profiles WHERE nick = ?", author).Scan(&res)
+func exceedsMaxProfiles(author string, store storage.Store) bool {
+	res, err := store.CountProfiles(identity.Key(author))
 	if err != nil {
 		return true
 	}
 
-	return (res >= maxProfiles)
+	return (res >= config.MaxProfiles)
 }
 
-func profileExists(name string, author string, db *sql.DB) (bool, error) {
-	var res int
-	err := db.QueryRow("SELECT COUNT(*) FROM profiles WHERE nick = ? AND name = ?", author, name).Scan(&res)
+func profileExists(name string, author string, store storage.Store) (bool, error) {
+	exists, err := store.ProfileExists(identity.Key(author), name)
 	if err != nil {
 		return true, err
 	}
 
-	return (res > 0), nil
+	return exists, nil
 }
 
-func listProfile(args []string, author string, db *sql.DB) string {
-	res, err := db.Query("SELECT name, messages FROM profiles WHERE nick = ?", author)
+func listProfile(args []string, author string, store storage.Store) string {
+	profiles, err := store.ListProfiles(identity.Key(author))
 	if err != nil {
 		log.Printf("Failed to query profiles by %s: %s", author, err.Error())
 		return fmt.Sprintf("%s: Failed to fetch results", author)
 	}
-	defer res.Close()
-
-	profileNames := make(map[string]int)
-	for res.Next() {
-		var name string
-		var msg string
-		if err := res.Scan(&name, &msg); err != nil {
-			log.Printf("Failed to scan profiles and messages by %s: %s", author, err.Error())
-			return fmt.Sprintf("%s: Failed to fetch results", author)
-		}
 
-		count := len(strings.Split(msg, "/:MSG/"))
-		profileNames[name] = max(0, count-1)
+	result := fmt.Sprintf("%s: You have %d profiles:", author, len(profiles))
+	for _, profile := range profiles {
+		result += fmt.Sprintf(" %s (%d messages),", profile.Name, profile.Messages)
 	}
+	result = strings.TrimSuffix(strings.TrimSuffix(result, ","), ":")
 
-	result := fmt.Sprintf("%s: You have %d profiles:", author, len(profileNames))
-	for k, v := range profileNames {
-		result += fmt.Sprintf(" %s (%d messages),", k, v)
+	shared, err := store.SharedWith(identity.Key(author))
+	if err != nil {
+		log.Printf("Failed to query profiles shared with %s: %s", author, err.Error())
+		return result
+	}
+	if len(shared) > 0 {
+		result += ". Shared with you:"
+		for _, profile := range shared {
+			result += fmt.Sprintf(" %s/%s (%s, %d messages),", profile.Owner, profile.Name, profile.Permission, profile.Messages)
+		}
+		result = strings.TrimSuffix(result, ",")
 	}
-
-	result = strings.TrimSuffix(result, ",")
 
 	return result
 }
 
-func createProfile(args []string, author string, db *sql.DB) string {
+func createProfile(args []string, author string, store storage.Store) string {
 	if len(args) != 2 {
 		return fmt.Sprintf("%s: Too few or too many arguments supplied. Profile names may not contain spaces", author)
 	}
 
-	if exceedsMaxProfiles(author, db) {
-		return fmt.Sprintf("%s: You have reached the maximum number of profiles allowed (%d). Delete a profile before continuing", author, maxProfiles)
+	if strings.Contains(args[1], "/") {
+		return fmt.Sprintf("%s: Profile names may not contain /", author)
 	}
 
-	exists, _ := profileExists(args[1], author, db)
+	if exceedsMaxProfiles(author, store) {
+		return fmt.Sprintf("%s: You have reached the maximum number of profiles allowed (%d). Delete a profile before continuing", author, config.MaxProfiles)
+	}
+
+	exists, _ := profileExists(args[1], author, store)
 	if exists {
 		return fmt.Sprintf("%s: A profile called %s already exists in your name", author, args[1])
 	}
 
-	_, err := db.Exec("INSERT INTO profiles (nick, name, messages) VALUES (?, ?, \"\")", author, args[1])
+	err := store.CreateProfile(identity.Key(author), args[1])
 	if err != nil {
 		log.Printf("Failed to create profile for %s: %s given %v", author, err.Error(), args)
 		return fmt.Sprintf("%s: Failed to create profile", author)
@@ -89,17 +85,17 @@ func createProfile(args []string, author string, db *sql.DB) string {
 	return fmt.Sprintf("%s: You have created a new profile %s", author, args[1])
 }
 
-func destroyProfile(args []string, author string, db *sql.DB) string {
+func destroyProfile(args []string, author string, store storage.Store) string {
 	if len(args) != 2 {
 		return fmt.Sprintf("%s: Too few or too many arguments supplied. Profile names may not contain spaces", author)
 	}
 
-	exists, _ := profileExists(args[1], author, db)
+	exists, _ := profileExists(args[1], author, store)
 	if !exists {
 		return fmt.Sprintf("%s: No profile called %s exists in your name", author, args[1])
 	}
 
-	_, err := db.Exec("DELETE FROM profiles WHERE nick = ? AND name = ?", author, args[1])
+	err := store.DeleteProfile(identity.Key(author), args[1])
 	if err != nil {
 		log.Printf("Failed to delete profile for %s: %s given %v", author, err.Error(), args)
 		return fmt.Sprintf("%s: Failed to delete profile", author)
@@ -108,18 +104,29 @@ func destroyProfile(args []string, author string, db *sql.DB) string {
 	return fmt.Sprintf("%s: You have deleted the profile %s", author, args[1])
 }
 
-func appendProfile(args []string, author string, db *sql.DB) string {
+func appendProfile(args []string, author string, store storage.Store) string {
 	if len(args) < 3 {
 		return fmt.Sprintf("%s: Too few arguments supplied", author)
 	}
 
-	exists, _ := profileExists(args[1], author, db)
-	if !exists {
-		return fmt.Sprintf("%s: No profile called %s exists in your name", author, args[1])
+	owner, name, reply := resolveProfile(args[1], author, storage.PermissionAppend, store)
+	if reply != "" {
+		return reply
 	}
 
-	_, err := db.Exec("UPDATE profiles SET messages = messages || '/:MSG/' || ? WHERE nick = ? AND name = ?", strings.Join(args[2:], " "), author, args[1])
+	count, err := store.CountProfileMessages(owner, name)
 	if err != nil {
+		log.Printf("Failed to count profile messages for %s: %s given %v", author, err.Error(), args)
+		return fmt.Sprintf("%s: Failed to append message to profile", author)
+	}
+	if count >= config.MaxProfileMessages {
+		return fmt.Sprintf("%s: %s has reached the maximum number of messages allowed (%d). Remove a message before continuing", author, args[1], config.MaxProfileMessages)
+	}
+
+	err = store.AppendProfile(owner, name, identity.Key(author), strings.Join(args[2:], " "))
+	if err == storage.ErrNotFound {
+		return fmt.Sprintf("%s: No profile called %s exists in your name", author, args[1])
+	} else if err != nil {
 		log.Printf("Failed to append message to profile for %s: %s given %v", author, err.Error(), args)
 		return fmt.Sprintf("%s: Failed to append message to profile", author)
 	}
@@ -127,65 +134,154 @@ func appendProfile(args []string, author string, db *sql.DB) string {
 	return ""
 }
 
-func getMessagesFromProfile(name string, author string, db *sql.DB) (string, error) {
-	var messages string
-	err := db.QueryRow("SELECT messages FROM profiles WHERE nick = ? AND name = ?", author, name).Scan(&messages)
+func showProfile(args []string, author string, store storage.Store) string {
+	if len(args) != 2 {
+		return fmt.Sprintf("%s: Too few or too many arguments supplied. Profile names may not contain spaces", author)
+	}
+
+	owner, name, reply := resolveProfile(args[1], author, storage.PermissionRead, store)
+	if reply != "" {
+		return reply
+	}
+
+	messages, err := store.ProfileMessages(owner, name)
+	if err == storage.ErrNotFound {
+		return fmt.Sprintf("%s: No profile called %s exists in your name", author, args[1])
+	} else if err != nil {
+		log.Printf("Failed to query profile messages by %s: %s given %v", author, err.Error(), args)
+		return fmt.Sprintf("%s: Failed to fetch results", author)
+	}
+
+	result := fmt.Sprintf("%s: %s has %d messages:", author, args[1], len(messages))
+	for _, message := range messages {
+		result += fmt.Sprintf(" [%d] %s", message.ID, message.Message)
+		// Collaborative profiles credit whoever appended each message.
+		if message.Author != "" && message.Author != owner {
+			result += fmt.Sprintf(" (%s)", message.Author)
+		}
+	}
+
+	return result
+}
+
+func removeFromProfile(args []string, author string, store storage.Store) string {
+	if len(args) != 3 {
+		return fmt.Sprintf("%s: Too few or too many arguments supplied. Usage: %sprofile remove <name> <id>", author, config.CommandPrefix)
+	}
+
+	id, err := strconv.ParseInt(args[2], 10, 64)
 	if err != nil {
-		log.Printf("Failed to query profiles by %s: %s", author, err.Error())
-		return "", err
+		return fmt.Sprintf("%s: %s is not a message ID. See %sprofile show %s", author, args[2], config.CommandPrefix, args[1])
+	}
+
+	owner, name, reply := resolveProfile(args[1], author, storage.PermissionAppend, store)
+	if reply != "" {
+		return reply
+	}
+	if owner != identity.Key(author) && !appendedBy(owner, name, id, identity.Key(author), store) {
+		return fmt.Sprintf("%s: You can only remove messages you appended to %s", author, args[1])
 	}
 
-	return messages, nil
+	err = store.RemoveProfileMessage(owner, name, id)
+	if err == storage.ErrNotFound {
+		return fmt.Sprintf("%s: Your profile %s has no message with ID %d", author, args[1], id)
+	} else if err != nil {
+		log.Printf("Failed to remove profile message for %s: %s given %v", author, err.Error(), args)
+		return fmt.Sprintf("%s: Failed to remove message from profile", author)
+	}
+
+	return fmt.Sprintf("%s: Removed message %d from %s", author, id, args[1])
 }
 
-func attributeProfile(args []string, author string, db *sql.DB) string {
+func renameProfile(args []string, author string, store storage.Store) string {
+	if len(args) != 3 {
+		return fmt.Sprintf("%s: Too few or too many arguments supplied. Profile names may not contain spaces", author)
+	}
+
+	if strings.Contains(args[2], "/") {
+		return fmt.Sprintf("%s: Profile names may not contain /", author)
+	}
+
+	exists, _ := profileExists(args[2], author, store)
+	if exists {
+		return fmt.Sprintf("%s: A profile called %s already exists in your name", author, args[2])
+	}
+
+	err := store.RenameProfile(identity.Key(author), args[1], args[2])
+	if err == storage.ErrNotFound {
+		return fmt.Sprintf("%s: No profile called %s exists in your name", author, args[1])
+	} else if err != nil {
+		log.Printf("Failed to rename profile for %s: %s given %v", author, err.Error(), args)
+		return fmt.Sprintf("%s: Failed to rename profile", author)
+	}
+
+	return fmt.Sprintf("%s: Renamed %s to %s", author, args[1], args[2])
+}
+
+func clearProfile(args []string, author string, store storage.Store) string {
 	if len(args) != 2 {
 		return fmt.Sprintf("%s: Too few or too many arguments supplied. Profile names may not contain spaces", author)
 	}
 
-	msg, err := getMessagesFromProfile(args[1], author, db)
-	body := map[string]interface{}{
-		"msg":          msg,
-		"min_messages": config.MessageQuota,
-		"confidence":   true,
+	exists, _ := profileExists(args[1], author, store)
+	if !exists {
+		return fmt.Sprintf("%s: No profile called %s exists in your name", author, args[1])
 	}
-	postJson, err := json.Marshal(body)
+
+	removed, err := store.ClearProfile(identity.Key(author), args[1])
 	if err != nil {
-		log.Printf("Failed to marshal POST request in profile attribute by %s: %s\n", author, err.Error())
-		return author + ": Failed to fetch results"
+		log.Printf("Failed to clear profile for %s: %s given %v", author, err.Error(), args)
+		return fmt.Sprintf("%s: Failed to clear profile", author)
 	}
 
-	req, err := http.NewRequest(http.MethodPost, "http://api:8111/profile_attribute", bytes.NewBuffer(postJson))
+	return fmt.Sprintf("%s: Removed %d messages from %s", author, removed, args[1])
+}
+
+func getMessagesFromProfile(owner string, name string, author string, store storage.Store) ([]string, error) {
+	messages, err := store.ProfileMessages(owner, name)
 	if err != nil {
-		log.Printf("Failed to get profile attribute URL for %s: %s\n", author, err.Error())
-		return author + ": Failed to fetch results"
+		log.Printf("Failed to query profiles by %s: %s", author, err.Error())
+		return nil, err
 	}
-	req.Header.Set("Content-Type", "application/json")
 
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		log.Printf("Failed to send POST request in profile attribute for %s: %s\n", author, err.Error())
-		return author + ": Failed to fetch results"
+	texts := make([]string, len(messages))
+	for i, message := range messages {
+		texts[i] = message.Message
 	}
 
-	resBody, err := io.ReadAll(res.Body)
-	if err != nil {
-		log.Printf("Failed to read response body in profile attribute for %s: %s\n", author, err.Error())
+	return texts, nil
+}
+
+func attributeProfile(args []string, author string, store storage.Store) string {
+	if len(args) != 2 {
+		return fmt.Sprintf("%s: Too few or too many arguments supplied. Profile names may not contain spaces", author)
+	}
+
+	owner, name, reply := resolveProfile(args[1], author, storage.PermissionRead, store)
+	if reply != "" {
+		return reply
+	}
+
+	messages, err := getMessagesFromProfile(owner, name, author, store)
+	if err == storage.ErrNotFound {
+		return fmt.Sprintf("%s: No profile called %s exists in your name", author, args[1])
+	} else if err != nil {
 		return author + ": Failed to fetch results"
 	}
+	if len(messages) == 0 {
+		return fmt.Sprintf("%s: The profile %s has no messages", author, args[1])
+	}
 
-	var result attributeResponse
-	err = json.Unmarshal(resBody, &result)
+	result, err := nlp.ProfileAttribute(context.Background(), messages, config.MessageQuota)
 	if err != nil {
-		log.Printf("Failed to unmarshal response body in profile attribute for %s: %s\n", author, err.Error())
-		return author + ": Failed to fetch results"
+		return apiFailure(author, "profile attribute", err)
 	}
 
-	return fmt.Sprintf("%s: Predicted author: %s_. Confidence scores: %s", author, result.Author, result.ConfidenceScore)
+	return attributionReply(author, result)
 }
 
-func profileHandler(args []string, author string, db *sql.DB) string {
-	if !storage.IsOptedIn(author) {
+func profileHandler(args []string, author string, store storage.Store) string {
+	if !storage.IsOptedIn(identity.Key(author)) {
 		return fmt.Sprintf("%s: You must be opted in to use this command. %shelp opt", author, config.CommandPrefix)
 	}
 
@@ -193,19 +289,27 @@ func profileHandler(args []string, author string, db *sql.DB) string {
 		return fmt.Sprintf("%s: No arguments supplied. See %shelp profile", author, config.CommandPrefix)
 	}
 
-	type fn func([]string, string, *sql.DB) string
+	type fn func([]string, string, storage.Store) string
 	argumentFuncMap := map[string]fn{
 		"list":      listProfile,
 		"create":    createProfile,
 		"destroy":   destroyProfile,
 		"append":    appendProfile,
+		"show":      showProfile,
+		"remove":    removeFromProfile,
+		"rename":    renameProfile,
+		"clear":     clearProfile,
+		"share":     shareProfile,
+		"unshare":   unshareProfile,
+		"recent":    recentLines,
+		"grab":      grabProfile,
 		"attribute": attributeProfile,
 	}
 	if profileFunction, ok := argumentFuncMap[args[0]]; ok {
-		return profileFunction(args, author, db)
+		return profileFunction(args, author, store)
 	}
 
 	return fmt.Sprintf("%s: Invalid argument: %s. See %shelp profile.", author, args[0], config.CommandPrefix)
 }
 
-var profileHelp string = `Build author profiles that provide higher attribution accuracy. Usage: ` + config.CommandPrefix + `profile (attribute|create|destroy) <name> | append <name> <message> | list`
+var profileHelp string = `Build author profiles that provide higher attribution accuracy. Appending, showing and listing recent lines must be done in a private message so the profile text isn't broadcast. Profiles shared with you are named owner/name. Usage: ` + config.CommandPrefix + `profile (attribute|create|destroy|show|clear) <name> | append <name> <message> | remove <name> <id> | rename <name> <new name> | share <name> [<nick> [read|append]] | unshare <name> <nick> | recent [nick] [count] | grab <name> (<nick> [count] | <id> [<id> ...]) | list`
@@ -0,0 +1,108 @@
+package commands
+
+import (
+	"fmt"
+	"hearsay/internal/config"
+	"hearsay/internal/identity"
+	"hearsay/internal/recent"
+	"hearsay/internal/storage"
+	"log"
+	"strconv"
+)
+
+const maxRecentLines = 20
+
+func recentLines(args []string, author string, store storage.Store) string {
+	if len(args) > 3 {
+		return fmt.Sprintf("%s: Too many arguments supplied. Usage: %sprofile recent [nick] [count]", author, config.CommandPrefix)
+	}
+
+	nick := ""
+	count := 10
+	for _, arg := range args[1:] {
+		if n, err := strconv.Atoi(arg); err == nil {
+			count = min(max(n, 1), maxRecentLines)
+		} else {
+			nick = arg
+		}
+	}
+
+	// Only lines from channels the author is in with the bot can be seen.
+	lines := recent.Latest(nick, count, identity.Channels(author))
+	if len(lines) == 0 {
+		return fmt.Sprintf("%s: No recent lines", author)
+	}
+
+	result := fmt.Sprintf("%s: Recent lines:", author)
+	for _, line := range lines {
+		result += fmt.Sprintf(" [%d] %s <%s> %s", line.ID, line.Channel, line.Nick, line.Content)
+	}
+
+	return result
+}
+
+func grabProfile(args []string, author string, store storage.Store) string {
+	if len(args) < 3 {
+		return fmt.Sprintf("%s: Too few arguments supplied. Usage: %sprofile grab <name> (<nick> [count] | <id> [<id> ...])", author, config.CommandPrefix)
+	}
+
+	owner, name, reply := resolveProfile(args[1], author, storage.PermissionAppend, store)
+	if reply != "" {
+		return reply
+	}
+
+	channels := identity.Channels(author)
+
+	// Nicks cannot start with a digit, so a number is always a line ID.
+	var lines []recent.Line
+	if _, err := strconv.ParseUint(args[2], 10, 64); err == nil {
+		for _, arg := range args[2:] {
+			id, err := strconv.ParseUint(arg, 10, 64)
+			if err != nil {
+				return fmt.Sprintf("%s: %s is not a line ID. See %sprofile recent", author, arg, config.CommandPrefix)
+			}
+			line, ok := recent.Get(id)
+			if !ok || !recent.In(line, channels) {
+				return fmt.Sprintf("%s: Line %d is not in the recent buffer (anymore)", author, id)
+			}
+			lines = append(lines, line)
+		}
+	} else {
+		if len(args) > 4 {
+			return fmt.Sprintf("%s: Too many arguments supplied. Usage: %sprofile grab <name> <nick> [count]", author, config.CommandPrefix)
+		}
+		count := 1
+		if len(args) == 4 {
+			n, err := strconv.Atoi(args[3])
+			if err != nil || n < 1 {
+				return fmt.Sprintf("%s: %s is not a valid count", author, args[3])
+			}
+			count = n
+		}
+		lines = recent.Latest(args[2], count, channels)
+		if len(lines) == 0 {
+			return fmt.Sprintf("%s: No recent lines from %s", author, args[2])
+		}
+	}
+
+	existing, err := store.CountProfileMessages(owner, name)
+	if err != nil {
+		log.Printf("Failed to count profile messages for %s: %s given %v", author, err.Error(), args)
+		return fmt.Sprintf("%s: Failed to append message to profile", author)
+	}
+	if existing+len(lines) > config.MaxProfileMessages {
+		return fmt.Sprintf("%s: %s can only hold %d more messages (maximum %d)", author, args[1], max(config.MaxProfileMessages-existing, 0), config.MaxProfileMessages)
+	}
+
+	for _, line := range lines {
+		err := store.AppendProfile(owner, name, identity.Key(author), line.Content)
+		if err == storage.ErrNotFound {
+			return fmt.Sprintf("%s: No profile called %s exists in your name", author, args[1])
+		} else if err != nil {
+			log.Printf("Failed to grab line into profile for %s: %s given %v", author, err.Error(), args)
+			return fmt.Sprintf("%s: Failed to append message to profile", author)
+		}
+	}
+
+	return fmt.Sprintf("%s: Added %d lines to %s", author, len(lines), args[1])
+}
@@ -0,0 +1,131 @@
+package commands
+
+import (
+	"fmt"
+	"hearsay/internal/config"
+	"hearsay/internal/identity"
+	"hearsay/internal/storage"
+	"log"
+	"strings"
+)
+
+// resolveProfile maps a profile reference to its owner and name. Your own profiles
+// are referred to by name, profiles shared with you as owner/name. reply is set
+// when author may not use the profile with the wanted permission.
+func resolveProfile(ref string, author string, want storage.Permission, store storage.Store) (string, string, string) {
+	authorKey := identity.Key(author)
+	ownerNick, name, shared := strings.Cut(ref, "/")
+	if !shared {
+		return authorKey, ref, ""
+	}
+
+	// Owners are named by the key they were shared under, not by a nick to look up.
+	owner := identity.Canonical(ownerNick)
+	if owner == authorKey {
+		return owner, name, ""
+	}
+
+	permission, err := store.ProfilePermission(owner, name, authorKey)
+	if err != nil {
+		log.Printf("Failed to query permission on %s for %s: %s", ref, author, err.Error())
+		return "", "", fmt.Sprintf("%s: Failed to fetch results", author)
+	}
+	if !permission.Allows(want) {
+		if permission.Allows(storage.PermissionRead) {
+			return "", "", fmt.Sprintf("%s: %s is shared with you read-only", author, ref)
+		}
+		return "", "", fmt.Sprintf("%s: No profile called %s is shared with you", author, ref)
+	}
+
+	return owner, name, ""
+}
+
+// appendedBy reports whether message id of a profile was appended by key.
+func appendedBy(owner string, name string, id int64, key string, store storage.Store) bool {
+	messages, err := store.ProfileMessages(owner, name)
+	if err != nil {
+		return false
+	}
+	for _, message := range messages {
+		if message.ID == id {
+			return message.Author == key
+		}
+	}
+
+	return false
+}
+
+func listShares(name string, author string, store storage.Store) string {
+	shares, err := store.ListShares(identity.Key(author), name)
+	if err != nil {
+		log.Printf("Failed to query shares of %s by %s: %s", name, author, err.Error())
+		return fmt.Sprintf("%s: Failed to fetch results", author)
+	}
+	if len(shares) == 0 {
+		return fmt.Sprintf("%s: %s is not shared with anyone", author, name)
+	}
+
+	result := fmt.Sprintf("%s: %s is shared with:", author, name)
+	for _, share := range shares {
+		result += fmt.Sprintf(" %s (%s),", share.Key, share.Permission)
+	}
+
+	return strings.TrimSuffix(result, ",")
+}
+
+func shareProfile(args []string, author string, store storage.Store) string {
+	if len(args) < 2 || len(args) > 4 {
+		return fmt.Sprintf("%s: Too few or too many arguments supplied. Usage: %sprofile share <name> [<nick> [read|append]]", author, config.CommandPrefix)
+	}
+
+	exists, _ := profileExists(args[1], author, store)
+	if !exists {
+		return fmt.Sprintf("%s: No profile called %s exists in your name", author, args[1])
+	}
+	if len(args) == 2 {
+		return listShares(args[1], author, store)
+	}
+
+	permission := storage.PermissionRead
+	if len(args) == 4 {
+		permission = storage.Permission(strings.ToLower(args[3]))
+		if permission != storage.PermissionRead && permission != storage.PermissionAppend {
+			return fmt.Sprintf("%s: Permission must be read or append", author)
+		}
+	}
+
+	target := identity.Key(args[2])
+	if target == identity.Key(author) {
+		return fmt.Sprintf("%s: You already own %s", author, args[1])
+	}
+	if !storage.IsOptedIn(target) {
+		return fmt.Sprintf("%s: %s must be opted in to use profiles", author, args[2])
+	}
+
+	err := store.ShareProfile(identity.Key(author), args[1], target, permission)
+	if err == storage.ErrNotFound {
+		return fmt.Sprintf("%s: No such user: %s", author, args[2])
+	}
+	if err != nil {
+		log.Printf("Failed to share profile for %s: %s given %v", author, err.Error(), args)
+		return fmt.Sprintf("%s: Failed to share profile", author)
+	}
+
+	return fmt.Sprintf("%s: Shared %s with %s (%s). They can refer to it as %s/%s", author, args[1], args[2], permission, identity.Key(author), args[1])
+}
+
+func unshareProfile(args []string, author string, store storage.Store) string {
+	if len(args) != 3 {
+		return fmt.Sprintf("%s: Too few or too many arguments supplied. Usage: %sprofile unshare <name> <nick>", author, config.CommandPrefix)
+	}
+
+	err := store.UnshareProfile(identity.Key(author), args[1], identity.Key(args[2]))
+	if err == storage.ErrNotFound {
+		return fmt.Sprintf("%s: %s is not shared with %s", author, args[1], args[2])
+	} else if err != nil {
+		log.Printf("Failed to unshare profile for %s: %s given %v", author, err.Error(), args)
+		return fmt.Sprintf("%s: Failed to unshare profile", author)
+	}
+
+	return fmt.Sprintf("%s: %s is no longer shared with %s", author, args[1], args[2])
+}
@@ -1,20 +1,13 @@
 package commands
 
 import (
-	"database/sql"
-	"encoding/json"
+	"context"
 	"fmt"
 	"hearsay/internal/config"
+	"hearsay/internal/identity"
 	"hearsay/internal/storage"
-	"io"
-	"log"
-	"net/http"
 )
 
-type scoreResponse struct {
-	Score float64 `json:"score"`
-}
-
 func scoreClass(score float64) string {
 	switch {
 	case 90.0 <= score:
@@ -38,42 +31,23 @@ func scoreClass(score float64) string {
 	return "Unknown."
 }
 
-func readabilityHandler(args []string, author string, db *sql.DB) string {
-	if !storage.IsOptedIn(author) {
+func readabilityHandler(args []string, author string, store storage.Store) string {
+	if !storage.IsOptedIn(identity.Key(author)) {
 		return fmt.Sprintf("%s: You must be opted in to use this command. %shelp opt", author, config.CommandPrefix)
 	}
 
-	fulfil, count := storage.FulfilsMessagesCount(author, config.MessageQuota, db)
+	key := identity.Key(author)
+	fulfil, count := storage.FulfilsMessagesCount(key, config.MessageQuota, store)
 	if !fulfil {
 		return fmt.Sprintf("%s: You have too few messages stored to use this command (%d/%d required)", author, count, config.MessageQuota)
 	}
 
-	url := fmt.Sprintf("http://api:8111/readability?nick=%s", author)
-	req, err := http.NewRequest(http.MethodGet, url, nil)
-	if err != nil {
-		log.Printf("Failed to get readability URL for %s: %s\n", author, err.Error())
-		return author + ": Failed to fetch results"
-	}
-
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		log.Printf("Failed to send GET request in readability for %s: %s\n", author, err.Error())
-		return author + ": Failed to fetch results"
-	}
-
-	resBody, err := io.ReadAll(res.Body)
-	if err != nil {
-		log.Printf("Failed to read response body in readability for %s: %s\n", author, err.Error())
-		return author + ": Failed to fetch results"
-	}
-	var result scoreResponse
-	err = json.Unmarshal(resBody, &result)
+	score, err := nlp.Readability(context.Background(), key)
 	if err != nil {
-		log.Printf("Failed to unmarshal response body in readability for %s: %s\n", author, err.Error())
-		return author + ": Failed to fetch results"
+		return apiFailure(author, "readability", err)
 	}
 
-	return fmt.Sprintf("%s: You have a Flesch-Kincaid score of %.2f (%s)", author, result.Score, scoreClass(result.Score))
+	return fmt.Sprintf("%s: You have a Flesch-Kincaid score of %.2f (%s)", author, score, scoreClass(score))
 }
 
 var readabilityHelp string = `Calculate the Flesch-Kincaid readability score of your messages (10,000 limit). Usage: ` + config.CommandPrefix + `readability`
@@ -1,23 +1,36 @@
 package commands
 
 import (
-	"database/sql"
+	"fmt"
 	"hearsay/internal/config"
+	"hearsay/internal/identity"
+	"hearsay/internal/storage"
 	"log"
 )
 
-func unforgetHandler(args []string, author string, db *sql.DB) string {
-	res, err := db.Exec("UPDATE users SET deletion = NULL WHERE nick = ? AND deletion IS NOT NULL", author)
+func unforgetHandler(args []string, author string, store storage.Store) string {
+	key := identity.Key(author)
+	cancelled, err := store.CancelDeletion(key)
 	if err != nil {
 		log.Printf("Failed to serve unforget request for nick %s: %s\n", author, err.Error())
 		return author + ": The requested action was met with an error."
 	}
+	forgets, err := store.CancelForgets(key)
+	if err != nil {
+		log.Printf("Failed to cancel selective deletions for nick %s: %s\n", author, err.Error())
+		return author + ": The requested action was met with an error."
+	}
 
-	if rA, _ := res.RowsAffected(); rA == 0 {
+	switch {
+	case !cancelled && forgets == 0:
 		return author + ": You have no deletion scheduled or were not found in the database."
+	case forgets == 0:
+		return author + ": You have successfully cancelled your deletion request."
+	case !cancelled:
+		return fmt.Sprintf("%s: You have successfully cancelled %d selective deletion request(s).", author, forgets)
 	}
 
-	return author + ": You have successfully cancelled your deletion request."
+	return fmt.Sprintf("%s: You have successfully cancelled your deletion request and %d selective deletion request(s).", author, forgets)
 }
 
-var unforgetHelp string = `Cancel a scheduled data deletion. Usage: ` + config.CommandPrefix + `unforget`
+var unforgetHelp string = `Cancel your scheduled data deletions, full or selective. Usage: ` + config.CommandPrefix + `unforget`
@@ -14,8 +14,43 @@ var CommandPrefix = "+"
 var BotMode = "+B"
 var Server = "irc.zoite.net:6697"
 var Channel = "#antisocial"
+var ReconnectMin = 5
+var ReconnectMax = 300
+var BotNick = "hearsay"
+var BotUsername = "hearsay"
+var BotRealname = "hearsay"
+var AltNicks []string
+var AuthMethod = ""
+var AuthAccount = ""
+var AuthPassword = ""
+var AuthCert = ""
+var AuthKey = ""
+var NickServFallback = false
+var RequireAccount = false
+var Admins []string
+var StorageDriver = "sqlite3"
+var StorageDSN = "data/database.db"
 var MaxMessagePool = 20
+var FlushInterval = 60
+var IngestQueueSize = 1000
+var SpoolPath = "data/spool.jsonl"
 var DeletionDays = 1
+var AutoRetrain = false
+var AutoRetrainMessages = 500
+var AutoRetrainWindow = ""
+var AutoRetrainAnnounce = ""
+var MaxProfiles = 3
+var MaxProfileMessages = 200
+var RecentBufferSize = 500
+var RecentWindow = 1800
+var ExportListen = ""
+var ExportURL = ""
+var ExportTTL = 900
+var ExportDir = "data/exports"
+var APIURL = "http://api:8111"
+var APITimeout = 30
+var APIRetrainTimeout = 1800
+var APIRetries = 2
 var MessageQuota = 400
 var PeopleQuota = 5
 var Bert = true
@@ -26,16 +61,77 @@ type BotStruct struct {
 	Mode    string `yaml:"mode"`
 	Server  string `yaml:"server"`
 	Channel string `yaml:"channel"`
+
+	ReconnectMin int `yaml:"reconnect_min"`
+	ReconnectMax int `yaml:"reconnect_max"`
+
+	Nick     string     `yaml:"nick"`
+	Username string     `yaml:"username"`
+	Realname string     `yaml:"realname"`
+	AltNicks []string   `yaml:"alt_nicks"`
+	Auth     AuthStruct `yaml:"auth"`
+
+	RequireAccount bool `yaml:"require_account"`
+	// Admins are the nicks told when the model must be retrained for compliance.
+	Admins []string `yaml:"admins"`
+}
+
+// Method is one of "sasl_plain", "sasl_external", "nickserv" or empty for no authentication.
+type AuthStruct struct {
+	Method           string `yaml:"method"`
+	Account          string `yaml:"account"`
+	Password         string `yaml:"password" secret:"true"`
+	Cert             string `yaml:"cert"`
+	Key              string `yaml:"key"`
+	NickServFallback bool   `yaml:"nickserv_fallback"`
 }
 
 type StorageStruct struct {
-	MessagePoolSize int `yaml:"message_pool_size"`
-	MessageQuota    int `yaml:"message_quota"`
-	PeopleQuota     int `yaml:"people_quota"`
+	Driver          string `yaml:"driver"`
+	DSN             string `yaml:"dsn" secret:"true"`
+	MessagePoolSize int    `yaml:"message_pool_size"`
+	FlushInterval   int    `yaml:"flush_interval"`
+	IngestQueueSize int    `yaml:"ingest_queue_size"`
+	SpoolPath       string `yaml:"spool_path"`
+	MessageQuota    int    `yaml:"message_quota"`
+	PeopleQuota     int    `yaml:"people_quota"`
+}
+
+type ProfilesStruct struct {
+	MaxPerUser   int `yaml:"max_per_user"`
+	MaxMessages  int `yaml:"max_messages"`
+	BufferSize   int `yaml:"buffer_size"`
+	BufferWindow int `yaml:"buffer_window"`
+}
+
+// The export server is only started when Listen is set; URL is how users reach it.
+type ExportStruct struct {
+	Listen string `yaml:"listen"`
+	URL    string `yaml:"url"`
+	TTL    int    `yaml:"ttl"`
+	Dir    string `yaml:"dir"`
+}
+
+// Timeouts are in seconds. Retries only apply to calls that are safe to repeat.
+type APIStruct struct {
+	URL            string `yaml:"url"`
+	Timeout        int    `yaml:"timeout"`
+	RetrainTimeout int    `yaml:"retrain_timeout"`
+	Retries        *int   `yaml:"retries"`
 }
 
 type SchedulerStruct struct {
-	DeletionDays int `yaml:"deletion_days"`
+	DeletionDays int               `yaml:"deletion_days"`
+	AutoRetrain  AutoRetrainStruct `yaml:"auto_retrain"`
+}
+
+// QuietWindow is the "HH:MM-HH:MM" span of local time retrains may start in;
+// Announce is the channel their results are posted to.
+type AutoRetrainStruct struct {
+	Enabled     bool   `yaml:"enabled"`
+	NewMessages *int   `yaml:"new_messages"`
+	QuietWindow string `yaml:"quiet_window"`
+	Announce    string `yaml:"announce"`
 }
 
 type ModelStruct struct {
@@ -46,6 +142,9 @@ type ModelStruct struct {
 type ConfigStruct struct {
 	Bot       BotStruct       `yaml:"bot"`
 	Storage   StorageStruct   `yaml:"storage"`
+	Profiles  ProfilesStruct  `yaml:"profiles"`
+	Export    ExportStruct    `yaml:"export"`
+	API       APIStruct       `yaml:"api"`
 	Scheduler SchedulerStruct `yaml:"scheduler"`
 	Model     ModelStruct     `yaml:"model"`
 }
@@ -62,9 +161,14 @@ func List(v interface{}) {
 	for i := 0; i < value.NumField(); i++ {
 		field := typeR.Field(i)
 		val := value.Field(i)
+		if val.Kind() == reflect.Pointer && !val.IsNil() {
+			val = val.Elem()
+		}
 
 		if val.Kind() == reflect.Struct {
 			List(val.Interface())
+		} else if field.Tag.Get("secret") == "true" && !val.IsZero() {
+			fmt.Printf("%s: ********\n", field.Name)
 		} else {
 			fmt.Printf("%s: %v\n", field.Name, val.Interface())
 		}
@@ -97,10 +201,79 @@ func ReadConfig(path string, verbose bool) error {
 	if cfg.Bot.Channel != "" {
 		Channel = cfg.Bot.Channel
 	}
+	if cfg.Bot.ReconnectMin > 0 {
+		ReconnectMin = cfg.Bot.ReconnectMin
+	}
+	if cfg.Bot.ReconnectMax > 0 {
+		ReconnectMax = cfg.Bot.ReconnectMax
+	}
+	if ReconnectMax < ReconnectMin {
+		ReconnectMax = ReconnectMin
+	}
+	if cfg.Bot.Nick != "" {
+		BotNick = cfg.Bot.Nick
+	}
+	if cfg.Bot.Username != "" {
+		BotUsername = cfg.Bot.Username
+	}
+	if cfg.Bot.Realname != "" {
+		BotRealname = cfg.Bot.Realname
+	}
+	AltNicks = cfg.Bot.AltNicks
+	RequireAccount = cfg.Bot.RequireAccount
+	Admins = cfg.Bot.Admins
+
+	switch cfg.Bot.Auth.Method {
+	case "", "sasl_plain", "sasl_external", "nickserv":
+		AuthMethod = cfg.Bot.Auth.Method
+	default:
+		err = fmt.Errorf("unknown auth method %q", cfg.Bot.Auth.Method)
+		log.Printf("Invalid bot.auth section: %s\n", err)
+		return err
+	}
+	AuthAccount = cfg.Bot.Auth.Account
+	if AuthAccount == "" {
+		AuthAccount = BotNick
+	}
+	AuthPassword = cfg.Bot.Auth.Password
+	AuthCert = cfg.Bot.Auth.Cert
+	AuthKey = cfg.Bot.Auth.Key
+	NickServFallback = cfg.Bot.Auth.NickServFallback
+	if AuthMethod == "sasl_external" && (AuthCert == "" || AuthKey == "") {
+		err = fmt.Errorf("sasl_external requires both cert and key")
+		log.Printf("Invalid bot.auth section: %s\n", err)
+		return err
+	}
+
+	switch cfg.Storage.Driver {
+	case "":
+	case "sqlite3", "postgres":
+		StorageDriver = cfg.Storage.Driver
+	default:
+		err = fmt.Errorf("unknown storage driver %q", cfg.Storage.Driver)
+		log.Printf("Invalid storage section: %s\n", err)
+		return err
+	}
+	if cfg.Storage.DSN != "" {
+		StorageDSN = cfg.Storage.DSN
+	} else if StorageDriver == "postgres" {
+		err = fmt.Errorf("the postgres driver requires a dsn")
+		log.Printf("Invalid storage section: %s\n", err)
+		return err
+	}
 
 	if cfg.Storage.MessagePoolSize > 0 {
 		MaxMessagePool = cfg.Storage.MessagePoolSize
 	}
+	if cfg.Storage.FlushInterval > 0 {
+		FlushInterval = cfg.Storage.FlushInterval
+	}
+	if cfg.Storage.IngestQueueSize > 0 {
+		IngestQueueSize = cfg.Storage.IngestQueueSize
+	}
+	if cfg.Storage.SpoolPath != "" {
+		SpoolPath = cfg.Storage.SpoolPath
+	}
 	if cfg.Storage.MessageQuota > 0 {
 		MessageQuota = cfg.Storage.MessageQuota
 	}
@@ -108,9 +281,65 @@ func ReadConfig(path string, verbose bool) error {
 		PeopleQuota = cfg.Storage.PeopleQuota
 	}
 
+	if cfg.Profiles.MaxPerUser > 0 {
+		MaxProfiles = cfg.Profiles.MaxPerUser
+	}
+	if cfg.Profiles.MaxMessages > 0 {
+		MaxProfileMessages = cfg.Profiles.MaxMessages
+	}
+	if cfg.Profiles.BufferSize > 0 {
+		RecentBufferSize = cfg.Profiles.BufferSize
+	}
+	if cfg.Profiles.BufferWindow > 0 {
+		RecentWindow = cfg.Profiles.BufferWindow
+	}
+
+	ExportListen = cfg.Export.Listen
+	ExportURL = cfg.Export.URL
+	if ExportListen != "" && ExportURL == "" {
+		err = fmt.Errorf("url is required when listen is set")
+		log.Printf("Invalid export section: %s\n", err)
+		return err
+	}
+	if cfg.Export.TTL > 0 {
+		ExportTTL = cfg.Export.TTL
+	}
+	if cfg.Export.Dir != "" {
+		ExportDir = cfg.Export.Dir
+	}
+
+	if cfg.API.URL != "" {
+		APIURL = cfg.API.URL
+	}
+	if cfg.API.Timeout > 0 {
+		APITimeout = cfg.API.Timeout
+	}
+	if cfg.API.RetrainTimeout > 0 {
+		APIRetrainTimeout = cfg.API.RetrainTimeout
+	}
+	if cfg.API.Retries != nil {
+		if *cfg.API.Retries < 0 {
+			err = fmt.Errorf("retries cannot be negative")
+			log.Printf("Invalid api section: %s\n", err)
+			return err
+		}
+		APIRetries = *cfg.API.Retries
+	}
+
 	if cfg.Scheduler.DeletionDays > 0 {
 		DeletionDays = cfg.Scheduler.DeletionDays
 	}
+	AutoRetrain = cfg.Scheduler.AutoRetrain.Enabled
+	if cfg.Scheduler.AutoRetrain.NewMessages != nil {
+		if *cfg.Scheduler.AutoRetrain.NewMessages < 0 {
+			err = fmt.Errorf("new_messages cannot be negative")
+			log.Printf("Invalid scheduler section: %s\n", err)
+			return err
+		}
+		AutoRetrainMessages = *cfg.Scheduler.AutoRetrain.NewMessages
+	}
+	AutoRetrainWindow = cfg.Scheduler.AutoRetrain.QuietWindow
+	AutoRetrainAnnounce = cfg.Scheduler.AutoRetrain.Announce
 
 	Bert = cfg.Model.Bert
 	GPU = cfg.Model.GPU
@@ -0,0 +1,98 @@
+package core
+
+import (
+	"log"
+
+	"hearsay/internal/identity"
+
+	irc "github.com/fluffle/goirc/client"
+)
+
+// Capabilities used to learn which services account each nick is logged into.
+var accountCaps = []string{"account-tag", "extended-join", "account-notify"}
+
+// whoxToken tags our WHOX queries so their 354 replies can be told apart from anyone else's.
+const whoxToken = "152"
+
+// registerAccountHandlers keeps the identity package's nick -> account map up to date.
+func registerAccountHandlers(c *irc.Conn) {
+	c.Config().EnableCapabilityNegotiation = true
+	c.Config().Capabilites = append(c.Config().Capabilites, accountCaps...)
+
+	c.HandleFunc(irc.JOIN,
+		func(c *irc.Conn, l *irc.Line) {
+			line := parseRaw(l)
+			if line == nil {
+				return
+			}
+
+			if isMe(c, line.Nick) {
+				// Ask for the accounts of everyone already in the channel.
+				if WhoxSupported() {
+					c.Raw("WHO " + line.Param(0) + " %tna," + whoxToken)
+				}
+				return
+			}
+
+			// extended-join: JOIN <channel> <account> :<realname>, where * means logged out.
+			if len(line.Params) >= 3 {
+				setAccount(line.Nick, line.Param(1), "*")
+			}
+		})
+
+	c.HandleFunc("ACCOUNT",
+		func(c *irc.Conn, l *irc.Line) {
+			if line := parseRaw(l); line != nil {
+				setAccount(line.Nick, line.Param(0), "*")
+			}
+		})
+
+	c.HandleFunc("354",
+		func(c *irc.Conn, l *irc.Line) {
+			// :server 354 <me> <token> <nick> <account>, where 0 means logged out.
+			line := parseRaw(l)
+			if line == nil || line.Param(1) != whoxToken || len(line.Params) < 4 {
+				return
+			}
+			setAccount(line.Param(2), line.Param(3), "0")
+		})
+
+	c.HandleFunc(irc.NICK,
+		func(c *irc.Conn, l *irc.Line) {
+			if line := parseRaw(l); line != nil {
+				identity.Rename(line.Nick, line.Param(0))
+			}
+		})
+
+	c.HandleFunc(irc.QUIT,
+		func(c *irc.Conn, l *irc.Line) {
+			if line := parseRaw(l); line != nil {
+				identity.Forget(line.Nick)
+			}
+		})
+
+	c.HandleFunc(irc.DISCONNECTED,
+		func(c *irc.Conn, l *irc.Line) {
+			identity.Clear()
+			whoxSupported.Store(false)
+		})
+}
+
+// updateAccountFromTags applies the account tag of a message. With account-tag
+// negotiated, a missing tag means the sender is not logged in.
+func updateAccountFromTags(c *irc.Conn, line *Line) {
+	if !c.HasCapability("account-tag") {
+		return
+	}
+	identity.SetAccount(line.Nick, line.Tags["account"])
+}
+
+func setAccount(nick string, account string, loggedOut string) {
+	if account == loggedOut {
+		account = ""
+	}
+	if previous, known := identity.Account(nick); known && previous != account {
+		log.Printf("%s switched account from %s to %q\n", nick, previous, account)
+	}
+	identity.SetAccount(nick, account)
+}
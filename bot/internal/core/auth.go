@@ -0,0 +1,95 @@
+package core
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"sync/atomic"
+
+	"hearsay/internal/config"
+
+	"github.com/emersion/go-sasl"
+	irc "github.com/fluffle/goirc/client"
+)
+
+// configureIdentity applies nick, alternate nicks and authentication settings to an IRC config.
+func configureIdentity(cfg *irc.Config) error {
+	cfg.NewNick = nextNick
+
+	if config.AuthCert != "" || config.AuthKey != "" {
+		// A client certificate is also sent outside of SASL EXTERNAL so CertFP works with NickServ.
+		cert, err := tls.LoadX509KeyPair(config.AuthCert, config.AuthKey)
+		if err != nil {
+			return fmt.Errorf("loading client certificate: %w", err)
+		}
+		cfg.SSLConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	switch config.AuthMethod {
+	case "sasl_plain":
+		cfg.Sasl = sasl.NewPlainClient("", config.AuthAccount, config.AuthPassword)
+	case "sasl_external":
+		cfg.Sasl = sasl.NewExternalClient("")
+	}
+
+	return nil
+}
+
+// nextNick walks through the configured alternate nicks when ours is taken
+// and falls back to goirc's default mangling once they are exhausted.
+func nextNick(old string) string {
+	candidates := append([]string{config.BotNick}, config.AltNicks...)
+	for i, nick := range candidates[:len(candidates)-1] {
+		if nick == old {
+			return candidates[i+1]
+		}
+	}
+
+	return irc.DefaultNewNick(old)
+}
+
+// registerAuthHandlers tracks the SASL outcome of each connection and identifies
+// with NickServ once connected when configured to, or when SASL did not succeed.
+func registerAuthHandlers(c *irc.Conn) {
+	var saslDone atomic.Bool
+
+	c.HandleFunc(irc.REGISTER,
+		func(c *irc.Conn, l *irc.Line) {
+			saslDone.Store(false)
+		})
+
+	c.HandleFunc("903",
+		func(c *irc.Conn, l *irc.Line) {
+			saslDone.Store(true)
+			log.Printf("SASL authentication as %s succeeded.\n", config.AuthAccount)
+		})
+
+	c.HandleFunc("904",
+		func(c *irc.Conn, l *irc.Line) {
+			log.Printf("SASL authentication as %s failed.\n", config.AuthAccount)
+		})
+
+	c.HandleFunc("908",
+		func(c *irc.Conn, l *irc.Line) {
+			log.Printf("SASL mechanism rejected by server. Supported: %s\n", l.Text())
+		})
+
+	c.HandleFunc(irc.CONNECTED,
+		func(c *irc.Conn, l *irc.Line) {
+			identify := config.AuthMethod == "nickserv"
+			if config.AuthMethod == "sasl_plain" || config.AuthMethod == "sasl_external" {
+				if saslDone.Load() {
+					return
+				}
+				if !c.HasCapability("sasl") {
+					log.Println("Server did not offer SASL.")
+				}
+				identify = config.NickServFallback
+			}
+
+			if identify && config.AuthPassword != "" {
+				log.Printf("Identifying to NickServ as %s.\n", config.AuthAccount)
+				c.Privmsg("NickServ", fmt.Sprintf("IDENTIFY %s %s", config.AuthAccount, config.AuthPassword))
+			}
+		})
+}
@@ -2,123 +2,246 @@ package core
 
 import (
 	"crypto/tls"
-	"database/sql"
-	"fmt"
 	"log"
 	"strings"
+	"time"
 
 	"hearsay/internal/commands"
 	config "hearsay/internal/config"
+	"hearsay/internal/identity"
+	"hearsay/internal/recent"
+	"hearsay/internal/retrain"
 	storage "hearsay/internal/storage"
 
 	irc "github.com/fluffle/goirc/client"
 	"golang.org/x/net/context"
 )
 
-var messagePool []storage.Message
-
-func HearsayConnect(Server string, Channel string, ctx context.Context, db *sql.DB) {
-	botNick := "hearsay" // TODO: Move to configure.go
-	botUser := "hearsay"
-	botMe := "hearsay"
-
-	cfg := irc.NewConfig(botNick, botUser, botMe)
+func HearsayConnect(Server string, Channel string, ctx context.Context, store storage.Store, ingestor *storage.Ingestor) {
+	cfg := irc.NewConfig(config.BotNick, config.BotUsername, config.BotRealname)
 
 	// https://github.com/fluffle/goirc/blob/v1.3.1/client/connection.go#L144
 	cfg.Version = "Bot"
 	cfg.SSL = true
 	cfg.SSLConfig = &tls.Config{InsecureSkipVerify: true}
 	cfg.Server = Server
+	if err := configureIdentity(cfg); err != nil {
+		log.Printf("Failed to configure bot identity: %s\n", err.Error())
+		return
+	}
 
 	c := irc.Client(cfg)
-
-	quit := make(chan struct{})
+	retrain.SetNotifier(func(nick string, message string) {
+		if !c.Connected() {
+			log.Printf("Could not tell %s that their retrain finished while disconnected: %s\n", nick, message)
+			return
+		}
+		c.Privmsg(nick, message)
+	})
+	commands.UseConnection(c)
+	registerAuthHandlers(c)
+	registerAccountHandlers(c)
+	registerPresenceHandlers(c)
+
+	quit := make(chan struct{}, 1)
+	channels := newChannelSet()
+	channels.Add(Channel)
 
 	// These are handlers and WILL DO STUFF.
 	c.HandleFunc(irc.CONNECTED,
 		func(c *irc.Conn, l *irc.Line) {
-			c.Join(Channel)
-			c.Mode(botNick, config.BotMode)
+			for _, channel := range channels.List() {
+				c.Join(channel)
+				log.Printf("Joined %s\n", channel)
+			}
+			c.Mode(c.Me().Nick, config.BotMode)
 			c.Away(config.CommandPrefix + "help for command list.")
-			log.Printf("Joined %s\n", Channel)
+		})
+
+	c.HandleFunc("005",
+		func(c *irc.Conn, l *irc.Line) {
+			if line := parseRaw(l); line != nil {
+				handleISupport(line)
+			}
+		})
+
+	c.HandleFunc(irc.JOIN,
+		func(c *irc.Conn, l *irc.Line) {
+			line := parseRaw(l)
+			if line != nil && isMe(c, line.Nick) && line.Param(0) != "" {
+				channels.Add(line.Param(0))
+			}
+		})
+
+	c.HandleFunc(irc.PART,
+		func(c *irc.Conn, l *irc.Line) {
+			line := parseRaw(l)
+			if line != nil && isMe(c, line.Nick) && line.Param(0) != "" {
+				channels.Remove(line.Param(0))
+			}
+		})
 
-			log.Println("Loading deletion scheduler...")
-			go commands.DeletionWrapper(db, c, ctx)
+	c.HandleFunc(irc.KICK,
+		func(c *irc.Conn, l *irc.Line) {
+			line := parseRaw(l)
+			if line != nil && isMe(c, line.Param(1)) {
+				channels.Remove(line.Param(0))
+				log.Printf("Kicked from %s by %s\n", line.Param(0), line.Nick)
+			}
 		})
 
 	c.HandleFunc(irc.PRIVMSG,
 		func(c *irc.Conn, l *irc.Line) {
-			incomingMessageAuthor := GetNickFromRawMessage(l.Raw)
-			incomingMessageContent := GetContentFromRawMessage(l.Raw)
-			incomingMessageChannel := GetChannelFromRawMessage(l.Raw)
+			line := parseRaw(l)
+			if line == nil || line.Nick == "" {
+				return
+			}
+
+			updateAccountFromTags(c, line)
+			storage.ClaimLegacyNick(line.Nick, config.MaxProfiles, store)
+			incomingMessageAuthor := line.Nick
+			incomingMessageContent := line.Trailing()
+			incomingMessageChannel := ""
+			if IsChannel(line.Param(0)) {
+				incomingMessageChannel = line.Param(0)
+			}
+			// Messages are stored against the services account when we know it.
+			authorKey := identity.Key(incomingMessageAuthor)
 			messageFinal := storage.Message{
-				Nick:      incomingMessageAuthor,
+				Nick:      authorKey,
 				Content:   incomingMessageContent,
 				Channel:   incomingMessageChannel,
 				Timestamp: l.Time,
 			}
 
-			if strings.HasPrefix(incomingMessageContent, config.CommandPrefix) {
+			isCommand := strings.HasPrefix(incomingMessageContent, config.CommandPrefix)
+			if incomingMessageChannel != "" && !isCommand && !strings.HasPrefix(incomingMessageContent, "\x01") {
+				// Every channel line is kept in RAM for a while so it can be grabbed into a profile.
+				recent.Add(incomingMessageChannel, incomingMessageAuthor, incomingMessageContent, l.Time)
+			}
+
+			if isCommand {
 				// Case: The incoming message is preceded by our command prefix.
 				commandAndArgs := strings.Split(incomingMessageContent, " ")
 				receivedCommand := strings.Split(commandAndArgs[0], config.CommandPrefix)[1]
 				receivedArgs := commandAndArgs[1:]
 				log.Printf("Received command %s by %s.\n", receivedCommand, incomingMessageAuthor)
 
-				go func(rCmd string, rArgs []string, rAuthor string, rChannel string) {
-					if cmd, ok := commands.Commands[rCmd]; ok {
-						result := cmd.Handler(rArgs, rAuthor, db)
-						if result != "" {
-							c.Privmsg(rChannel, result)
-						}
-					} else {
-						c.Privmsgf(rChannel, "No such command: %s", rCmd)
+				// Replies go to the channel for channel messages and to the sender for queries.
+				private := incomingMessageChannel == ""
+				replyTarget := incomingMessageChannel
+				if private {
+					replyTarget = incomingMessageAuthor
+				}
+
+				go func(rCmd string, rArgs []string, rAuthor string, rTarget string, rPrivate bool) {
+					cmd, ok := commands.Commands[rCmd]
+					if !ok {
+						c.Privmsgf(rTarget, "No such command: %s", rCmd)
+						return
 					}
-				}(receivedCommand, receivedArgs, incomingMessageAuthor, incomingMessageChannel)
-			} else if storage.IsOptedIn(incomingMessageAuthor) {
-				// Case: The incoming message is not preceded by our command prefix and the nick is not opted out.
-				messagePool = append(messagePool, messageFinal)
-				if len(messagePool) >= config.MaxMessagePool {
-					tempPool := make([]storage.Message, len(messagePool))
-					copy(tempPool, messagePool)
-					messagePool = nil
-
-					go func(pool []storage.Message) {
-						err := storage.SubmitMessages(pool, db)
-						if err != nil {
-							log.Printf("Failed to submit messages: %v\n", err)
-						} else {
-							log.Printf("Wrote %d/%d messages to database.\n", len(pool), config.MaxMessagePool)
+
+					if !commands.ScopeFor(rCmd, rArgs).Allows(rPrivate) {
+						where := "a private message"
+						if rPrivate {
+							where = "a channel"
 						}
-					}(tempPool)
-				}
+						c.Privmsgf(rTarget, "%s: This command can only be used in %s", rAuthor, where)
+						return
+					}
+
+					if cmd.Sensitive && config.RequireAccount && !identity.Identified(rAuthor) {
+						c.Privmsgf(rTarget, "%s: You must be identified with services to use this command", rAuthor)
+						return
+					}
+
+					result := cmd.Handler(rArgs, rAuthor, store)
+					if result != "" {
+						c.Privmsg(rTarget, result)
+					}
+				}(receivedCommand, receivedArgs, incomingMessageAuthor, replyTarget, private)
+			} else if incomingMessageChannel != "" && storage.IsOptedIn(authorKey) &&
+				(!config.RequireAccount || identity.Identified(incomingMessageAuthor)) {
+				// Case: The incoming channel message is not preceded by our command prefix and the nick is not opted out.
+				// Private queries are never collected, nor are unidentified users when accounts are required.
+				ingestor.Submit(messageFinal)
 			}
 		})
 
 	c.HandleFunc(irc.INVITE,
 		func(c *irc.Conn, l *irc.Line) {
-			channelToJoin := GetChannelFromInvite(l.Raw)
+			line := parseRaw(l)
+			if line == nil || !IsChannel(line.Param(1)) {
+				return
+			}
+
+			channelToJoin := line.Param(1)
 			c.Join(channelToJoin)
 			log.Printf("Joined channel %s\n", channelToJoin)
 		})
 
 	c.HandleFunc(irc.DISCONNECTED,
 		func(c *irc.Conn, l *irc.Line) {
-			close(quit)
+			select {
+			case quit <- struct{}{}:
+			default:
+			}
 		})
 
-	if err := c.Connect(); err != nil {
-		fmt.Printf("Connection error: %s\n", err.Error())
-		return
+	minDelay := time.Duration(config.ReconnectMin) * time.Second
+	maxDelay := time.Duration(config.ReconnectMax) * time.Second
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			delay := backoff(attempt-1, minDelay, maxDelay)
+			log.Printf("Reconnecting to %s in %v (attempt %d)...\n", Server, delay.Round(time.Second), attempt)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+		}
+
+		// Try for our primary nick again rather than whichever alternate we ended up with.
+		c.Config().Me.Nick = config.BotNick
+		if err := c.ConnectContext(ctx); err != nil {
+			log.Printf("Connection error: %s\n", err.Error())
+			if ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+		connectedAt := time.Now()
+
+		select {
+		case <-ctx.Done():
+			log.Println("Conext canceled. Sending QUIT...")
+			c.Quit("Signing off.")
+			c.Close()
+			return
+
+		case <-quit:
+			log.Println("Received server-side disconnect (such as /kill or unavailability)")
+		}
+
+		// A connection that stayed up for a while resets the backoff.
+		if time.Since(connectedAt) > maxDelay {
+			attempt = 0
+		}
 	}
+}
 
-	select {
-	case <-ctx.Done():
-		log.Println("Conext canceled. Sending QUIT...")
-		c.Quit("Signing off.")
-		c.Close()
-
-	case <-quit:
-		log.Println("Received server-side disconnect (such as /kill or unavailability)")
+// parseRaw runs goirc's raw line through our own parser, logging lines it cannot make sense of.
+func parseRaw(l *irc.Line) *Line {
+	line, err := ParseLine(l.Raw)
+	if err != nil {
+		log.Printf("Failed to parse line %q: %s\n", l.Raw, err.Error())
+		return nil
 	}
+
+	return line
+}
+
+func isMe(c *irc.Conn, nick string) bool {
+	return nick != "" && strings.EqualFold(nick, c.Me().Nick)
 }
@@ -0,0 +1,174 @@
+package core
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Line is a single parsed IRC message as described by RFC 1459 and IRCv3 message tags:
+//
+//	[@tags] [:prefix] command [params] [:trailing]
+type Line struct {
+	Tags    map[string]string
+	Prefix  string
+	Nick    string
+	User    string
+	Host    string
+	Command string
+	Params  []string
+}
+
+var ErrEmptyLine = errors.New("empty line")
+var ErrNoCommand = errors.New("line has no command")
+
+// tagEscapes maps the character after a backslash in a tag value to what it stands for.
+var tagEscapes = map[byte]byte{':': ';', 's': ' ', '\\': '\\', 'r': '\r', 'n': '\n'}
+
+// ParseLine parses a raw line as received from the server. Trailing CR/LF is ignored.
+func ParseLine(raw string) (*Line, error) {
+	s := strings.TrimRight(raw, "\r\n")
+	if strings.TrimSpace(s) == "" {
+		return nil, ErrEmptyLine
+	}
+
+	line := &Line{}
+
+	if strings.HasPrefix(s, "@") {
+		var tags string
+		tags, s = nextToken(s[1:])
+		line.Tags = parseTags(tags)
+	}
+
+	if strings.HasPrefix(s, ":") {
+		line.Prefix, s = nextToken(s[1:])
+		line.Nick, line.User, line.Host = splitPrefix(line.Prefix)
+	}
+
+	line.Command, s = nextToken(s)
+	if line.Command == "" {
+		return nil, ErrNoCommand
+	}
+	line.Command = strings.ToUpper(line.Command)
+
+	for s != "" {
+		if strings.HasPrefix(s, ":") {
+			// Everything after the colon is one parameter, spaces and colons included.
+			line.Params = append(line.Params, s[1:])
+			break
+		}
+
+		var param string
+		param, s = nextToken(s)
+		line.Params = append(line.Params, param)
+	}
+
+	return line, nil
+}
+
+// nextToken returns the text up to the next space and the remainder with leading spaces removed.
+func nextToken(s string) (string, string) {
+	s = strings.TrimLeft(s, " ")
+	token, rest, _ := strings.Cut(s, " ")
+	return token, strings.TrimLeft(rest, " ")
+}
+
+func parseTags(s string) map[string]string {
+	tags := make(map[string]string)
+	for _, tag := range strings.Split(s, ";") {
+		if tag == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(tag, "=")
+		tags[key] = unescapeTagValue(value)
+	}
+
+	return tags
+}
+
+// unescapeTagValue undoes IRCv3 tag value escaping. A backslash before any other
+// character is dropped, and so is a lone backslash at the end of the value.
+func unescapeTagValue(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			b.WriteByte(value[i])
+			continue
+		}
+		if i++; i == len(value) {
+			break
+		}
+		if unescaped, ok := tagEscapes[value[i]]; ok {
+			b.WriteByte(unescaped)
+		} else {
+			b.WriteByte(value[i])
+		}
+	}
+
+	return b.String()
+}
+
+// splitPrefix splits nick!user@host. Server prefixes (no ! or @, but a dot) yield an empty nick.
+func splitPrefix(prefix string) (string, string, string) {
+	nick, host, hasHost := strings.Cut(prefix, "@")
+	nick, user, hasUser := strings.Cut(nick, "!")
+	if !hasHost && !hasUser && strings.Contains(prefix, ".") {
+		return "", "", prefix
+	}
+
+	return nick, user, host
+}
+
+// Param returns the i-th parameter or an empty string if there are not that many.
+func (l *Line) Param(i int) string {
+	if i < 0 || i >= len(l.Params) {
+		return ""
+	}
+	return l.Params[i]
+}
+
+// Trailing returns the last parameter, which for PRIVMSG and NOTICE is the message text.
+func (l *Line) Trailing() string {
+	return l.Param(len(l.Params) - 1)
+}
+
+var chanTypesMu sync.RWMutex
+var chanTypes = "#&"
+
+// SetChanTypes records the CHANTYPES advertised by the server in RPL_ISUPPORT.
+func SetChanTypes(types string) {
+	chanTypesMu.Lock()
+	defer chanTypesMu.Unlock()
+	chanTypes = types
+}
+
+// IsChannel reports whether name is a channel according to the server's CHANTYPES.
+func IsChannel(name string) bool {
+	chanTypesMu.RLock()
+	defer chanTypesMu.RUnlock()
+	return name != "" && strings.ContainsRune(chanTypes, rune(name[0]))
+}
+
+var whoxSupported atomic.Bool
+
+// WhoxSupported reports whether the server advertised WHOX in RPL_ISUPPORT.
+func WhoxSupported() bool {
+	return whoxSupported.Load()
+}
+
+// handleISupport picks the options we care about out of a 005 line.
+func handleISupport(line *Line) {
+	// The first parameter is our nick and the last is the human-readable "are supported by this server".
+	if len(line.Params) < 3 {
+		return
+	}
+	for _, token := range line.Params[1 : len(line.Params)-1] {
+		if value, ok := strings.CutPrefix(token, "CHANTYPES="); ok {
+			SetChanTypes(value)
+		}
+		if token == "WHOX" {
+			whoxSupported.Store(true)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want Line
+	}{
+		{
+			name: "privmsg to channel",
+			raw:  ":alice!al@example.org PRIVMSG #hearsay :hello there\r\n",
+			want: Line{Prefix: "alice!al@example.org", Nick: "alice", User: "al", Host: "example.org",
+				Command: "PRIVMSG", Params: []string{"#hearsay", "hello there"}},
+		},
+		{
+			name: "privmsg to nick",
+			raw:  ":alice!al@example.org PRIVMSG hearsay :+opt in",
+			want: Line{Prefix: "alice!al@example.org", Nick: "alice", User: "al", Host: "example.org",
+				Command: "PRIVMSG", Params: []string{"hearsay", "+opt in"}},
+		},
+		{
+			name: "ampersand channel",
+			raw:  ":bob!b@host PRIVMSG &local :hi",
+			want: Line{Prefix: "bob!b@host", Nick: "bob", User: "b", Host: "host",
+				Command: "PRIVMSG", Params: []string{"&local", "hi"}},
+		},
+		{
+			name: "trailing with colons and spaces",
+			raw:  ":bob!b@host PRIVMSG #c :see https://example.org:8080/x :: ok",
+			want: Line{Prefix: "bob!b@host", Nick: "bob", User: "b", Host: "host",
+				Command: "PRIVMSG", Params: []string{"#c", "see https://example.org:8080/x :: ok"}},
+		},
+		{
+			name: "colon inside a middle parameter",
+			raw:  ":irc.example.org 005 hearsay CHANTYPES=#& PREFIX=(ov)@+ :are supported by this server",
+			want: Line{Prefix: "irc.example.org", Host: "irc.example.org",
+				Command: "005", Params: []string{"hearsay", "CHANTYPES=#&", "PREFIX=(ov)@+", "are supported by this server"}},
+		},
+		{
+			name: "empty trailing",
+			raw:  ":bob!b@host PRIVMSG #c :",
+			want: Line{Prefix: "bob!b@host", Nick: "bob", User: "b", Host: "host",
+				Command: "PRIVMSG", Params: []string{"#c", ""}},
+		},
+		{
+			name: "server-only prefix",
+			raw:  ":irc.example.org NOTICE * :*** Looking up your hostname",
+			want: Line{Prefix: "irc.example.org", Host: "irc.example.org",
+				Command: "NOTICE", Params: []string{"*", "*** Looking up your hostname"}},
+		},
+		{
+			name: "no prefix, lowercase command",
+			raw:  "ping :irc.example.org",
+			want: Line{Command: "PING", Params: []string{"irc.example.org"}},
+		},
+		{
+			name: "nick-only prefix",
+			raw:  ":alice QUIT :bye",
+			want: Line{Prefix: "alice", Nick: "alice", Command: "QUIT", Params: []string{"bye"}},
+		},
+		{
+			name: "repeated spaces between parameters",
+			raw:  ":alice!a@h   MODE  #c   +o  bob",
+			want: Line{Prefix: "alice!a@h", Nick: "alice", User: "a", Host: "h",
+				Command: "MODE", Params: []string{"#c", "+o", "bob"}},
+		},
+		{
+			name: "tags",
+			raw:  "@account=alice;time=2025-03-01T20:00:00.000Z;+draft/reply :alice!a@h PRIVMSG #c :hi",
+			want: Line{
+				Tags:   map[string]string{"account": "alice", "time": "2025-03-01T20:00:00.000Z", "+draft/reply": ""},
+				Prefix: "alice!a@h", Nick: "alice", User: "a", Host: "h",
+				Command: "PRIVMSG", Params: []string{"#c", "hi"},
+			},
+		},
+		{
+			name: "escaped tag values",
+			raw:  `@a=one\stwo;b=semi\:colon;c=back\\slash;d=line\r\nbreak;e=ends\\;f=lone\;g=\unknown :s 001 hearsay :hi`,
+			want: Line{
+				Tags: map[string]string{
+					"a": "one two", "b": "semi;colon", "c": `back\slash`, "d": "line\r\nbreak",
+					"e": `ends\`, "f": "lone", "g": "unknown",
+				},
+				Prefix: "s", Nick: "s", Command: "001", Params: []string{"hearsay", "hi"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseLine(tt.raw)
+			if err != nil {
+				t.Fatalf("ParseLine(%q) failed: %s", tt.raw, err)
+			}
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("ParseLine(%q)\n got %#v\nwant %#v", tt.raw, *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseLineErrors(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want error
+	}{
+		{"", ErrEmptyLine},
+		{"  \r\n", ErrEmptyLine},
+		{":irc.example.org", ErrNoCommand},
+		{"@a=b", ErrNoCommand},
+	}
+
+	for _, tt := range tests {
+		if _, err := ParseLine(tt.raw); err != tt.want {
+			t.Errorf("ParseLine(%q) = %v, want %v", tt.raw, err, tt.want)
+		}
+	}
+}
+
+func TestIsChannel(t *testing.T) {
+	defer SetChanTypes("#&")
+
+	SetChanTypes("#&")
+	for name, want := range map[string]bool{"#hearsay": true, "&local": true, "hearsay": false, "": false, "+modeless": false} {
+		if got := IsChannel(name); got != want {
+			t.Errorf("IsChannel(%q) = %v, want %v", name, got, want)
+		}
+	}
+
+	SetChanTypes("#")
+	if IsChannel("&local") {
+		t.Errorf("IsChannel(%q) = true with CHANTYPES=#", "&local")
+	}
+}
@@ -0,0 +1,60 @@
+package core
+
+import (
+	"strings"
+
+	"hearsay/internal/identity"
+
+	irc "github.com/fluffle/goirc/client"
+)
+
+// membershipPrefixes are the channel status prefixes NAMES replies put before nicks.
+const membershipPrefixes = "~&@%+"
+
+// registerPresenceHandlers keeps track of which of our channels each nick is in.
+// NICK, QUIT and disconnects are handled by registerAccountHandlers.
+func registerPresenceHandlers(c *irc.Conn) {
+	c.HandleFunc(irc.JOIN,
+		func(c *irc.Conn, l *irc.Line) {
+			if line := parseRaw(l); line != nil && line.Param(0) != "" {
+				identity.Join(line.Nick, line.Param(0))
+			}
+		})
+
+	c.HandleFunc(irc.PART,
+		func(c *irc.Conn, l *irc.Line) {
+			if line := parseRaw(l); line != nil && line.Param(0) != "" {
+				left(c, line.Nick, line.Param(0))
+			}
+		})
+
+	c.HandleFunc(irc.KICK,
+		func(c *irc.Conn, l *irc.Line) {
+			if line := parseRaw(l); line != nil && line.Param(1) != "" {
+				left(c, line.Param(1), line.Param(0))
+			}
+		})
+
+	c.HandleFunc("353",
+		func(c *irc.Conn, l *irc.Line) {
+			// :server 353 <me> <type> <channel> :<nicks>
+			line := parseRaw(l)
+			if line == nil || len(line.Params) < 4 {
+				return
+			}
+			for _, name := range strings.Fields(line.Trailing()) {
+				if nick := strings.TrimLeft(name, membershipPrefixes); nick != "" {
+					identity.Join(nick, line.Param(2))
+				}
+			}
+		})
+}
+
+// left records that nick is no longer in channel, and if nick is us, that nobody is as far as we know.
+func left(c *irc.Conn, nick string, channel string) {
+	if isMe(c, nick) {
+		identity.Left(channel)
+		return
+	}
+	identity.Part(nick, channel)
+}
@@ -0,0 +1,55 @@
+package core
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// backoff returns how long to wait before reconnection attempt n (starting at 0).
+// The delay doubles per attempt up to max and is jittered to [d/2, d] so that
+// several bots thrown off by the same netsplit don't reconnect in lockstep.
+func backoff(attempt int, min time.Duration, max time.Duration) time.Duration {
+	d := min
+	for i := 0; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// channelSet keeps track of the channels the bot is in so they can be rejoined after a reconnect.
+type channelSet struct {
+	mu       sync.Mutex
+	channels map[string]struct{}
+}
+
+func newChannelSet() *channelSet {
+	return &channelSet{channels: make(map[string]struct{})}
+}
+
+func (s *channelSet) Add(channel string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.channels[channel] = struct{}{}
+}
+
+func (s *channelSet) Remove(channel string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.channels, channel)
+}
+
+func (s *channelSet) List() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]string, 0, len(s.channels))
+	for channel := range s.channels {
+		list = append(list, channel)
+	}
+	return list
+}
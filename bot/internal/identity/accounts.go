@@ -0,0 +1,112 @@
+package identity
+
+import (
+	"strings"
+	"sync"
+)
+
+// We keep a map of nick -> services account, fed by IRCv3 account-tag,
+// extended-join, account-notify and WHOX replies. An empty account means
+// the nick is known to be logged out; a missing entry means we don't know.
+
+var mu sync.RWMutex
+var accounts = make(map[string]string)
+
+// sessions carries a user's identity key across NICK changes within one
+// session, so katt -> katt_away keeps storing under katt.
+var sessions = make(map[string]string)
+
+func fold(nick string) string {
+	return strings.ToLower(nick)
+}
+
+func SetAccount(nick string, account string) {
+	mu.Lock()
+	defer mu.Unlock()
+	accounts[fold(nick)] = account
+}
+
+// Account returns the account nick is logged into and whether it is logged in at all.
+func Account(nick string) (string, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	account := accounts[fold(nick)]
+	return account, account != ""
+}
+
+// Known reports whether we know if nick is logged in at all.
+func Known(nick string) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	_, known := accounts[fold(nick)]
+	return known
+}
+
+func Rename(oldNick string, newNick string) {
+	mu.Lock()
+	defer mu.Unlock()
+	if account, ok := accounts[fold(oldNick)]; ok {
+		delete(accounts, fold(oldNick))
+		accounts[fold(newNick)] = account
+	}
+
+	carried, ok := sessions[fold(oldNick)]
+	if !ok {
+		carried = oldNick
+	}
+	delete(sessions, fold(oldNick))
+	if fold(carried) != fold(newNick) {
+		sessions[fold(newNick)] = carried
+	}
+	renameMember(oldNick, newNick)
+}
+
+func Forget(nick string) {
+	mu.Lock()
+	defer mu.Unlock()
+	delete(accounts, fold(nick))
+	delete(sessions, fold(nick))
+	forgetMember(nick)
+}
+
+// Clear drops everything we know about the current session, e.g. after a disconnect.
+func Clear() {
+	mu.Lock()
+	defer mu.Unlock()
+	accounts = make(map[string]string)
+	sessions = make(map[string]string)
+	clearMembers()
+}
+
+func Identified(nick string) bool {
+	_, ok := Account(nick)
+	return ok
+}
+
+// NickPrefix marks identity keys taken from the nick of a user known not to be
+// logged in. It cannot occur in nicks or account names, so nobody can store data
+// under an account just by using a nick of the same name.
+const NickPrefix = "~"
+
+// Key returns the identity data is stored under: the account when the nick is
+// logged in, otherwise the nick the user had before any NICK changes this
+// session, behind NickPrefix if the user is known to be logged out. Without
+// account information, as on networks without services, the nick is used as is.
+// The key is then resolved through the linked aliases.
+func Key(nick string) string {
+	mu.RLock()
+	account, known := accounts[fold(nick)]
+	carried, ok := sessions[fold(nick)]
+	mu.RUnlock()
+	if account != "" {
+		return Canonical(account)
+	}
+	if !ok {
+		carried = nick
+	}
+	if known {
+		return Canonical(NickPrefix + carried)
+	}
+
+	return Canonical(carried)
+}
@@ -0,0 +1,60 @@
+package identity
+
+import "testing"
+
+func TestKey(t *testing.T) {
+	defer Clear()
+
+	SetAccount("alice", "alice")
+	SetAccount("Mallory", "")
+	SetAccount("bob", "")
+	Rename("bob", "bob_away")
+
+	tests := []struct {
+		nick string
+		want string
+	}{
+		{"alice", "alice"},
+		// Logged out under an account's name: kept apart from the account.
+		{"Mallory", NickPrefix + "Mallory"},
+		{"bob_away", NickPrefix + "bob"},
+		// Nothing is known without services, so the nick is used as is.
+		{"carol", "carol"},
+	}
+	for _, tt := range tests {
+		if got := Key(tt.nick); got != tt.want {
+			t.Errorf("Key(%q) = %q, want %q", tt.nick, got, tt.want)
+		}
+	}
+
+	SetAccount("alice", "")
+	if got := Key("alice"); got == "alice" {
+		t.Errorf("Key(%q) = %q after logging out, want the nick namespace", "alice", got)
+	}
+}
+
+func TestOnline(t *testing.T) {
+	defer Clear()
+
+	SetAccount("alice_", "alice")
+	Join("alice_", "#hearsay")
+	SetAccount("bob", "")
+	Join("bob", "#hearsay")
+	Join("bob", "#other")
+
+	if nick, ok := Online("alice"); !ok || nick != "alice_" {
+		t.Errorf("Online(%q) = %q, %v, want %q", "alice", nick, ok, "alice_")
+	}
+	if nick, ok := Online("bob"); ok {
+		t.Errorf("Online(%q) = %q, want nobody: bob is not logged in", "bob", nick)
+	}
+	if nick, ok := Online(NickPrefix + "bob"); !ok || nick != "bob" {
+		t.Errorf("Online(%q) = %q, %v, want %q", NickPrefix+"bob", nick, ok, "bob")
+	}
+
+	Part("bob", "#hearsay")
+	Left("#other")
+	if nick, ok := Online(NickPrefix + "bob"); ok {
+		t.Errorf("Online(%q) = %q after bob left every shared channel", NickPrefix+"bob", nick)
+	}
+}
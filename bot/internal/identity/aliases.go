@@ -0,0 +1,38 @@
+package identity
+
+import "sync"
+
+// Linked aliases map an identity key onto the canonical identity it belongs to.
+// They are persisted in the aliases table and loaded at start-up.
+
+var aliasesMu sync.RWMutex
+var aliases = make(map[string]string)
+
+func SetAlias(alias string, canonical string) {
+	aliasesMu.Lock()
+	defer aliasesMu.Unlock()
+	aliases[fold(alias)] = canonical
+}
+
+func RemoveAlias(alias string) {
+	aliasesMu.Lock()
+	defer aliasesMu.Unlock()
+	delete(aliases, fold(alias))
+}
+
+// Canonical follows alias links from key to the identity everything is stored under.
+func Canonical(key string) string {
+	aliasesMu.RLock()
+	defer aliasesMu.RUnlock()
+
+	// Links are never more than a few hops long; the bound protects against cycles.
+	for i := 0; i < 8; i++ {
+		next, ok := aliases[fold(key)]
+		if !ok {
+			break
+		}
+		key = next
+	}
+
+	return key
+}
@@ -0,0 +1,111 @@
+package identity
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// We also keep which of the bot's channels each nick is in, fed by JOIN, PART,
+// KICK and NAMES replies, so commands can stay within the channels a user
+// shares with the bot. NICK and QUIT are handled along with accounts.
+
+var channelsMu sync.RWMutex
+
+type member struct {
+	nick string
+	// channels maps folded channel names to the names as they were joined.
+	channels map[string]string
+}
+
+// members is keyed by folded nick.
+var members = make(map[string]*member)
+
+func Join(nick string, channel string) {
+	channelsMu.Lock()
+	defer channelsMu.Unlock()
+	if members[fold(nick)] == nil {
+		members[fold(nick)] = &member{nick, make(map[string]string)}
+	}
+	members[fold(nick)].channels[strings.ToLower(channel)] = channel
+}
+
+func Part(nick string, channel string) {
+	channelsMu.Lock()
+	defer channelsMu.Unlock()
+	m, ok := members[fold(nick)]
+	if !ok {
+		return
+	}
+	delete(m.channels, strings.ToLower(channel))
+	if len(m.channels) == 0 {
+		delete(members, fold(nick))
+	}
+}
+
+// Left drops channel for everyone, for when the bot itself is no longer in it.
+func Left(channel string) {
+	channelsMu.Lock()
+	defer channelsMu.Unlock()
+	for nick, m := range members {
+		delete(m.channels, strings.ToLower(channel))
+		if len(m.channels) == 0 {
+			delete(members, nick)
+		}
+	}
+}
+
+// Channels returns the channels nick shares with the bot, sorted.
+func Channels(nick string) []string {
+	channelsMu.RLock()
+	defer channelsMu.RUnlock()
+	channels := []string{}
+	if m, ok := members[fold(nick)]; ok {
+		for _, channel := range m.channels {
+			channels = append(channels, channel)
+		}
+	}
+	sort.Strings(channels)
+	return channels
+}
+
+// Online returns a nick in one of the bot's channels whose data is stored under
+// key, if anyone like that is around.
+func Online(key string) (string, bool) {
+	channelsMu.RLock()
+	nicks := make([]string, 0, len(members))
+	for _, m := range members {
+		nicks = append(nicks, m.nick)
+	}
+	channelsMu.RUnlock()
+
+	sort.Strings(nicks)
+	for _, nick := range nicks {
+		if fold(Key(nick)) == fold(key) {
+			return nick, true
+		}
+	}
+	return "", false
+}
+
+func renameMember(oldNick string, newNick string) {
+	channelsMu.Lock()
+	defer channelsMu.Unlock()
+	if m, ok := members[fold(oldNick)]; ok {
+		delete(members, fold(oldNick))
+		m.nick = newNick
+		members[fold(newNick)] = m
+	}
+}
+
+func forgetMember(nick string) {
+	channelsMu.Lock()
+	defer channelsMu.Unlock()
+	delete(members, fold(nick))
+}
+
+func clearMembers() {
+	channelsMu.Lock()
+	defer channelsMu.Unlock()
+	members = make(map[string]*member)
+}
@@ -0,0 +1,197 @@
+// Package nlpclient talks to hearsay's Python NLP API. Every endpoint has a typed
+// method; calls are bounded by a timeout derived from the caller's context, and
+// the idempotent ones are retried when the API is unreachable or overloaded.
+package nlpclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client is safe for concurrent use.
+type Client struct {
+	baseURL string
+	http    *http.Client
+	// timeout bounds every attempt of a call, retrainTimeout a retrain.
+	timeout        time.Duration
+	retrainTimeout time.Duration
+	// retries is how many more times an idempotent call is attempted after a temporary failure.
+	retries int
+	backoff time.Duration
+}
+
+func New(baseURL string, timeout time.Duration, retrainTimeout time.Duration, retries int) *Client {
+	return &Client{
+		baseURL:        strings.TrimRight(baseURL, "/"),
+		http:           &http.Client{},
+		timeout:        timeout,
+		retrainTimeout: retrainTimeout,
+		retries:        retries,
+		backoff:        500 * time.Millisecond,
+	}
+}
+
+// Error describes a call that failed, either before the API answered (Status is
+// zero and Err says why) or because it answered with an error status.
+type Error struct {
+	Endpoint string
+	Status   int
+	// Detail is the API's explanation of an error status, if it gave one.
+	Detail string
+	Err    error
+}
+
+func (e *Error) Error() string {
+	if e.Status == 0 {
+		return fmt.Sprintf("%s: %s", e.Endpoint, e.Err.Error())
+	}
+	if e.Detail != "" {
+		return fmt.Sprintf("%s: %d %s: %s", e.Endpoint, e.Status, http.StatusText(e.Status), e.Detail)
+	}
+	return fmt.Sprintf("%s: %d %s", e.Endpoint, e.Status, http.StatusText(e.Status))
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Temporary reports whether trying again later might succeed.
+func (e *Error) Temporary() bool {
+	if e.Status == 0 {
+		// A call that ran out of time is not repeated, lest a struggling API get more work.
+		return !errors.Is(e.Err, context.Canceled) && !errors.Is(e.Err, context.DeadlineExceeded) && !isDecodeError(e.Err)
+	}
+	return e.Status == http.StatusTooManyRequests || e.Status >= 500
+}
+
+// TimedOut reports whether err is a call that ran out of time.
+func TimedOut(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
+type decodeError struct {
+	err error
+}
+
+func (e decodeError) Error() string {
+	return "decoding response: " + e.err.Error()
+}
+
+func (e decodeError) Unwrap() error {
+	return e.err
+}
+
+func isDecodeError(err error) bool {
+	var d decodeError
+	return errors.As(err, &d)
+}
+
+// call sends one request and decodes the JSON response into out. in, if not nil,
+// is sent as a JSON body.
+func (c *Client) call(ctx context.Context, method string, endpoint string, query url.Values, in any, out any, timeout time.Duration, idempotent bool) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return &Error{Endpoint: endpoint, Err: err}
+		}
+	}
+
+	target := c.baseURL + endpoint
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	attempts := 1
+	if idempotent {
+		attempts += c.retries
+	}
+
+	var err *Error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return &Error{Endpoint: endpoint, Err: ctx.Err()}
+			case <-time.After(c.backoff << (attempt - 1)):
+			}
+		}
+
+		err = c.do(ctx, method, endpoint, target, body, out, timeout)
+		if err == nil || !err.Temporary() || ctx.Err() != nil {
+			break
+		}
+	}
+
+	if err == nil {
+		return nil
+	}
+	return err
+}
+
+func (c *Client) do(ctx context.Context, method string, endpoint string, target string, body []byte, out any, timeout time.Duration) *Error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return &Error{Endpoint: endpoint, Err: err}
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return &Error{Endpoint: endpoint, Err: err}
+	}
+	defer res.Body.Close()
+
+	raw, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return &Error{Endpoint: endpoint, Err: err}
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return &Error{Endpoint: endpoint, Status: res.StatusCode, Detail: detail(raw)}
+	}
+	if err := json.Unmarshal(raw, out); err != nil {
+		return &Error{Endpoint: endpoint, Err: decodeError{err}}
+	}
+
+	return nil
+}
+
+// detail extracts FastAPI's {"detail": ...} from an error response, or else
+// returns the start of the body.
+func detail(raw []byte) string {
+	var fastAPI struct {
+		Detail any `json:"detail"`
+	}
+	if json.Unmarshal(raw, &fastAPI) == nil && fastAPI.Detail != nil {
+		if s, ok := fastAPI.Detail.(string); ok {
+			return s
+		}
+		encoded, _ := json.Marshal(fastAPI.Detail)
+		raw = encoded
+	}
+
+	text := strings.TrimSpace(string(raw))
+	if len(text) > 200 {
+		text = text[:200] + "..."
+	}
+	return text
+}
@@ -0,0 +1,128 @@
+package nlpclient
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Attribution is the most likely author of a text and the scores of the top candidates,
+// preformatted by the API as "nick_ (0.56), other_ (0.09), ...".
+type Attribution struct {
+	Author     string `json:"author"`
+	Confidence string `json:"confidence"`
+}
+
+type Sentiment struct {
+	Pos      float64 `json:"pos"`
+	Neu      float64 `json:"neu"`
+	Neg      float64 `json:"neg"`
+	Compound float64 `json:"compound"`
+	// Label is "positive", "neutral" or "negative".
+	Label string `json:"hr"`
+}
+
+// Stats summarises one author for the me command.
+type Stats struct {
+	Readability    float64 `json:"readability"`
+	Sentiment      float64 `json:"sentiment"`
+	SentimentLabel string  `json:"sentiment_hr"`
+	Neighbour      string  `json:"neighbour"`
+}
+
+type RetrainOptions struct {
+	MinMessages int
+	// ConfusionMatrix adds cross-validated evaluation and an uploaded confusion matrix.
+	ConfusionMatrix bool
+	// PastDays leaves out nicks inactive for longer; zero keeps everyone.
+	PastDays int
+	Bert     bool
+	GPU      bool
+}
+
+type RetrainResult struct {
+	Seconds float64 `json:"time"`
+	// ConfusionMatrixURL and the scores are only set when evaluation was requested.
+	ConfusionMatrixURL string  `json:"url"`
+	Accuracy           float64 `json:"accuracy"`
+	F1                 float64 `json:"f1"`
+	// Labels is how many authors the model tells apart; Samples how many messages it was fit on.
+	Labels  int `json:"labels"`
+	Samples int `json:"samples"`
+}
+
+// profileDelimiter separates the messages of a profile sent to /profile_attribute.
+const profileDelimiter = "/:MSG/"
+
+type attributeRequest struct {
+	Msg         string `json:"msg"`
+	MinMessages int    `json:"min_messages"`
+	Confidence  bool   `json:"confidence"`
+}
+
+func (c *Client) Readability(ctx context.Context, nick string) (float64, error) {
+	var result struct {
+		Score float64 `json:"score"`
+	}
+	err := c.call(ctx, http.MethodGet, "/readability", url.Values{"nick": {nick}}, nil, &result, c.timeout, true)
+	return result.Score, err
+}
+
+func (c *Client) Me(ctx context.Context, author string) (Stats, error) {
+	var result Stats
+	err := c.call(ctx, http.MethodGet, "/me", url.Values{"author": {author}}, nil, &result, c.timeout, true)
+	return result, err
+}
+
+func (c *Client) Sentiment(ctx context.Context, message string) (Sentiment, error) {
+	var result Sentiment
+	err := c.call(ctx, http.MethodPost, "/sentiment", nil, map[string]string{"msg": message}, &result, c.timeout, true)
+	return result, err
+}
+
+func (c *Client) Attribute(ctx context.Context, message string, minMessages int) (Attribution, error) {
+	var result Attribution
+	err := c.call(ctx, http.MethodPost, "/attribute", nil, attributeRequest{message, minMessages, true}, &result, c.timeout, true)
+	return result, err
+}
+
+// ProfileAttribute attributes a profile's messages as a whole.
+func (c *Client) ProfileAttribute(ctx context.Context, messages []string, minMessages int) (Attribution, error) {
+	var result Attribution
+	in := attributeRequest{strings.Join(messages, profileDelimiter), minMessages, true}
+	err := c.call(ctx, http.MethodPost, "/profile_attribute", nil, in, &result, c.timeout, true)
+	return result, err
+}
+
+// AttributeList returns the nicks the model knows, as a comma-separated list.
+func (c *Client) AttributeList(ctx context.Context) (string, error) {
+	var result struct {
+		Authors string `json:"authors"`
+	}
+	err := c.call(ctx, http.MethodGet, "/attribute_list", nil, nil, &result, c.timeout, true)
+	return result.Authors, err
+}
+
+// Retrain refits the model. It replaces the model on disk, so it is never retried.
+func (c *Client) Retrain(ctx context.Context, opts RetrainOptions) (RetrainResult, error) {
+	query := url.Values{
+		"min_messages": {strconv.Itoa(opts.MinMessages)},
+		"cm":           {flag(opts.ConfusionMatrix)},
+		"cf":           {strconv.Itoa(opts.PastDays)},
+		"bert":         {flag(opts.Bert)},
+		"gpu":          {flag(opts.GPU)},
+	}
+
+	var result RetrainResult
+	err := c.call(ctx, http.MethodGet, "/retrain", query, nil, &result, c.retrainTimeout, false)
+	return result, err
+}
+
+func flag(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
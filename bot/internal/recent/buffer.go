@@ -0,0 +1,139 @@
+// Package recent keeps the last channel lines the bot has seen, whether or not
+// their authors are opted in, so they can be referred to by commands. Lines are
+// only ever held in memory and are dropped once they are older than the window.
+package recent
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Line is a channel message as the bot saw it. IDs increase and are never reused
+// while the bot is running.
+type Line struct {
+	ID      uint64
+	Channel string
+	Nick    string
+	Content string
+	Time    time.Time
+}
+
+var (
+	mu     sync.Mutex
+	buf    = make([]Line, 500)
+	start  int // index of the oldest line
+	count  int
+	lastID uint64
+	window = 30 * time.Minute
+	now    = time.Now
+)
+
+// Configure sets the buffer size and how long lines are kept, dropping whatever is buffered.
+func Configure(size int, maxAge time.Duration) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	buf = make([]Line, size)
+	start, count = 0, 0
+	window = maxAge
+}
+
+// Add buffers a channel line, overwriting the oldest one when full, and returns its ID.
+func Add(channel string, nick string, content string, at time.Time) uint64 {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(buf) == 0 {
+		return 0
+	}
+
+	lastID++
+	line := Line{lastID, channel, nick, content, at}
+	if count < len(buf) {
+		buf[(start+count)%len(buf)] = line
+		count++
+	} else {
+		buf[start] = line
+		start = (start + 1) % len(buf)
+	}
+	expire()
+
+	return lastID
+}
+
+// expire drops lines older than the window. Callers hold mu.
+func expire() {
+	cutoff := now().Add(-window)
+	for count > 0 && buf[start].Time.Before(cutoff) {
+		buf[start] = Line{}
+		start = (start + 1) % len(buf)
+		count--
+	}
+}
+
+// Expire drops lines older than the window.
+func Expire() {
+	mu.Lock()
+	defer mu.Unlock()
+	expire()
+}
+
+// Run expires lines every minute so nothing outlives the window in a quiet channel.
+func Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			Expire()
+		}
+	}
+}
+
+// Get returns the buffered line with the given ID.
+func Get(id uint64) (Line, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	expire()
+
+	for i := 0; i < count; i++ {
+		if line := buf[(start+i)%len(buf)]; line.ID == id {
+			return line, true
+		}
+	}
+
+	return Line{}, false
+}
+
+// Latest returns up to n of the most recent lines from channels, oldest first.
+// An empty nick matches everyone.
+func Latest(nick string, n int, channels []string) []Line {
+	mu.Lock()
+	defer mu.Unlock()
+	expire()
+
+	lines := []Line{}
+	for i := count - 1; i >= 0 && len(lines) < n; i-- {
+		line := buf[(start+i)%len(buf)]
+		if (nick == "" || strings.EqualFold(line.Nick, nick)) && In(line, channels) {
+			lines = append(lines, line)
+		}
+	}
+
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+
+	return lines
+}
+
+// In reports whether line was seen in one of channels.
+func In(line Line, channels []string) bool {
+	return slices.ContainsFunc(channels, func(channel string) bool { return strings.EqualFold(channel, line.Channel) })
+}
@@ -0,0 +1,78 @@
+package retrain
+
+import (
+	"fmt"
+	"hearsay/internal/nlpclient"
+	"log"
+	"time"
+)
+
+var (
+	// defaults are the options of the retrains the bot starts by itself.
+	defaults nlpclient.RetrainOptions
+	// admins are told when the model must be retrained for compliance.
+	admins []string
+	// stale is when data behind the model was last removed, and staleReason how.
+	// The model holds removed data for as long as stale is after trainedOn.
+	stale       time.Time
+	staleReason string
+	wake        = make(chan struct{}, 1)
+)
+
+// Configure sets the options of the retrains the bot starts by itself and the
+// nicks of the admins to tell about compliance retrains.
+func Configure(opts nlpclient.RetrainOptions, adminNicks []string) {
+	mu.Lock()
+	defer mu.Unlock()
+	defaults = opts
+	admins = adminNicks
+}
+
+// Invalidate records that data the model was trained on has been removed, such
+// as by a purge or an opt-out, and has a compliance retrain queued as soon as
+// possible. Admins are told the first time the model becomes stale.
+func Invalidate(reason string) {
+	mu.Lock()
+	wasStale := stale.After(trainedOn)
+	stale = now()
+	staleReason = reason
+	tell := notify
+	recipients := admins
+	mu.Unlock()
+
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+
+	if wasStale {
+		return
+	}
+	log.Printf("The model is stale because %s; a compliance retrain is required.\n", reason)
+	for _, admin := range recipients {
+		tell(admin, fmt.Sprintf("The model still contains data that has been removed (%s). A retrain is required for compliance and will be started as soon as possible; until then, attributions to removed identities are withheld.", reason))
+	}
+}
+
+// Stale reports whether the model still holds data that has been removed since it was trained.
+func Stale() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return stale.After(trainedOn)
+}
+
+// enforce queues a compliance retrain if the model is stale and none is queued,
+// running or waiting out a failure.
+func enforce() {
+	mu.Lock()
+	due := stale.After(trainedOn) && current == nil && now().Sub(lastFailure) >= Cooldown
+	job := Job{Reason: staleReason, Compliance: true, Options: defaults}
+	mu.Unlock()
+	if !due {
+		return
+	}
+
+	if job, err := submit(job); err == nil {
+		log.Printf("Compliance retrain job %d queued: %s.\n", job.ID, job.Reason)
+	}
+}
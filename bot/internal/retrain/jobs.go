@@ -0,0 +1,348 @@
+// Package retrain runs model retraining as background jobs, one at a time, and
+// tells whoever asked for a job how it went once it is done. Jobs are requested
+// by users or started by the scheduler when the data has changed enough. Only a
+// successful retrain starts the cooldown before the next one may be requested.
+// Every finished job is recorded as a model run, from which the cooldown is
+// restored when the bot restarts. When data behind the model is removed, a
+// compliance retrain is forced regardless of the cooldown.
+package retrain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hearsay/internal/nlpclient"
+	"hearsay/internal/storage"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+type State string
+
+const (
+	Queued    State = "queued"
+	Running   State = "running"
+	Succeeded State = "succeeded"
+	Failed    State = "failed"
+)
+
+// Cooldown is how long after a successful retrain the next one may be requested.
+const Cooldown = 2 * time.Hour
+
+var (
+	// ErrBusy is returned while another job is queued or running.
+	ErrBusy = errors.New("a retrain is already queued or running")
+	// ErrCooldown is returned when the model was retrained too recently.
+	ErrCooldown = errors.New("the model was retrained too recently")
+)
+
+type Job struct {
+	ID int
+	// Requester is the nick that asked for the job and is told how it went. It is
+	// empty for jobs the scheduler started, whose Reason says why.
+	Requester string
+	Reason    string
+	// Compliance jobs remove data that has been purged or opted out from the
+	// model. They skip the cooldown, and admins are told how they went.
+	Compliance bool
+	Options    nlpclient.RetrainOptions
+	State      State
+	Queued     time.Time
+	Started    time.Time
+	Finished   time.Time
+	Result     nlpclient.RetrainResult
+	Err        error
+	// DataUntil is when the newest stored message was sent as the job started.
+	DataUntil time.Time
+}
+
+var (
+	mu          sync.Mutex
+	current     *Job // queued or running
+	last        *Job // the most recent job to finish
+	lastID      int
+	lastSuccess time.Time
+	// trainedOn is when the last successful job started, which is as recent as the data behind the model gets.
+	trainedOn time.Time
+	// announce is the channel the results of scheduled jobs are posted to, if any.
+	announce string
+	queue    = make(chan *Job, 1)
+	notify   = func(nick string, message string) {}
+	now      = time.Now
+)
+
+// SetNotifier sets how requesters are told that their job has finished.
+func SetNotifier(fn func(nick string, message string)) {
+	mu.Lock()
+	defer mu.Unlock()
+	notify = fn
+}
+
+// Submit queues a retrain for requester and returns the queued job.
+func Submit(requester string, opts nlpclient.RetrainOptions) (Job, error) {
+	return submit(Job{Requester: requester, Options: opts})
+}
+
+// submit queues job, numbering it.
+func submit(job Job) (Job, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if current != nil {
+		return Job{}, ErrBusy
+	}
+	if cooldownLeft() > 0 && !job.Compliance {
+		return Job{}, ErrCooldown
+	}
+
+	lastID++
+	job.ID = lastID
+	job.State = Queued
+	job.Queued = now()
+	current = &job
+	queue <- current
+
+	return job, nil
+}
+
+// CooldownLeft returns how long until another retrain may be requested.
+func CooldownLeft() time.Duration {
+	mu.Lock()
+	defer mu.Unlock()
+	return cooldownLeft()
+}
+
+// cooldownLeft is CooldownLeft for callers that hold mu.
+func cooldownLeft() time.Duration {
+	if lastSuccess.IsZero() {
+		return 0
+	}
+	return max(0, Cooldown-now().Sub(lastSuccess))
+}
+
+// Status returns the job that is queued or running and the last one to finish, if any.
+func Status() (pending *Job, finished *Job) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if current != nil {
+		job := *current
+		pending = &job
+	}
+	if last != nil {
+		job := *last
+		finished = &job
+	}
+	return pending, finished
+}
+
+// Restore picks up job numbering, the cooldown, what the model was trained on and
+// whether data has been removed since from store. It must be called before jobs
+// are submitted.
+func Restore(store storage.Store) error {
+	runs, err := store.ModelRuns(1)
+	if err != nil {
+		return err
+	}
+	run, ok, err := store.LastModelRun()
+	if err != nil {
+		return err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(runs) > 0 {
+		lastID = int(runs[0].ID)
+	}
+	if ok {
+		lastSuccess = run.Finished
+		trainedOn = run.Started
+	}
+
+	removed, err := store.RemovedSince(trainedOn)
+	if err != nil {
+		return err
+	}
+	if removed > 0 {
+		stale = now()
+		staleReason = "data was removed since the model was trained"
+		log.Printf("The model is stale because data was removed %d time(s) by purges, selective deletions or opt-outs since it was trained; a compliance retrain is required.\n", removed)
+	}
+	return nil
+}
+
+// Run carries out queued jobs with client, recording them in store, until ctx is
+// canceled. Between jobs it queues a compliance retrain whenever one is due.
+func Run(ctx context.Context, client *nlpclient.Client, store storage.Store) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	enforce()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-queue:
+			execute(ctx, client, store, job)
+			enforce()
+		case <-wake:
+			enforce()
+		case <-ticker.C:
+			enforce()
+		}
+	}
+}
+
+func execute(ctx context.Context, client *nlpclient.Client, store storage.Store, job *Job) {
+	dataUntil, err := store.NewestMessage()
+	if err != nil {
+		log.Printf("Failed to look up the newest message for retrain job %d: %s\n", job.ID, err.Error())
+	}
+
+	mu.Lock()
+	job.State = Running
+	job.Started = now()
+	job.DataUntil = dataUntil
+	mu.Unlock()
+	log.Printf("Retrain job %d started (%s).\n", job.ID, origin(*job))
+
+	result, err := client.Retrain(ctx, job.Options)
+
+	mu.Lock()
+	job.Finished = now()
+	job.Result = result
+	job.Err = err
+	if err != nil {
+		job.State = Failed
+		if job.Requester == "" {
+			lastFailure = job.Finished
+		}
+	} else {
+		job.State = Succeeded
+		lastSuccess = job.Finished
+		trainedOn = job.Started
+	}
+	current = nil
+	last = job
+	finished := *job
+	tell := notify
+	channel := announce
+	recipients := admins
+	mu.Unlock()
+
+	if err != nil {
+		log.Printf("Retrain job %d failed: %s\n", job.ID, err.Error())
+	} else {
+		log.Printf("Retrain job %d succeeded in %.2f seconds.\n", job.ID, result.Seconds)
+	}
+	if err := store.RecordModelRun(record(finished)); err != nil {
+		log.Printf("Failed to record retrain job %d: %s\n", job.ID, err.Error())
+	}
+	if finished.Requester != "" {
+		tell(finished.Requester, Describe(finished))
+	} else if channel != "" {
+		tell(channel, Describe(finished))
+	}
+	if finished.Compliance {
+		message := Describe(finished)
+		if finished.State == Failed {
+			message += fmt.Sprintf(" The model still holds removed data; the retrain will be tried again in %d hours.", int(Cooldown.Hours()))
+		}
+		for _, admin := range recipients {
+			tell(admin, message)
+		}
+	}
+}
+
+// record turns a finished job into the model run that is stored.
+func record(job Job) storage.ModelRun {
+	run := storage.ModelRun{
+		ID:              int64(job.ID),
+		Requester:       job.Requester,
+		Reason:          job.Reason,
+		ConfusionMatrix: job.Options.ConfusionMatrix,
+		Bert:            job.Options.Bert,
+		PastDays:        job.Options.PastDays,
+		Succeeded:       job.State == Succeeded,
+		Started:         job.Started,
+		Finished:        job.Finished,
+	}
+	if !job.DataUntil.IsZero() {
+		run.DataUntil = &job.DataUntil
+	}
+	if job.Err != nil {
+		run.Error = job.Err.Error()
+		return run
+	}
+
+	run.FitSeconds = job.Result.Seconds
+	run.Labels = job.Result.Labels
+	run.Samples = job.Result.Samples
+	if job.Options.ConfusionMatrix {
+		run.Accuracy = &job.Result.Accuracy
+		run.F1 = &job.Result.F1
+	}
+	return run
+}
+
+// Describe sums up how a job went, or how far it has come.
+func Describe(job Job) string {
+	name := fmt.Sprintf("Retrain #%d%s", job.ID, flags(job.Options))
+	switch job.State {
+	case Queued:
+		return fmt.Sprintf("%s, %s, is queued.", name, origin(job))
+	case Running:
+		return fmt.Sprintf("%s, %s, has been running for %s.", name, origin(job), now().Sub(job.Started).Round(time.Second))
+	}
+
+	// Results of scheduled jobs are posted to a channel, so they say why the job ran.
+	if job.Requester == "" {
+		name += ", " + origin(job) + ","
+	}
+	if job.State == Failed {
+		reason := "the NLP API returned an error"
+		if nlpclient.TimedOut(job.Err) {
+			reason = "the NLP API took too long to answer"
+		}
+		description := fmt.Sprintf("%s failed after %s: %s.", name, job.Finished.Sub(job.Started).Round(time.Second), reason)
+		if job.Requester != "" {
+			description += " The cooldown was not used up, so you can try again."
+		}
+		return description
+	}
+
+	description := fmt.Sprintf("%s succeeded. The SVM model took \x02%.2f\x02 seconds to fit.", name, job.Result.Seconds)
+	if job.Result.ConfusionMatrixURL != "" {
+		description += fmt.Sprintf(" \x02Confusion matrix\x02: %s | \x025-fold CV\x02: Accuracy %.4f, F1 score %.4f", job.Result.ConfusionMatrixURL, job.Result.Accuracy, job.Result.F1)
+	}
+	return description
+}
+
+// origin says who or what started a job.
+func origin(job Job) string {
+	if job.Requester == "" {
+		return "started automatically because " + job.Reason
+	}
+	return "requested by " + job.Requester
+}
+
+// flags renders the options a job was requested with the way they are typed.
+func flags(opts nlpclient.RetrainOptions) string {
+	var parts []string
+	if opts.ConfusionMatrix {
+		parts = append(parts, "--cm")
+	}
+	if opts.Bert {
+		parts = append(parts, "--bert")
+	}
+	if opts.PastDays > 0 {
+		parts = append(parts, fmt.Sprintf("--past %d", opts.PastDays))
+	}
+
+	if len(parts) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(parts, " ") + ")"
+}
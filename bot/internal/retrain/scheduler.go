@@ -0,0 +1,176 @@
+package retrain
+
+import (
+	"context"
+	"fmt"
+	"hearsay/internal/storage"
+	"log"
+	"strings"
+	"time"
+)
+
+// checkInterval is how often the scheduler looks at what has changed.
+const checkInterval = 10 * time.Minute
+
+// Policy decides when the scheduler retrains on its own.
+type Policy struct {
+	// NewMessages is how many messages eligible users must have sent since the
+	// model was trained to warrant a retrain. Zero leaves message counts out.
+	NewMessages int
+	// Quota and People are the message quota and the number of people who must
+	// meet it, as for a requested retrain.
+	Quota  int
+	People int
+	// Window is the time of day retrains may be started in.
+	Window Window
+	// Announce is the channel results are posted to, if any.
+	Announce string
+}
+
+// Window is a daily span of local time. The zero Window spans the whole day.
+type Window struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// ParseWindow parses "HH:MM-HH:MM". The span may run past midnight, as in
+// "22:00-06:00". An empty string is the whole day.
+func ParseWindow(s string) (Window, error) {
+	if s == "" {
+		return Window{}, nil
+	}
+
+	start, end, ok := strings.Cut(s, "-")
+	if !ok {
+		return Window{}, fmt.Errorf("window %q is not of the form HH:MM-HH:MM", s)
+	}
+	var w Window
+	for _, bound := range []struct {
+		text string
+		into *time.Duration
+	}{{start, &w.Start}, {end, &w.End}} {
+		t, err := time.Parse("15:04", strings.TrimSpace(bound.text))
+		if err != nil {
+			return Window{}, fmt.Errorf("window %q is not of the form HH:MM-HH:MM", s)
+		}
+		*bound.into = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	}
+	return w, nil
+}
+
+// Contains reports whether t falls within the window.
+func (w Window) Contains(t time.Time) bool {
+	if w.Start == w.End {
+		return true
+	}
+
+	year, month, day := t.Date()
+	offset := t.Sub(time.Date(year, month, day, 0, 0, 0, 0, t.Location()))
+	if w.Start < w.End {
+		return offset >= w.Start && offset < w.End
+	}
+	return offset >= w.Start || offset < w.End
+}
+
+var (
+	lastDeletion time.Time
+	// lastFailure is when a scheduled job last failed. The scheduler waits out the
+	// cooldown after it as well, so an unreachable API is not asked again every check.
+	lastFailure time.Time
+)
+
+// NoteDeletion tells the scheduler that data has been purged, so the model was
+// trained on messages that are gone.
+func NoteDeletion() {
+	mu.Lock()
+	defer mu.Unlock()
+	lastDeletion = now()
+}
+
+// Schedule starts a retrain whenever enough has changed since the model was last
+// trained: policy.NewMessages new messages from eligible users, someone reaching
+// the message quota, or a completed deletion. It only does so within the window
+// and outside the cooldown, and stops once ctx is canceled.
+func Schedule(ctx context.Context, store storage.Store, policy Policy) {
+	mu.Lock()
+	announce = policy.Announce
+	// Until a retrain succeeds, what the model was trained on is unknown; start counting now.
+	started := now()
+	mu.Unlock()
+
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			check(store, policy, started)
+		}
+	}
+}
+
+func check(store storage.Store, policy Policy, started time.Time) {
+	mu.Lock()
+	since := trainedOn
+	if since.IsZero() {
+		since = started
+	}
+	deleted := lastDeletion.After(since)
+	waiting := current != nil || cooldownLeft() > 0 || now().Sub(lastFailure) < Cooldown || !policy.Window.Contains(now())
+	mu.Unlock()
+	if waiting {
+		return
+	}
+
+	users, err := store.EligibleUsers(policy.Quota, since)
+	if err != nil {
+		log.Printf("Failed to count eligible users for the retrain scheduler: %s\n", err.Error())
+		return
+	}
+	if len(users) < policy.People {
+		return
+	}
+
+	reasons := changes(users, policy, deleted)
+	if len(reasons) == 0 {
+		return
+	}
+
+	mu.Lock()
+	opts := defaults
+	mu.Unlock()
+	job, err := submit(Job{Reason: strings.Join(reasons, ", "), Options: opts})
+	if err != nil {
+		// A user requested a retrain in the meantime.
+		return
+	}
+	log.Printf("Scheduled retrain job %d queued: %s.\n", job.ID, job.Reason)
+}
+
+// changes lists what has changed since the model was trained that calls for a retrain.
+func changes(users []storage.EligibleUser, policy Policy, deleted bool) []string {
+	var reasons []string
+
+	messages, crossed := 0, 0
+	for _, user := range users {
+		messages += user.Since
+		if user.Messages-user.Since < policy.Quota {
+			crossed++
+		}
+	}
+	if policy.NewMessages > 0 && messages >= policy.NewMessages {
+		reasons = append(reasons, fmt.Sprintf("%d new messages were sent", messages))
+	}
+	if crossed == 1 {
+		reasons = append(reasons, "someone reached the message quota")
+	} else if crossed > 1 {
+		reasons = append(reasons, fmt.Sprintf("%d people reached the message quota", crossed))
+	}
+	if deleted {
+		reasons = append(reasons, "data was purged")
+	}
+
+	return reasons
+}
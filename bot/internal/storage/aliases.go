@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"fmt"
+	"hearsay/internal/identity"
+)
+
+// LinkAlias merges the identity alias into canonical and updates the in-memory
+// alias and opt-in caches to match.
+func LinkAlias(alias string, canonical string, maxProfiles int, store Store) error {
+	if err := store.LinkAlias(alias, canonical, maxProfiles); err != nil {
+		return err
+	}
+
+	opt, err := store.OptStatus(canonical)
+	if err != nil {
+		return err
+	}
+
+	identity.SetAlias(alias, canonical)
+	SetOptIn(alias, false)
+	SetOptIn(canonical, opt)
+	return nil
+}
+
+func LoadAliases(store Store) error {
+	aliases, err := store.Aliases()
+	if err != nil {
+		return err
+	}
+
+	for alias, canonical := range aliases {
+		identity.SetAlias(alias, canonical)
+	}
+
+	return nil
+}
+
+// mergedProfileName returns name, or name-2, name-3 and so on if taken says it is
+// already in use, for a profile moving to an identity that has one of that name.
+func mergedProfileName(name string, taken func(string) bool) string {
+	merged := name
+	for n := 2; taken(merged); n++ {
+		merged = fmt.Sprintf("%s-%d", name, n)
+	}
+	return merged
+}
@@ -2,74 +2,59 @@ package storage
 
 import (
 	"database/sql"
+	"fmt"
 	"log"
 
+	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 )
 
-func openDbHelper(path string) *sql.DB {
-	db, err := sql.Open("sqlite3", path)
-	if err != nil {
-		log.Fatalf("Error opening %s: %v\n", path, err.Error())
-	}
-
-	err = db.Ping()
-	if err != nil {
-		log.Fatalf("Error connecting to %s: %v\n", path, err)
+// OpenStore opens the database for driver ("sqlite3" or "postgres") without
+// touching its schema. For SQLite the DSN is the path of the database file.
+func OpenStore(driver string, dsn string) (*SQLStore, error) {
+	var store *SQLStore
+	switch driver {
+	case "sqlite3":
+		// Foreign keys are set in the DSN so that every pooled connection enforces them,
+		// not just the one a PRAGMA happens to run on.
+		db, err := sql.Open("sqlite3", "file:"+dsn+"?_foreign_keys=on")
+		if err != nil {
+			return nil, err
+		}
+		store = NewSQLiteStore(db)
+	case "postgres":
+		db, err := sql.Open("postgres", dsn)
+		if err != nil {
+			return nil, err
+		}
+		store = NewPostgresStore(db)
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", driver)
 	}
 
-	_, err = db.Exec("PRAGMA foreign_keys = ON")
-	if err != nil {
-		log.Fatalf("Failed to enable foreign keys: %s\n", err.Error())
+	if err := store.db.Ping(); err != nil {
+		store.Close()
+		return nil, err
 	}
 
-	return db
+	return store, nil
 }
 
-func InitDatabase() (*sql.DB, error) {
-	db := openDbHelper("data/database.db")
-	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS messages(
-	id INTEGER PRIMARY KEY,
-	nick TEXT NOT NULL,
-	channel TEXT NOT NULL,
-	message TEXT NOT NULL,
-	time DATETIME DEFAULT CURRENT_TIMESTAMP,
-	FOREIGN KEY(nick) REFERENCES users(nick) ON DELETE CASCADE
-	)`)
-	if err != nil {
-		log.Fatalf("Error creating messages table: %v\n", err.Error())
-		return nil, err
-	}
-
-	_, err = db.Exec("CREATE INDEX IF NOT EXISTS idx_nick ON messages(nick)")
+// InitStore opens the database and brings its schema up to date.
+func InitStore(driver string, dsn string) (*SQLStore, error) {
+	store, err := OpenStore(driver, dsn)
 	if err != nil {
-		log.Fatalf("Error indexing nick: %v\n", err.Error())
 		return nil, err
 	}
 
-	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS users(
-	nick TEXT PRIMARY KEY,
-	registered DATETIME DEFAULT CURRENT_TIMESTAMP,
-	opt BOOL DEFAULT FALSE,
-	deletion DATETIME
-	)`)
+	applied, err := store.Migrate()
 	if err != nil {
-		log.Fatalf("Error creating users table: %v\n", err.Error())
-		return nil, err
+		store.Close()
+		return nil, fmt.Errorf("migrating database: %w", err)
 	}
-
-	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS profiles(
-	id INTEGER PRIMARY KEY,
-	nick TEXT,
-	name TEXT,
-	messages TEXT DEFAULT "",
-	created DATETIME DEFAULT CURRENT_TIMESTAMP,
-	FOREIGN KEY(nick) REFERENCES users(nick) ON DELETE CASCADE
-	)`)
-	if err != nil {
-		log.Fatalf("Error creating profiles table: %v\n", err.Error())
-		return nil, err
+	if applied > 0 {
+		log.Printf("Applied %d database migrations.\n", applied)
 	}
 
-	return db, nil
+	return store, nil
 }
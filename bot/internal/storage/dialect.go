@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// dialect captures the few places where SQLite and PostgreSQL disagree.
+// Queries are written with ? placeholders and portable syntax
+// (ON CONFLICT, excluded.*) wherever both accept it.
+type dialect struct {
+	name string
+	// numbered rewrites ? placeholders to $1, $2, ... as PostgreSQL expects.
+	numbered bool
+	// tableExists takes one parameter, the table name.
+	tableExists string
+	// timestamp is the column type for points in time.
+	timestamp string
+	// instant wraps a time column or parameter so that comparing and ordering
+	// follow the actual point in time, whatever offset it was written with.
+	instant string
+}
+
+var sqliteDialect = dialect{
+	name:        "sqlite3",
+	tableExists: "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?",
+	timestamp:   "DATETIME",
+	instant:     "julianday(%s)",
+}
+
+var postgresDialect = dialect{
+	name:        "postgres",
+	numbered:    true,
+	tableExists: "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = ?",
+	timestamp:   "TIMESTAMPTZ",
+	instant:     "%s",
+}
+
+// at returns instant applied to expr, a column name or a placeholder.
+func (d dialect) at(expr string) string {
+	return fmt.Sprintf(d.instant, expr)
+}
+
+// rebind converts ? placeholders for dialects that number them. Our queries
+// never contain a literal question mark, so no quoting rules are needed.
+func (d dialect) rebind(query string) string {
+	if !d.numbered || !strings.Contains(query, "?") {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
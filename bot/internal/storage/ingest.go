@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// How long Submit waits for room in a full queue before giving up on a message.
+const ingestBackpressureWait = time.Second
+
+// Bounds for the delay between attempts to replay the spool while the database is failing.
+const spoolRetryMin = 30 * time.Second
+const spoolRetryMax = 10 * time.Minute
+
+// Ingestor batches incoming messages on a single worker goroutine and writes them
+// to the database once a batch is full or its oldest message has waited maxLatency.
+// Batches the database rejects go to the spool and are replayed later.
+type Ingestor struct {
+	store      Store
+	spool      *Spool
+	queue      chan Message
+	batchSize  int
+	maxLatency time.Duration
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+
+	dropped atomic.Int64
+	stalled atomic.Int64
+}
+
+func NewIngestor(store Store, spool *Spool, batchSize int, maxLatency time.Duration, queueSize int) *Ingestor {
+	in := &Ingestor{
+		store:      store,
+		spool:      spool,
+		queue:      make(chan Message, queueSize),
+		batchSize:  batchSize,
+		maxLatency: maxLatency,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	go in.run()
+
+	return in
+}
+
+// Submit queues a message for writing. If the queue is full it waits briefly
+// for the worker to catch up and drops the message if it doesn't.
+func (in *Ingestor) Submit(message Message) {
+	select {
+	case in.queue <- message:
+		return
+	case <-in.stop:
+		in.drop("ingestor is shut down")
+		return
+	default:
+	}
+
+	stalled := in.stalled.Add(1)
+	log.Printf("Ingestion queue full (%d/%d); applying backpressure (%d stalls so far).\n", len(in.queue), cap(in.queue), stalled)
+
+	timer := time.NewTimer(ingestBackpressureWait)
+	defer timer.Stop()
+	select {
+	case in.queue <- message:
+	case <-timer.C:
+		in.drop("queue stayed full")
+	case <-in.stop:
+		in.drop("ingestor is shut down")
+	}
+}
+
+func (in *Ingestor) drop(reason string) {
+	dropped := in.dropped.Add(1)
+	log.Printf("Dropped incoming message: %s (%d dropped so far).\n", reason, dropped)
+}
+
+// Close flushes everything still queued and waits for the worker to finish.
+func (in *Ingestor) Close() {
+	in.stopOnce.Do(func() {
+		close(in.stop)
+	})
+	<-in.done
+}
+
+func (in *Ingestor) run() {
+	defer close(in.done)
+
+	batch := make([]Message, 0, in.batchSize)
+	// The timer only runs while the batch is non-empty.
+	timer := time.NewTimer(in.maxLatency)
+	timer.Stop()
+
+	// Replays of the spool back off while the database keeps failing.
+	retryDelay := spoolRetryMin
+	retry := time.NewTimer(0)
+	if in.spool.Len() == 0 {
+		retry.Stop()
+	}
+	replay := func() {
+		if err := in.spool.Replay(in.store); err != nil {
+			log.Printf("Failed to replay spool (%d messages waiting), retrying in %v: %s\n", in.spool.Len(), retryDelay, err.Error())
+			retry.Reset(retryDelay)
+			retryDelay = min(2*retryDelay, spoolRetryMax)
+			return
+		}
+		retry.Stop()
+		retryDelay = spoolRetryMin
+	}
+
+	flush := func(reason string) {
+		timer.Stop()
+		if len(batch) == 0 {
+			return
+		}
+		if in.write(batch, reason) && in.spool.Len() > 0 {
+			// The database is accepting writes again.
+			replay()
+		} else if in.spool.Len() > 0 {
+			retry.Reset(retryDelay)
+		}
+		batch = make([]Message, 0, in.batchSize)
+	}
+
+	for {
+		select {
+		case message := <-in.queue:
+			if len(batch) == 0 {
+				timer.Reset(in.maxLatency)
+			}
+			batch = append(batch, message)
+			if len(batch) >= in.batchSize {
+				flush("batch full")
+			}
+
+		case <-timer.C:
+			flush("max latency reached")
+
+		case <-retry.C:
+			replay()
+
+		case <-in.stop:
+			// We are the only reader, so whatever is queued now can be taken without blocking.
+			for len(in.queue) > 0 {
+				batch = append(batch, <-in.queue)
+			}
+			flush("shutdown")
+			log.Printf("Ingestor stopped. %d messages were dropped during this run, %d remain spooled.\n", in.dropped.Load(), in.spool.Len())
+			return
+		}
+	}
+}
+
+// write stores a batch, spooling it on failure. It reports whether the database accepted it.
+func (in *Ingestor) write(batch []Message, reason string) bool {
+	err := in.store.SubmitMessages(batch)
+	if err != nil {
+		log.Printf("Failed to submit %d messages, spooling them: %v\n", len(batch), err)
+		if err := in.spool.Append(batch); err != nil {
+			log.Printf("Failed to spool %d messages; they are lost: %s\n", len(batch), err.Error())
+		}
+		return false
+	}
+	log.Printf("Wrote %d messages to database (%s).\n", len(batch), reason)
+	return true
+}
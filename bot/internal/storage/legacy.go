@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"hearsay/internal/identity"
+	"log"
+	"strings"
+	"sync"
+)
+
+// Data stored before accounts were tracked is keyed by the bare nick it came
+// from, which is nobody's key once services say who is behind the nick. Those
+// users are listed in legacy_nicks when the database is upgraded, and the first
+// time such a nick is seen with its services status known, its data is linked
+// to the nick's key as an alias, just as +alias link would.
+
+var legacyMu sync.Mutex
+var legacyNicks = make(map[string]string) // lowercased nick -> nick as stored
+
+func LoadLegacyNicks(store Store) error {
+	nicks, err := store.LegacyNicks()
+	if err != nil {
+		return err
+	}
+
+	legacyMu.Lock()
+	defer legacyMu.Unlock()
+	for _, nick := range nicks {
+		legacyNicks[strings.ToLower(nick)] = nick
+	}
+
+	return nil
+}
+
+// ClaimLegacyNick links the legacy data of nick, if there is any, to the key it
+// is now stored under. Each nick is only tried once per run, so a link refused
+// for too many profiles is tried again after a restart.
+func ClaimLegacyNick(nick string, maxProfiles int, store Store) {
+	if !identity.Known(nick) {
+		return
+	}
+
+	legacyMu.Lock()
+	legacy, ok := legacyNicks[strings.ToLower(nick)]
+	delete(legacyNicks, strings.ToLower(nick))
+	legacyMu.Unlock()
+	if !ok {
+		return
+	}
+
+	key := identity.Key(nick)
+	if key == legacy {
+		// Logged into an account named like the nick, which already holds the data.
+		if err := store.DropLegacyNick(legacy); err != nil {
+			log.Printf("Failed to drop legacy nick %s: %s\n", legacy, err.Error())
+		}
+		return
+	}
+	if err := LinkAlias(legacy, key, maxProfiles, store); err != nil {
+		log.Printf("Failed to link legacy nick %s to %s: %s\n", legacy, key, err.Error())
+		return
+	}
+	log.Printf("Linked legacy nick %s to %s.\n", legacy, key)
+}
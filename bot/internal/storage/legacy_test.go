@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"hearsay/internal/identity"
+	"reflect"
+	"testing"
+)
+
+func TestClaimLegacyNick(t *testing.T) {
+	for _, kind := range sqlKinds {
+		t.Run(kind.name, func(t *testing.T) {
+			store, err := kind.open(t)
+			if err != nil {
+				t.Fatalf("Failed to open %s store: %s", kind.name, err)
+			}
+			defer store.Close()
+			defer identity.Clear()
+
+			must(t, store.ensureMigrationsTable())
+			must(t, store.applyMigration(migrations[0]))
+			for _, nick := range []string{"alice", "bob", "carol", "dave"} {
+				if _, err := store.exec("INSERT INTO users (nick, opt) VALUES (?, ?)", nick, true); err != nil {
+					t.Fatal(err)
+				}
+				SetOptIn(nick, true)
+				defer SetOptIn(nick, false)
+			}
+			_, err = store.Migrate()
+			must(t, err)
+			must(t, store.SubmitMessages([]Message{message("alice", "#a", "one", 0)}))
+			must(t, LoadLegacyNicks(store))
+
+			identity.SetAccount("alice", "alicia")
+			identity.SetAccount("bob", "")
+			identity.SetAccount("carol", "carol")
+			for _, nick := range []string{"alice", "bob", "carol", "dave"} {
+				ClaimLegacyNick(nick, 3, store)
+			}
+			defer identity.RemoveAlias("alice")
+			defer identity.RemoveAlias("bob")
+			defer SetOptIn("alicia", false)
+			defer SetOptIn(identity.NickPrefix+"bob", false)
+
+			if count, err := store.CountMessages("alicia"); err != nil || count != 1 {
+				t.Errorf("CountMessages(alicia) = %d, %v, want alice's message", count, err)
+			}
+			if got := identity.Key("alice"); got != "alicia" || !IsOptedIn(got) {
+				t.Errorf("Key(alice) = %q, opted in %v, want alicia opted in", got, IsOptedIn(got))
+			}
+			if opt, err := store.OptStatus(identity.NickPrefix + "bob"); err != nil || !opt {
+				t.Errorf("OptStatus(~bob) = %v, %v, want bob's opt-in", opt, err)
+			}
+			// carol's account holds her data already, and nothing is known about dave yet.
+			if nicks, err := store.LegacyNicks(); err != nil || !reflect.DeepEqual(nicks, []string{"dave"}) {
+				t.Errorf("LegacyNicks() = %v, %v, want [dave]", nicks, err)
+			}
+			if opt, err := store.OptStatus("carol"); err != nil || !opt {
+				t.Errorf("OptStatus(carol) = %v, %v, want her opt-in", opt, err)
+			}
+		})
+	}
+}
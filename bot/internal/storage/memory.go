@@ -0,0 +1,783 @@
+package storage
+
+import (
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a Store kept entirely in memory, for tests and dry runs.
+type MemoryStore struct {
+	mu       sync.Mutex
+	users    map[string]*memoryUser
+	messages []Message
+	profiles map[string]map[string]*memoryProfile // owner -> name -> profile
+	aliases  map[string]string
+	forgets  []ScheduledForget
+	runs     []ModelRun
+	lastID   int64
+	now      func() time.Time
+	// purged is when each user with messages was deleted, and when each
+	// selective deletion that removed messages was carried out.
+	purged []time.Time
+}
+
+type memoryUser struct {
+	registered time.Time
+	opt        bool
+	deletion   *time.Time
+	consent    []ConsentChange
+}
+
+type memoryProfile struct {
+	messages []ProfileMessage
+	shares   map[string]Permission
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users:    make(map[string]*memoryUser),
+		profiles: make(map[string]map[string]*memoryProfile),
+		aliases:  make(map[string]string),
+		now:      time.Now,
+	}
+}
+
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+// user returns the users row for key, creating it like INSERT OR IGNORE would.
+func (m *MemoryStore) user(key string, registered time.Time) *memoryUser {
+	u, ok := m.users[key]
+	if !ok {
+		u = &memoryUser{registered: registered}
+		m.users[key] = u
+	}
+	return u
+}
+
+func (m *MemoryStore) OptStatus(key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[key]
+	if !ok {
+		return false, ErrNotFound
+	}
+	return u.opt, nil
+}
+
+func (m *MemoryStore) SetOpt(key string, opt bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, known := m.users[key]
+	u := m.user(key, m.now())
+	if !known || u.opt != opt {
+		u.consent = append(u.consent, ConsentChange{opt, m.now()})
+	}
+	u.opt = opt
+	return nil
+}
+
+func (m *MemoryStore) OptedIn() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := []string{}
+	for key, u := range m.users {
+		if u.opt {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (m *MemoryStore) DeletionScheduled(key string) (time.Time, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[key]
+	if !ok {
+		return time.Time{}, false, ErrNotFound
+	}
+	if u.deletion == nil {
+		return time.Time{}, false, nil
+	}
+	return *u.deletion, true, nil
+}
+
+func (m *MemoryStore) ScheduleDeletion(key string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[key]
+	if !ok {
+		return ErrNotFound
+	}
+	u.deletion = &at
+	return nil
+}
+
+func (m *MemoryStore) CancelDeletion(key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[key]
+	if !ok || u.deletion == nil {
+		return false, nil
+	}
+	u.deletion = nil
+	return true, nil
+}
+
+func (m *MemoryStore) DeletionsDue(now time.Time) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := []string{}
+	for key, u := range m.users {
+		if u.deletion != nil && !u.deletion.After(now) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (m *MemoryStore) DeleteUser(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[key]; !ok {
+		return ErrNotFound
+	}
+
+	if slices.ContainsFunc(m.messages, func(message Message) bool { return message.Nick == key }) {
+		m.purged = append(m.purged, m.now())
+	}
+	delete(m.users, key)
+	delete(m.profiles, key)
+	kept := m.messages[:0]
+	for _, message := range m.messages {
+		if message.Nick != key {
+			kept = append(kept, message)
+		}
+	}
+	m.messages = kept
+	for alias, canonical := range m.aliases {
+		if canonical == key {
+			delete(m.aliases, alias)
+		}
+	}
+	m.forgets = slices.DeleteFunc(m.forgets, func(f ScheduledForget) bool { return f.Key == key })
+	for _, profiles := range m.profiles {
+		for _, profile := range profiles {
+			delete(profile.shares, key)
+			for i := range profile.messages {
+				if profile.messages[i].Author == key {
+					profile.messages[i].Author = ""
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) User(key string) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[key]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	return User{key, u.registered, u.opt, u.deletion}, nil
+}
+
+func (m *MemoryStore) ConsentHistory(key string) ([]ConsentChange, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if u, ok := m.users[key]; ok {
+		return append([]ConsentChange{}, u.consent...), nil
+	}
+	return []ConsentChange{}, nil
+}
+
+func (m *MemoryStore) WalkMessages(key string, fn func(Message) error) error {
+	m.mu.Lock()
+	messages := []Message{}
+	for _, message := range m.messages {
+		if message.Nick == key {
+			messages = append(messages, message)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, message := range messages {
+		if err := fn(message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matching returns the indexes of key's messages that filter matches. Callers hold mu.
+func (m *MemoryStore) matching(key string, filter MessageFilter) []int {
+	matched := []int{}
+	for i, message := range m.messages {
+		if message.Nick == key && filter.Matches(message) {
+			matched = append(matched, i)
+		}
+	}
+
+	if filter.Last > 0 && len(matched) > filter.Last {
+		sort.SliceStable(matched, func(i, j int) bool {
+			return m.messages[matched[i]].Timestamp.Before(m.messages[matched[j]].Timestamp)
+		})
+		matched = matched[len(matched)-filter.Last:]
+	}
+	return matched
+}
+
+func (m *MemoryStore) CountMatching(key string, filter MessageFilter) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.matching(key, filter)), nil
+}
+
+func (m *MemoryStore) ScheduleForget(key string, filter MessageFilter, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[key]; !ok {
+		return ErrNotFound
+	}
+	m.lastID++
+	m.forgets = append(m.forgets, ScheduledForget{m.lastID, key, filter, at})
+	return nil
+}
+
+func (m *MemoryStore) CancelForgets(key string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	before := len(m.forgets)
+	m.forgets = slices.DeleteFunc(m.forgets, func(f ScheduledForget) bool { return f.Key == key })
+	return before - len(m.forgets), nil
+}
+
+func (m *MemoryStore) ForgetsDue(now time.Time) ([]ScheduledForget, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	due := []ScheduledForget{}
+	for _, forget := range m.forgets {
+		if !forget.Due.After(now) {
+			due = append(due, forget)
+		}
+	}
+	return due, nil
+}
+
+func (m *MemoryStore) Forget(id int64) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := slices.IndexFunc(m.forgets, func(f ScheduledForget) bool { return f.ID == id })
+	if i < 0 {
+		return 0, ErrNotFound
+	}
+	forget := m.forgets[i]
+	m.forgets = slices.Delete(m.forgets, i, i+1)
+
+	doomed := make(map[int]bool)
+	for _, index := range m.matching(forget.Key, forget.Filter) {
+		doomed[index] = true
+	}
+	kept := m.messages[:0]
+	for index, message := range m.messages {
+		if !doomed[index] {
+			kept = append(kept, message)
+		}
+	}
+	m.messages = kept
+	if len(doomed) > 0 {
+		m.purged = append(m.purged, m.now())
+	}
+	return len(doomed), nil
+}
+
+// SubmitMessages skips messages already stored, like the unique index on messages.hash.
+func (m *MemoryStore) SubmitMessages(messages []Message) error {
+	_, err := m.SubmitMessagesOnce(messages)
+	return err
+}
+
+func (m *MemoryStore) SubmitMessagesOnce(messages []Message) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored := m.hashes()
+
+	written := 0
+	for _, message := range dedupeMessages(messages) {
+		message.Content = strings.TrimSpace(message.Content)
+		if _, ok := stored[message.Hash()]; ok {
+			continue
+		}
+		m.user(message.Nick, message.Timestamp)
+		m.messages = append(m.messages, message)
+		written++
+	}
+	return written, nil
+}
+
+// hashes returns the hash of every stored message. Callers hold mu.
+func (m *MemoryStore) hashes() map[string]struct{} {
+	stored := make(map[string]struct{}, len(m.messages))
+	for _, message := range m.messages {
+		stored[message.Hash()] = struct{}{}
+	}
+	return stored
+}
+
+func (m *MemoryStore) CountStored(messages []Message) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored := m.hashes()
+
+	count := 0
+	for _, message := range dedupeMessages(messages) {
+		if _, ok := stored[message.Hash()]; ok {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *MemoryStore) canonical(key string) string {
+	if canonical, ok := m.aliases[key]; ok {
+		return canonical
+	}
+	return key
+}
+
+func (m *MemoryStore) CountMessages(key string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	count := 0
+	for _, message := range m.messages {
+		if m.canonical(message.Nick) == key {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *MemoryStore) CountEligible(quota int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	counts := make(map[string]int)
+	for _, message := range m.messages {
+		counts[m.canonical(message.Nick)]++
+	}
+
+	eligible := 0
+	for key, count := range counts {
+		if count >= quota && m.optedIn(key) {
+			eligible++
+		}
+	}
+	return eligible, nil
+}
+
+// optedIn reports whether key has a users row and is opted in. m.mu must be held.
+func (m *MemoryStore) optedIn(key string) bool {
+	u, ok := m.users[key]
+	return ok && u.opt
+}
+
+func (m *MemoryStore) NewestMessage() (time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var newest time.Time
+	for _, message := range m.messages {
+		if message.Timestamp.After(newest) {
+			newest = message.Timestamp
+		}
+	}
+	return newest, nil
+}
+
+func (m *MemoryStore) EligibleUsers(quota int, since time.Time) ([]EligibleUser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	counts := make(map[string]*EligibleUser)
+	for _, message := range m.messages {
+		key := m.canonical(message.Nick)
+		if counts[key] == nil {
+			counts[key] = &EligibleUser{Key: key}
+		}
+		counts[key].Messages++
+		if message.Timestamp.After(since) {
+			counts[key].Since++
+		}
+	}
+
+	users := []EligibleUser{}
+	for _, user := range counts {
+		if user.Messages >= quota && m.optedIn(user.Key) {
+			users = append(users, *user)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Key < users[j].Key })
+	return users, nil
+}
+
+func (m *MemoryStore) CountProfiles(owner string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.profiles[owner]), nil
+}
+
+func (m *MemoryStore) ProfileExists(owner string, name string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.profiles[owner][name]
+	return ok, nil
+}
+
+func (m *MemoryStore) ListProfiles(owner string) ([]ProfileSummary, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	profiles := []ProfileSummary{}
+	for name, profile := range m.profiles[owner] {
+		profiles = append(profiles, ProfileSummary{name, len(profile.messages)})
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name < profiles[j].Name })
+	return profiles, nil
+}
+
+func (m *MemoryStore) CreateProfile(owner string, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// Like the profiles table, this references a users row.
+	if _, ok := m.users[owner]; !ok {
+		return ErrNotFound
+	}
+	if m.profiles[owner] == nil {
+		m.profiles[owner] = make(map[string]*memoryProfile)
+	}
+	m.profiles[owner][name] = &memoryProfile{messages: []ProfileMessage{}, shares: make(map[string]Permission)}
+	return nil
+}
+
+func (m *MemoryStore) DeleteProfile(owner string, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.profiles[owner][name]; !ok {
+		return ErrNotFound
+	}
+	delete(m.profiles[owner], name)
+	return nil
+}
+
+func (m *MemoryStore) RenameProfile(owner string, name string, newName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	profile, ok := m.profiles[owner][name]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(m.profiles[owner], name)
+	m.profiles[owner][newName] = profile
+	return nil
+}
+
+func (m *MemoryStore) AppendProfile(owner string, name string, author string, message string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	profile, ok := m.profiles[owner][name]
+	if !ok {
+		return ErrNotFound
+	}
+	m.lastID++
+	profile.messages = append(profile.messages, ProfileMessage{m.lastID, message, author, m.now()})
+	return nil
+}
+
+func (m *MemoryStore) ProfileMessages(owner string, name string) ([]ProfileMessage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	profile, ok := m.profiles[owner][name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]ProfileMessage{}, profile.messages...), nil
+}
+
+func (m *MemoryStore) CountProfileMessages(owner string, name string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if profile, ok := m.profiles[owner][name]; ok {
+		return len(profile.messages), nil
+	}
+	return 0, nil
+}
+
+func (m *MemoryStore) RemoveProfileMessage(owner string, name string, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	profile, ok := m.profiles[owner][name]
+	if !ok {
+		return ErrNotFound
+	}
+	for i, message := range profile.messages {
+		if message.ID == id {
+			profile.messages = append(profile.messages[:i:i], profile.messages[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (m *MemoryStore) ClearProfile(owner string, name string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	profile, ok := m.profiles[owner][name]
+	if !ok {
+		return 0, nil
+	}
+	removed := len(profile.messages)
+	profile.messages = []ProfileMessage{}
+	return removed, nil
+}
+
+func (m *MemoryStore) ShareProfile(owner string, name string, key string, permission Permission) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	profile, ok := m.profiles[owner][name]
+	if !ok {
+		return ErrNotFound
+	}
+	if _, ok := m.users[key]; !ok {
+		return ErrNotFound
+	}
+	profile.shares[key] = permission
+	return nil
+}
+
+func (m *MemoryStore) UnshareProfile(owner string, name string, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	profile, ok := m.profiles[owner][name]
+	if !ok {
+		return ErrNotFound
+	}
+	if _, ok := profile.shares[key]; !ok {
+		return ErrNotFound
+	}
+	delete(profile.shares, key)
+	return nil
+}
+
+func (m *MemoryStore) ProfilePermission(owner string, name string, key string) (Permission, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if profile, ok := m.profiles[owner][name]; ok {
+		return profile.shares[key], nil
+	}
+	return PermissionNone, nil
+}
+
+func (m *MemoryStore) ListShares(owner string, name string) ([]ProfileShare, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	shares := []ProfileShare{}
+	if profile, ok := m.profiles[owner][name]; ok {
+		for key, permission := range profile.shares {
+			shares = append(shares, ProfileShare{key, permission})
+		}
+	}
+	sort.Slice(shares, func(i, j int) bool { return shares[i].Key < shares[j].Key })
+	return shares, nil
+}
+
+func (m *MemoryStore) SharedWith(key string) ([]SharedProfile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	shared := []SharedProfile{}
+	for owner, profiles := range m.profiles {
+		for name, profile := range profiles {
+			if permission, ok := profile.shares[key]; ok {
+				shared = append(shared, SharedProfile{owner, name, permission, len(profile.messages)})
+			}
+		}
+	}
+	sort.Slice(shared, func(i, j int) bool {
+		if shared[i].Owner != shared[j].Owner {
+			return shared[i].Owner < shared[j].Owner
+		}
+		return shared[i].Name < shared[j].Name
+	})
+	return shared, nil
+}
+
+func (m *MemoryStore) RecordModelRun(run ModelRun) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.runs = append(m.runs, run)
+	sort.Slice(m.runs, func(i, j int) bool { return m.runs[i].ID < m.runs[j].ID })
+	return nil
+}
+
+func (m *MemoryStore) ModelRuns(limit int) ([]ModelRun, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	runs := []ModelRun{}
+	for i := len(m.runs) - 1; i >= 0 && len(runs) < limit; i-- {
+		runs = append(runs, m.runs[i])
+	}
+	return runs, nil
+}
+
+func (m *MemoryStore) ModelRun(id int64) (ModelRun, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, run := range m.runs {
+		if run.ID == id {
+			return run, nil
+		}
+	}
+	return ModelRun{}, ErrNotFound
+}
+
+func (m *MemoryStore) LastModelRun() (ModelRun, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.runs) - 1; i >= 0; i-- {
+		if m.runs[i].Succeeded {
+			return m.runs[i], true, nil
+		}
+	}
+	return ModelRun{}, false, nil
+}
+
+func (m *MemoryStore) RemovedSince(since time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	removed := 0
+	for _, purged := range m.purged {
+		if purged.After(since) {
+			removed++
+		}
+	}
+	for _, u := range m.users {
+		for i, change := range u.consent {
+			if !change.Opt && change.Changed.After(since) && slices.ContainsFunc(u.consent[:i], func(c ConsentChange) bool { return c.Opt }) {
+				removed++
+				break
+			}
+		}
+	}
+	return removed, nil
+}
+
+func (m *MemoryStore) LinkAlias(alias string, canonical string, maxProfiles int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.profiles[alias])+len(m.profiles[canonical]) > maxProfiles {
+		return ErrTooManyProfiles
+	}
+
+	if _, ok := m.users[canonical]; !ok {
+		if u, ok := m.users[alias]; ok {
+			m.users[canonical] = &memoryUser{registered: u.registered, opt: u.opt}
+		} else {
+			m.users[canonical] = &memoryUser{registered: m.now()}
+		}
+	}
+	if u, ok := m.users[alias]; ok {
+		c := m.users[canonical]
+		c.consent = append(c.consent, u.consent...)
+		sort.SliceStable(c.consent, func(i, j int) bool { return c.consent[i].Changed.Before(c.consent[j].Changed) })
+		if u.deletion != nil && (c.deletion == nil || u.deletion.Before(*c.deletion)) {
+			c.deletion = u.deletion
+		}
+	}
+
+	// Hashes are worked out from the nick whenever they are needed, so moved
+	// messages are deduplicated under canonical from here on.
+	for i := range m.messages {
+		if m.messages[i].Nick == alias {
+			m.messages[i].Nick = canonical
+		}
+	}
+	if m.profiles[canonical] == nil {
+		m.profiles[canonical] = make(map[string]*memoryProfile)
+	}
+	for _, name := range slices.Sorted(maps.Keys(m.profiles[alias])) {
+		merged := mergedProfileName(name, func(candidate string) bool {
+			_, owned := m.profiles[canonical][candidate]
+			_, moving := m.profiles[alias][candidate]
+			return owned || (moving && candidate != name)
+		})
+		m.profiles[canonical][merged] = m.profiles[alias][name]
+		delete(m.profiles[alias], name)
+	}
+	delete(m.profiles, alias)
+	for _, profiles := range m.profiles {
+		for _, profile := range profiles {
+			if permission, ok := profile.shares[alias]; ok {
+				if _, ok := profile.shares[canonical]; !ok {
+					profile.shares[canonical] = permission
+				}
+				delete(profile.shares, alias)
+			}
+			for i := range profile.messages {
+				if profile.messages[i].Author == alias {
+					profile.messages[i].Author = canonical
+				}
+			}
+		}
+	}
+	for a, c := range m.aliases {
+		if c == alias {
+			m.aliases[a] = canonical
+		}
+	}
+	for i := range m.forgets {
+		if m.forgets[i].Key == alias {
+			m.forgets[i].Key = canonical
+		}
+	}
+
+	delete(m.users, alias)
+	m.aliases[alias] = canonical
+	return nil
+}
+
+func (m *MemoryStore) ListAliases(canonical string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := []string{}
+	for alias, c := range m.aliases {
+		if c == canonical {
+			list = append(list, alias)
+		}
+	}
+	sort.Strings(list)
+	return list, nil
+}
+
+func (m *MemoryStore) Aliases() (map[string]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	aliases := make(map[string]string, len(m.aliases))
+	for alias, canonical := range m.aliases {
+		aliases[alias] = canonical
+	}
+	return aliases, nil
+}
+
+// A MemoryStore starts out empty, so it never holds data from before accounts were tracked.
+func (m *MemoryStore) LegacyNicks() ([]string, error) {
+	return []string{}, nil
+}
+
+func (m *MemoryStore) DropLegacyNick(nick string) error {
+	return nil
+}
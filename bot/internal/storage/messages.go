@@ -1,78 +1,72 @@
 package storage
 
 import (
-	"database/sql"
+	"crypto/sha256"
+	"encoding/hex"
 	"log"
 	"strings"
 	"time"
 )
 
 type Message struct {
-	Nick      string
-	Content   string
-	Channel   string
-	Timestamp time.Time
+	Nick      string    `json:"nick"`
+	Content   string    `json:"content"`
+	Channel   string    `json:"channel"`
+	Timestamp time.Time `json:"time"`
 }
 
-func SubmitMessages(messages []Message, db *sql.DB) error {
-	tx, err := db.Begin()
+func FulfilsMessagesCount(nick string, quota int, store Store) (bool, int) {
+	count, err := store.CountMessages(nick)
 	if err != nil {
-		return err
+		log.Printf("Failed to count messages in FulfilsMessagesCount for nick %s: %s\n", nick, err.Error())
+		return false, 0
 	}
 
-	userInsertionStmt, err := tx.Prepare("INSERT OR IGNORE INTO users(nick, registered, opt, deletion) VALUES (?, ?, ?, ?)")
-	if err != nil {
-		return err
-	}
+	return (count >= quota), count
+}
 
-	messagesStmt, err := tx.Prepare("INSERT INTO messages (nick, channel, message, time) VALUES (?, ?, ?, ?)")
+func EnoughFulfilsMessagesCount(peopleQuota int, messageQuota int, store Store) bool {
+	count, err := store.CountEligible(messageQuota)
 	if err != nil {
-		return err
-	}
-
-	for _, message := range messages {
-		_, err = userInsertionStmt.Exec(message.Nick, message.Timestamp, false, nil)
-		if err != nil {
-			tx.Rollback()
-			return err
-		}
-
-		_, err := messagesStmt.Exec(message.Nick, message.Channel, strings.TrimSpace(message.Content), message.Timestamp)
-		if err != nil {
-			tx.Rollback()
-			return err
-		}
+		log.Printf("Failed to count messages in EnoughFulfilsMessagesCount: %s\n", err.Error())
 	}
 
-	return tx.Commit()
+	return (count >= peopleQuota)
 }
 
-func FulfilsMessagesCount(nick string, quota int, db *sql.DB) (bool, int) {
-	var count int
-	var currentCount int
+// Hash identifies a message by its nick, channel, time and text, which is what
+// the unique index on messages.hash deduplicates on. Times are taken to the
+// microsecond, the precision PostgreSQL keeps.
+func (m Message) Hash() string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{
+		m.Nick,
+		m.Channel,
+		m.Timestamp.UTC().Truncate(time.Microsecond).Format(time.RFC3339Nano),
+		strings.TrimSpace(m.Content),
+	}, "\x00")))
 
-	err := db.QueryRow("SELECT COUNT(*) FROM messages WHERE nick = ?", nick).Scan(&count)
-	if err != nil {
-		log.Printf("Failed to count messages in FulfilsMessagesCount for nick %s: %s\n", nick, err.Error())
-		return false, 0
-	}
+	return hex.EncodeToString(sum[:])
+}
 
-	err = db.QueryRow("SELECT COUNT(*) FROM messages WHERE nick = ?", nick).Scan(&currentCount)
-	if err != nil {
-		log.Printf("Failed to count individual messages in FulfilsMessagesCount: %s\n", err.Error())
-		return false, 0
+// dedupeMessages drops messages with the same hash as an earlier one.
+func dedupeMessages(messages []Message) []Message {
+	seen := make(map[string]struct{})
+	unique := make([]Message, 0, len(messages))
+	for _, message := range messages {
+		hash := message.Hash()
+		if _, ok := seen[hash]; ok {
+			continue
+		}
+		seen[hash] = struct{}{}
+		unique = append(unique, message)
 	}
 
-	return (count >= quota), currentCount
+	return unique
 }
 
-func EnoughFulfilsMessagesCount(peopleQuota int, messageQuota int, db *sql.DB) bool {
-	var count int
-
-	err := db.QueryRow("SELECT COUNT(*) FROM (SELECT nick FROM messages GROUP BY nick HAVING COUNT(*) >= ?)", messageQuota).Scan(&count)
-	if err != nil {
-		log.Printf("Failed to count messages in EnoughFulfilsMessagesCount: %s\n", err.Error())
-	}
-
-	return (count >= peopleQuota)
+// splitProfileMessages splits the concatenated messages column profiles had
+// before migration 3, which starts with a delimiter.
+func splitProfileMessages(messages string) []string {
+	parts := strings.Split(messages, "/:MSG/")
+	return parts[1:]
 }
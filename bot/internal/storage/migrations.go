@@ -0,0 +1,495 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// A migration moves the schema from version-1 to version. Migrations are applied
+// in order, each in its own transaction, and recorded in schema_migrations.
+// Never edit a migration that has shipped; add a new one instead.
+// statements are SQLite; postgres holds the equivalent PostgreSQL schema.
+// run, if set, moves data around after the statements have been executed.
+type migration struct {
+	version    int
+	name       string
+	statements []string
+	postgres   []string
+	run        func(tx *sql.Tx, d dialect) error
+}
+
+var migrations = []migration{
+	{1, "baseline", []string{
+		`CREATE TABLE IF NOT EXISTS messages(
+	id INTEGER PRIMARY KEY,
+	nick TEXT NOT NULL,
+	channel TEXT NOT NULL,
+	message TEXT NOT NULL,
+	time DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY(nick) REFERENCES users(nick) ON DELETE CASCADE
+	)`,
+		`CREATE INDEX IF NOT EXISTS idx_nick ON messages(nick)`,
+		`CREATE TABLE IF NOT EXISTS users(
+	nick TEXT PRIMARY KEY,
+	registered DATETIME DEFAULT CURRENT_TIMESTAMP,
+	opt BOOL DEFAULT FALSE,
+	deletion DATETIME
+	)`,
+		`CREATE TABLE IF NOT EXISTS profiles(
+	id INTEGER PRIMARY KEY,
+	nick TEXT,
+	name TEXT,
+	messages TEXT DEFAULT "",
+	created DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY(nick) REFERENCES users(nick) ON DELETE CASCADE
+	)`,
+	}, []string{
+		`CREATE TABLE IF NOT EXISTS users(
+	nick TEXT PRIMARY KEY,
+	registered TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	opt BOOLEAN DEFAULT FALSE,
+	deletion TIMESTAMPTZ
+	)`,
+		`CREATE TABLE IF NOT EXISTS messages(
+	id BIGSERIAL PRIMARY KEY,
+	nick TEXT NOT NULL REFERENCES users(nick) ON DELETE CASCADE,
+	channel TEXT NOT NULL,
+	message TEXT NOT NULL,
+	time TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+	)`,
+		`CREATE INDEX IF NOT EXISTS idx_nick ON messages(nick)`,
+		`CREATE TABLE IF NOT EXISTS profiles(
+	id BIGSERIAL PRIMARY KEY,
+	nick TEXT REFERENCES users(nick) ON DELETE CASCADE,
+	name TEXT,
+	messages TEXT DEFAULT '',
+	created TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+	)`,
+	}, nil},
+	{2, "aliases", []string{
+		// IF NOT EXISTS: this table was created outside of migrations before they existed.
+		`CREATE TABLE IF NOT EXISTS aliases(
+	alias TEXT PRIMARY KEY,
+	canonical TEXT NOT NULL,
+	linked DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY(canonical) REFERENCES users(nick) ON DELETE CASCADE
+	)`,
+	}, []string{
+		`CREATE TABLE IF NOT EXISTS aliases(
+	alias TEXT PRIMARY KEY,
+	canonical TEXT NOT NULL REFERENCES users(nick) ON DELETE CASCADE,
+	linked TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+	)`,
+	}, nil},
+	{3, "profile_messages", []string{
+		`CREATE TABLE profile_messages(
+	id INTEGER PRIMARY KEY,
+	profile INTEGER NOT NULL,
+	message TEXT NOT NULL,
+	added DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY(profile) REFERENCES profiles(id) ON DELETE CASCADE
+	)`,
+		`CREATE INDEX idx_profile ON profile_messages(profile)`,
+	}, []string{
+		`CREATE TABLE profile_messages(
+	id BIGSERIAL PRIMARY KEY,
+	profile BIGINT NOT NULL REFERENCES profiles(id) ON DELETE CASCADE,
+	message TEXT NOT NULL,
+	added TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+	)`,
+		`CREATE INDEX idx_profile ON profile_messages(profile)`,
+	}, splitProfiles},
+	{4, "drop_profiles_messages", []string{
+		`ALTER TABLE profiles DROP COLUMN messages`,
+	}, []string{
+		`ALTER TABLE profiles DROP COLUMN messages`,
+	}, nil},
+	{5, "profile_shares", []string{
+		`CREATE TABLE profile_shares(
+	profile INTEGER NOT NULL,
+	nick TEXT NOT NULL,
+	permission TEXT NOT NULL,
+	shared DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY(profile, nick),
+	FOREIGN KEY(profile) REFERENCES profiles(id) ON DELETE CASCADE,
+	FOREIGN KEY(nick) REFERENCES users(nick) ON DELETE CASCADE
+	)`,
+		`CREATE INDEX idx_share_nick ON profile_shares(nick)`,
+		`ALTER TABLE profile_messages ADD COLUMN author TEXT REFERENCES users(nick) ON DELETE SET NULL`,
+		`UPDATE profile_messages SET author = (SELECT nick FROM profiles WHERE profiles.id = profile_messages.profile)`,
+	}, []string{
+		`CREATE TABLE profile_shares(
+	profile BIGINT NOT NULL REFERENCES profiles(id) ON DELETE CASCADE,
+	nick TEXT NOT NULL REFERENCES users(nick) ON DELETE CASCADE,
+	permission TEXT NOT NULL,
+	shared TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY(profile, nick)
+	)`,
+		`CREATE INDEX idx_share_nick ON profile_shares(nick)`,
+		`ALTER TABLE profile_messages ADD COLUMN author TEXT REFERENCES users(nick) ON DELETE SET NULL`,
+		`UPDATE profile_messages SET author = (SELECT nick FROM profiles WHERE profiles.id = profile_messages.profile)`,
+	}, nil},
+	{6, "message_hash", []string{
+		`ALTER TABLE messages ADD COLUMN hash TEXT`,
+	}, []string{
+		`ALTER TABLE messages ADD COLUMN hash TEXT`,
+	}, hashMessages},
+	{7, "message_hash_index", []string{
+		// Exact duplicates stored before hashing keep a NULL hash rather than being deleted.
+		`UPDATE messages SET hash = NULL WHERE id NOT IN (SELECT MIN(id) FROM messages GROUP BY hash)`,
+		`CREATE UNIQUE INDEX idx_hash ON messages(hash)`,
+	}, []string{
+		`UPDATE messages SET hash = NULL WHERE id NOT IN (SELECT MIN(id) FROM messages GROUP BY hash)`,
+		`CREATE UNIQUE INDEX idx_hash ON messages(hash)`,
+	}, nil},
+	{8, "consent_history", []string{
+		`CREATE TABLE consent_history(
+	id INTEGER PRIMARY KEY,
+	nick TEXT NOT NULL,
+	opt BOOL NOT NULL,
+	changed DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY(nick) REFERENCES users(nick) ON DELETE CASCADE
+	)`,
+		`CREATE INDEX idx_consent_nick ON consent_history(nick)`,
+		// Earlier changes were never recorded, so history starts with the status at upgrade time.
+		`INSERT INTO consent_history (nick, opt) SELECT nick, opt FROM users`,
+	}, []string{
+		`CREATE TABLE consent_history(
+	id BIGSERIAL PRIMARY KEY,
+	nick TEXT NOT NULL REFERENCES users(nick) ON DELETE CASCADE,
+	opt BOOLEAN NOT NULL,
+	changed TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+	)`,
+		`CREATE INDEX idx_consent_nick ON consent_history(nick)`,
+		`INSERT INTO consent_history (nick, opt) SELECT nick, opt FROM users`,
+	}, nil},
+	{9, "scheduled_forgets", []string{
+		`CREATE TABLE scheduled_forgets(
+	id INTEGER PRIMARY KEY,
+	nick TEXT NOT NULL,
+	channel TEXT NOT NULL DEFAULT '',
+	since DATETIME,
+	until DATETIME NOT NULL,
+	last_count INTEGER NOT NULL DEFAULT 0,
+	due DATETIME NOT NULL,
+	requested DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY(nick) REFERENCES users(nick) ON DELETE CASCADE
+	)`,
+		`CREATE INDEX idx_forget_nick ON scheduled_forgets(nick)`,
+	}, []string{
+		`CREATE TABLE scheduled_forgets(
+	id BIGSERIAL PRIMARY KEY,
+	nick TEXT NOT NULL REFERENCES users(nick) ON DELETE CASCADE,
+	channel TEXT NOT NULL DEFAULT '',
+	since TIMESTAMPTZ,
+	until TIMESTAMPTZ NOT NULL,
+	last_count INTEGER NOT NULL DEFAULT 0,
+	due TIMESTAMPTZ NOT NULL,
+	requested TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+	)`,
+		`CREATE INDEX idx_forget_nick ON scheduled_forgets(nick)`,
+	}, nil},
+	{10, "deletion_audit", []string{
+		// No foreign key: the user is gone by the time a row is written.
+		`CREATE TABLE deletion_audit(
+	id INTEGER PRIMARY KEY,
+	nick TEXT NOT NULL,
+	kind TEXT NOT NULL,
+	messages INTEGER NOT NULL,
+	profiles INTEGER NOT NULL,
+	scheduled DATETIME,
+	executed DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`,
+	}, []string{
+		`CREATE TABLE deletion_audit(
+	id BIGSERIAL PRIMARY KEY,
+	nick TEXT NOT NULL,
+	kind TEXT NOT NULL,
+	messages INTEGER NOT NULL,
+	profiles INTEGER NOT NULL,
+	scheduled TIMESTAMPTZ,
+	executed TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+	)`,
+	}, nil},
+	{11, "model_runs", []string{
+		// Ids are the job numbers users see, so they are assigned by the bot.
+		`CREATE TABLE model_runs(
+	id INTEGER PRIMARY KEY,
+	requester TEXT NOT NULL DEFAULT '',
+	reason TEXT NOT NULL DEFAULT '',
+	cm BOOL NOT NULL,
+	bert BOOL NOT NULL,
+	past_days INTEGER NOT NULL,
+	succeeded BOOL NOT NULL,
+	error TEXT NOT NULL DEFAULT '',
+	started DATETIME NOT NULL,
+	finished DATETIME NOT NULL,
+	fit_seconds REAL NOT NULL DEFAULT 0,
+	labels INTEGER NOT NULL DEFAULT 0,
+	samples INTEGER NOT NULL DEFAULT 0,
+	accuracy REAL,
+	f1 REAL,
+	data_until DATETIME
+	)`,
+	}, []string{
+		`CREATE TABLE model_runs(
+	id BIGINT PRIMARY KEY,
+	requester TEXT NOT NULL DEFAULT '',
+	reason TEXT NOT NULL DEFAULT '',
+	cm BOOLEAN NOT NULL,
+	bert BOOLEAN NOT NULL,
+	past_days INTEGER NOT NULL,
+	succeeded BOOLEAN NOT NULL,
+	error TEXT NOT NULL DEFAULT '',
+	started TIMESTAMPTZ NOT NULL,
+	finished TIMESTAMPTZ NOT NULL,
+	fit_seconds DOUBLE PRECISION NOT NULL DEFAULT 0,
+	labels INTEGER NOT NULL DEFAULT 0,
+	samples INTEGER NOT NULL DEFAULT 0,
+	accuracy DOUBLE PRECISION,
+	f1 DOUBLE PRECISION,
+	data_until TIMESTAMPTZ
+	)`,
+	}, nil},
+	{12, "legacy_nicks", []string{
+		`CREATE TABLE legacy_nicks(
+	nick TEXT PRIMARY KEY,
+	FOREIGN KEY(nick) REFERENCES users(nick) ON DELETE CASCADE
+	)`,
+		// Everything stored so far is keyed by the bare nick it was sent from.
+		`INSERT INTO legacy_nicks (nick) SELECT nick FROM users WHERE nick NOT LIKE '~%'`,
+	}, []string{
+		`CREATE TABLE legacy_nicks(
+	nick TEXT PRIMARY KEY REFERENCES users(nick) ON DELETE CASCADE
+	)`,
+		`INSERT INTO legacy_nicks (nick) SELECT nick FROM users WHERE nick NOT LIKE '~%'`,
+	}, nil},
+}
+
+// hashMessages fills in messages.hash for messages stored before it existed,
+// a batch at a time so large tables are never held in memory at once.
+func hashMessages(tx *sql.Tx, d dialect) error {
+	const batch = 5000
+	var last int64
+	for {
+		res, err := tx.Query(d.rebind("SELECT id, nick, channel, message, time FROM messages WHERE id > ? ORDER BY id LIMIT ?"), last, batch)
+		if err != nil {
+			return err
+		}
+
+		ids := []int64{}
+		hashes := []string{}
+		for res.Next() {
+			var m Message
+			var t sql.NullTime
+			if err := res.Scan(&last, &m.Nick, &m.Channel, &m.Content, &t); err != nil {
+				res.Close()
+				return err
+			}
+			m.Timestamp = t.Time
+			ids = append(ids, last)
+			hashes = append(hashes, m.Hash())
+		}
+		res.Close()
+		if err := res.Err(); err != nil {
+			return err
+		}
+
+		for i := range ids {
+			if _, err := tx.Exec(d.rebind("UPDATE messages SET hash = ? WHERE id = ?"), hashes[i], ids[i]); err != nil {
+				return err
+			}
+		}
+		if len(ids) < batch {
+			return nil
+		}
+	}
+}
+
+// splitProfiles copies the /:MSG/-delimited profiles.messages column into
+// profile_messages, one row per message, dated when the profile was created.
+func splitProfiles(tx *sql.Tx, d dialect) error {
+	type profile struct {
+		id       int64
+		messages string
+		created  time.Time
+	}
+
+	res, err := tx.Query("SELECT id, messages, created FROM profiles")
+	if err != nil {
+		return err
+	}
+	profiles := []profile{}
+	for res.Next() {
+		var p profile
+		var messages sql.NullString
+		var created sql.NullTime
+		if err := res.Scan(&p.id, &messages, &created); err != nil {
+			res.Close()
+			return err
+		}
+		p.messages = messages.String
+		p.created = created.Time
+		if !created.Valid {
+			p.created = time.Now().UTC()
+		}
+		profiles = append(profiles, p)
+	}
+	res.Close()
+	if err := res.Err(); err != nil {
+		return err
+	}
+
+	insertStmt, err := tx.Prepare(d.rebind("INSERT INTO profile_messages (profile, message, added) VALUES (?, ?, ?)"))
+	if err != nil {
+		return err
+	}
+	defer insertStmt.Close()
+
+	for _, p := range profiles {
+		if p.messages == "" {
+			continue
+		}
+		for _, message := range splitProfileMessages(p.messages) {
+			if _, err := insertStmt.Exec(p.id, message, p.created); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+type MigrationState struct {
+	Version int
+	Name    string
+	Applied sql.NullTime
+}
+
+func (m migration) statementsFor(d dialect) []string {
+	if d.numbered {
+		return m.postgres
+	}
+	return m.statements
+}
+
+func (s *SQLStore) ensureMigrationsTable() error {
+	var exists int
+	err := s.queryRow(s.dialect.tableExists, "schema_migrations").Scan(&exists)
+	if err != nil || exists > 0 {
+		return err
+	}
+
+	_, err = s.exec(`CREATE TABLE schema_migrations(
+	version INTEGER PRIMARY KEY,
+	name TEXT NOT NULL,
+	applied ` + s.dialect.timestamp + ` DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		return err
+	}
+
+	// Deployments from before migrations already have the baseline schema.
+	var legacy int
+	err = s.queryRow(s.dialect.tableExists, "messages").Scan(&legacy)
+	if err != nil {
+		return err
+	}
+	if legacy > 0 {
+		_, err = s.exec("INSERT INTO schema_migrations (version, name) VALUES (?, ?)", migrations[0].version, migrations[0].name)
+	}
+
+	return err
+}
+
+func (s *SQLStore) appliedMigrations() (map[int]time.Time, error) {
+	res, err := s.query("SELECT version, applied FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	applied := make(map[int]time.Time)
+	for res.Next() {
+		var version int
+		var at time.Time
+		if err := res.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+
+	return applied, res.Err()
+}
+
+// Migrate applies all pending migrations and returns how many were applied.
+func (s *SQLStore) Migrate() (int, error) {
+	if err := s.ensureMigrationsTable(); err != nil {
+		return 0, err
+	}
+
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, m := range migrations {
+		if _, ok := applied[m.version]; ok {
+			continue
+		}
+		if err := s.applyMigration(m); err != nil {
+			return count, fmt.Errorf("migration %d (%s): %w", m.version, m.name, err)
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+func (s *SQLStore) applyMigration(m migration) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, statement := range m.statementsFor(s.dialect) {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+	if m.run != nil {
+		if err := m.run(tx, s.dialect); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(s.dialect.rebind("INSERT INTO schema_migrations (version, name) VALUES (?, ?)"), m.version, m.name)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// MigrationStatus lists every known migration and when it was applied, if at all.
+func (s *SQLStore) MigrationStatus() ([]MigrationState, error) {
+	if err := s.ensureMigrationsTable(); err != nil {
+		return nil, err
+	}
+
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]MigrationState, 0, len(migrations))
+	for _, m := range migrations {
+		at, ok := applied[m.version]
+		states = append(states, MigrationState{m.version, m.name, sql.NullTime{Time: at, Valid: ok}})
+	}
+
+	return states, nil
+}
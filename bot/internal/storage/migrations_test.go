@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// sqlKinds open an empty database of every kind SQLStore supports, without
+// touching its schema.
+var sqlKinds = []struct {
+	name string
+	open func(t *testing.T) (*SQLStore, error)
+}{
+	{"sqlite3", func(t *testing.T) (*SQLStore, error) {
+		return OpenStore("sqlite3", filepath.Join(t.TempDir(), "hearsay.db"))
+	}},
+	{"postgres", func(t *testing.T) (*SQLStore, error) {
+		return OpenStore("postgres", postgresDatabase(t))
+	}},
+}
+
+// TestMigrations upgrades a database holding data in the baseline schema through
+// every migration, then checks the data the migrations moved around.
+func TestMigrations(t *testing.T) {
+	for _, kind := range sqlKinds {
+		t.Run(kind.name, func(t *testing.T) {
+			store, err := kind.open(t)
+			if err != nil {
+				t.Fatalf("Failed to open %s store: %s", kind.name, err)
+			}
+			defer store.Close()
+
+			must(t, store.ensureMigrationsTable())
+			must(t, store.applyMigration(migrations[0]))
+			legacy := []Message{message("alice", "#a", "one", 0), message("alice", "#a", "two", 1)}
+			for _, query := range []struct {
+				sql  string
+				args []any
+			}{
+				{"INSERT INTO users (nick, opt) VALUES (?, ?)", []any{"alice", true}},
+				{"INSERT INTO messages (nick, channel, message, time) VALUES (?, ?, ?, ?)", []any{"alice", "#a", "one", legacy[0].Timestamp}},
+				{"INSERT INTO messages (nick, channel, message, time) VALUES (?, ?, ?, ?)", []any{"alice", "#a", "two", legacy[1].Timestamp}},
+				// An exact duplicate, which only the first copy's hash may claim.
+				{"INSERT INTO messages (nick, channel, message, time) VALUES (?, ?, ?, ?)", []any{"alice", "#a", "one", legacy[0].Timestamp}},
+				{"INSERT INTO profiles (nick, name, messages) VALUES (?, ?, ?)", []any{"alice", "p", "/:MSG/first/:MSG/second"}},
+			} {
+				if _, err := store.exec(query.sql, query.args...); err != nil {
+					t.Fatalf("%s: %s", query.sql, err)
+				}
+			}
+
+			if applied, err := store.Migrate(); err != nil || applied != len(migrations)-1 {
+				t.Fatalf("Migrate() = %d, %v, want %d", applied, err, len(migrations)-1)
+			}
+			if applied, err := store.Migrate(); err != nil || applied != 0 {
+				t.Errorf("Migrate() again = %d, %v, want 0", applied, err)
+			}
+			states, err := store.MigrationStatus()
+			must(t, err)
+			for _, state := range states {
+				if !state.Applied.Valid {
+					t.Errorf("Migration %d (%s) was not applied", state.Version, state.Name)
+				}
+			}
+
+			// Migration 3 split the profile, 6 hashed the messages and 8 started the consent history.
+			messages, err := store.ProfileMessages("alice", "p")
+			must(t, err)
+			texts := []string{}
+			for _, m := range messages {
+				texts = append(texts, m.Message)
+				if m.Author != "alice" {
+					t.Errorf("Profile message %q has author %q, want alice", m.Message, m.Author)
+				}
+			}
+			if !reflect.DeepEqual(texts, []string{"first", "second"}) {
+				t.Errorf("ProfileMessages(alice, p) = %v, want [first second]", texts)
+			}
+			if stored, err := store.CountStored(legacy); err != nil || stored != 2 {
+				t.Errorf("CountStored of messages from before hashing = %d, %v, want 2", stored, err)
+			}
+			if written, err := store.SubmitMessagesOnce(legacy); err != nil || written != 0 {
+				t.Errorf("SubmitMessagesOnce of messages from before hashing = %d, %v, want 0", written, err)
+			}
+			if count, err := store.CountMessages("alice"); err != nil || count != 3 {
+				t.Errorf("CountMessages(alice) = %d, %v, want the duplicate kept", count, err)
+			}
+			if history, err := store.ConsentHistory("alice"); err != nil || len(history) != 1 || !history[0].Opt {
+				t.Errorf("ConsentHistory(alice) = %+v, %v, want her opt-in", history, err)
+			}
+			// Migration 12 marked her as keyed by a bare nick.
+			if nicks, err := store.LegacyNicks(); err != nil || !reflect.DeepEqual(nicks, []string{"alice"}) {
+				t.Errorf("LegacyNicks() = %v, %v, want [alice]", nicks, err)
+			}
+		})
+	}
+}
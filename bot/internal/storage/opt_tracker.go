@@ -1,34 +1,43 @@
 package storage
 
 import (
-	"database/sql"
+	"sync"
 )
 
-// We keep a map of opt-outs. This prevents database lookups.
+// We keep a map of opt-ins. This prevents database lookups.
 // This approach works fine for smaller servers.
+// Keys are identity keys (see identity.Key), not necessarily nicks.
 
-var OptIns = make(map[string]struct{}) // TODO: Add mutex if this is used in more than one place.
+var optInsMu sync.RWMutex
+var OptIns = make(map[string]struct{})
 
-func IsOptedIn(nick string) bool {
-	if _, exists := OptIns[nick]; exists {
+func IsOptedIn(key string) bool {
+	optInsMu.RLock()
+	defer optInsMu.RUnlock()
+	if _, exists := OptIns[key]; exists {
 		return true
 	}
 	return false
 }
 
-func LoadOptIns(db *sql.DB) error {
-	res, err := db.Query("SELECT nick FROM users WHERE opt = 1")
+func SetOptIn(key string, opt bool) {
+	optInsMu.Lock()
+	defer optInsMu.Unlock()
+	if opt {
+		OptIns[key] = struct{}{}
+	} else {
+		delete(OptIns, key)
+	}
+}
+
+func LoadOptIns(store Store) error {
+	keys, err := store.OptedIn()
 	if err != nil {
 		return err
 	}
-	defer res.Close()
-
-	for res.Next() {
-		var nick string
-		if err := res.Scan(&nick); err != nil {
-			return err
-		}
-		OptIns[nick] = struct{}{}
+
+	for _, key := range keys {
+		SetOptIn(key, true)
 	}
 
 	return nil
@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// A throwaway PostgreSQL server is started from the postgres binary on PATH the
+// first time a test asks for a database, and each test gets a database of its
+// own. Without the binary, those tests are skipped, unless HEARSAY_TEST_POSTGRES
+// is set to "required" as it is in CI.
+var pg struct {
+	once sync.Once
+	// dir holds the cluster and the server's socket; the server only listens there.
+	dir       string
+	bin       string
+	err       error
+	databases atomic.Int32
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	stopPostgres()
+	os.Exit(code)
+}
+
+// postgresTool finds one of the server's programs, which some distributions
+// keep out of PATH next to the postgres binary.
+func postgresTool(name string) string {
+	if path, err := exec.LookPath(name); err == nil {
+		return path
+	}
+	return filepath.Join(pg.bin, name)
+}
+
+func startPostgres() error {
+	postgres, err := exec.LookPath("postgres")
+	if err != nil {
+		return errors.New("no postgres binary on PATH")
+	}
+	if os.Geteuid() == 0 {
+		return errors.New("PostgreSQL refuses to run as root")
+	}
+	pg.bin = filepath.Dir(postgres)
+
+	// Socket paths are limited to about 100 bytes, so t.TempDir would be too deep.
+	pg.dir, err = os.MkdirTemp("", "hearsay-pg")
+	if err != nil {
+		return err
+	}
+	data := filepath.Join(pg.dir, "data")
+
+	commands := [][]string{
+		{postgresTool("initdb"), "-D", data, "-U", "hearsay", "-A", "trust", "-E", "UTF8", "--no-sync"},
+		{postgresTool("pg_ctl"), "-D", data, "-l", filepath.Join(pg.dir, "server.log"), "-w",
+			"-o", "-F -k " + pg.dir + " -c listen_addresses=''", "start"},
+	}
+	for _, command := range commands {
+		if output, err := exec.Command(command[0], command[1:]...).CombinedOutput(); err != nil {
+			os.RemoveAll(pg.dir)
+			pg.dir = ""
+			return fmt.Errorf("%s failed: %w\n%s", filepath.Base(command[0]), err, output)
+		}
+	}
+	return nil
+}
+
+func stopPostgres() {
+	if pg.dir == "" {
+		return
+	}
+	exec.Command(postgresTool("pg_ctl"), "-D", filepath.Join(pg.dir, "data"), "-m", "immediate", "-w", "stop").Run()
+	os.RemoveAll(pg.dir)
+}
+
+func postgresDSN(database string) string {
+	return fmt.Sprintf("host=%s user=hearsay dbname=%s sslmode=disable", pg.dir, database)
+}
+
+// postgresDatabase creates an empty database and returns its DSN, skipping the
+// test if no server could be started.
+func postgresDatabase(t *testing.T) string {
+	pg.once.Do(func() { pg.err = startPostgres() })
+	if pg.err != nil {
+		if os.Getenv("HEARSAY_TEST_POSTGRES") == "required" {
+			t.Fatalf("PostgreSQL is not available: %s", pg.err)
+		}
+		t.Skipf("PostgreSQL is not available: %s", pg.err)
+	}
+
+	db, err := sql.Open("postgres", postgresDSN("postgres"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	name := fmt.Sprintf("hearsay_%d", pg.databases.Add(1))
+	if _, err := db.Exec("CREATE DATABASE " + name); err != nil {
+		t.Fatalf("Failed to create database %s: %s", name, err)
+	}
+	return postgresDSN(name)
+}
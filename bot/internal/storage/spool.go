@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Spool is an append-only JSON Lines file holding batches that could not be
+// written to the database, e.g. because of SQLITE_BUSY while the API reads the
+// same file. Its contents are replayed once the database accepts writes again.
+type Spool struct {
+	path  string
+	mu    sync.Mutex
+	count int
+}
+
+func OpenSpool(path string) (*Spool, error) {
+	s := &Spool{path: path}
+
+	messages, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	s.count = len(messages)
+	if s.count > 0 {
+		log.Printf("Spool %s holds %d messages from a previous run.\n", path, s.count)
+	}
+
+	return s, nil
+}
+
+// Len returns the number of spooled messages.
+func (s *Spool) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.count
+}
+
+func (s *Spool) Append(messages []Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, message := range messages {
+		if err := enc.Encode(message); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+
+	s.count += len(messages)
+	log.Printf("Spooled %d messages to %s (%d waiting).\n", len(messages), s.path, s.count)
+	return nil
+}
+
+// read returns every message in the spool. Lines that fail to decode, such as
+// one torn by a crash mid-write, are logged and skipped.
+func (s *Spool) read() ([]Message, error) {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	messages := []Message{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var message Message
+		if err := json.Unmarshal(scanner.Bytes(), &message); err != nil {
+			log.Printf("Skipping unreadable line %d in spool %s: %s\n", lineNumber, s.path, err.Error())
+			continue
+		}
+		messages = append(messages, message)
+	}
+
+	return messages, scanner.Err()
+}
+
+// Replay writes all spooled messages to the database and empties the spool.
+// Messages already present, e.g. from a replay interrupted after its commit,
+// are skipped, as are those of users who opted out or were purged while they
+// waited. On error the spool is left untouched for the next attempt.
+func (s *Spool) Replay(store Store) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	messages, err := s.read()
+	if err != nil {
+		return err
+	}
+	if len(messages) == 0 {
+		s.count = 0
+		return nil
+	}
+
+	spooled := len(messages)
+	messages = slices.DeleteFunc(messages, func(m Message) bool { return !IsOptedIn(m.Nick) })
+	written, err := store.SubmitMessagesOnce(messages)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Truncate(s.path, 0); err != nil {
+		return fmt.Errorf("replayed spool but failed to truncate it: %w", err)
+	}
+	s.count = 0
+	log.Printf("Replayed spool %s: %d messages written, %d duplicates and %d of users no longer opted in skipped.\n",
+		s.path, written, len(messages)-written, spooled-len(messages))
+	return nil
+}
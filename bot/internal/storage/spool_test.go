@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSpoolReplaySkipsPurged(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		optIn(t, store, "alice", "bob")
+		SetOptIn("alice", true)
+		SetOptIn("bob", true)
+		defer SetOptIn("alice", false)
+		defer SetOptIn("bob", false)
+
+		spool, err := OpenSpool(filepath.Join(t.TempDir(), "spool.jsonl"))
+		must(t, err)
+		must(t, spool.Append([]Message{
+			message("alice", "#a", "one", 0),
+			message("bob", "#a", "two", 1),
+		}))
+
+		// alice is purged and bob opts out while their messages wait in the spool.
+		must(t, store.DeleteUser("alice"))
+		SetOptIn("alice", false)
+		must(t, store.SetOpt("bob", false))
+		SetOptIn("bob", false)
+
+		must(t, spool.Replay(store))
+		if spool.Len() != 0 {
+			t.Errorf("Len() after replay = %d, want 0", spool.Len())
+		}
+		if _, err := store.OptStatus("alice"); err != ErrNotFound {
+			t.Errorf("OptStatus(alice) after replay = %v, want ErrNotFound", err)
+		}
+		for _, key := range []string{"alice", "bob"} {
+			if count, err := store.CountMessages(key); err != nil || count != 0 {
+				t.Errorf("CountMessages(%s) after replay = %d, %v, want 0", key, count, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,909 @@
+package storage
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// SQLStore is the Store backed by a database/sql handle: the go-sqlite3 file
+// shared with the API by default, or PostgreSQL.
+type SQLStore struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+func NewSQLiteStore(db *sql.DB) *SQLStore {
+	return &SQLStore{db: db, dialect: sqliteDialect}
+}
+
+func NewPostgresStore(db *sql.DB) *SQLStore {
+	return &SQLStore{db: db, dialect: postgresDialect}
+}
+
+// Driver returns the database/sql driver name of the store's dialect.
+func (s *SQLStore) Driver() string {
+	return s.dialect.name
+}
+
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLStore) exec(query string, args ...any) (sql.Result, error) {
+	return s.db.Exec(s.dialect.rebind(query), args...)
+}
+
+func (s *SQLStore) query(query string, args ...any) (*sql.Rows, error) {
+	return s.db.Query(s.dialect.rebind(query), args...)
+}
+
+func (s *SQLStore) queryRow(query string, args ...any) *sql.Row {
+	return s.db.QueryRow(s.dialect.rebind(query), args...)
+}
+
+func (s *SQLStore) OptStatus(key string) (bool, error) {
+	var opt bool
+	err := s.queryRow("SELECT opt FROM users WHERE nick = ?", key).Scan(&opt)
+	if err == sql.ErrNoRows {
+		return false, ErrNotFound
+	}
+
+	return opt, err
+}
+
+func (s *SQLStore) SetOpt(key string, opt bool) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var previous bool
+	err = tx.QueryRow(s.dialect.rebind("SELECT opt FROM users WHERE nick = ?"), key).Scan(&previous)
+	known := err == nil
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	// Messages are only collected after opting in, so the users row may not exist yet.
+	_, err = tx.Exec(s.dialect.rebind("INSERT INTO users (nick, opt) VALUES (?, ?) ON CONFLICT(nick) DO UPDATE SET opt = excluded.opt"), key, opt)
+	if err != nil {
+		return err
+	}
+	if !known || previous != opt {
+		if _, err = tx.Exec(s.dialect.rebind("INSERT INTO consent_history (nick, opt) VALUES (?, ?)"), key, opt); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLStore) OptedIn() ([]string, error) {
+	return s.column("SELECT nick FROM users WHERE opt = ?", true)
+}
+
+func (s *SQLStore) DeletionScheduled(key string) (time.Time, bool, error) {
+	var deletion sql.NullTime
+	err := s.queryRow("SELECT deletion FROM users WHERE nick = ?", key).Scan(&deletion)
+	if err == sql.ErrNoRows {
+		return time.Time{}, false, ErrNotFound
+	}
+
+	return deletion.Time, deletion.Valid, err
+}
+
+func (s *SQLStore) ScheduleDeletion(key string, at time.Time) error {
+	res, err := s.exec("UPDATE users SET deletion = ? WHERE nick = ?", at, key)
+	return notFoundIfUnchanged(res, err)
+}
+
+func (s *SQLStore) CancelDeletion(key string) (bool, error) {
+	res, err := s.exec("UPDATE users SET deletion = NULL WHERE nick = ? AND deletion IS NOT NULL", key)
+	if err != nil {
+		return false, err
+	}
+
+	rA, err := res.RowsAffected()
+	return rA > 0, err
+}
+
+func (s *SQLStore) DeletionsDue(now time.Time) ([]string, error) {
+	return s.column("SELECT nick FROM users WHERE deletion IS NOT NULL AND "+s.dialect.at("deletion")+" <= "+s.dialect.at("?"), now)
+}
+
+func (s *SQLStore) DeleteUser(key string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var scheduled sql.NullTime
+	var messages, profiles int
+	err = tx.QueryRow(s.dialect.rebind(`SELECT deletion,
+	(SELECT COUNT(*) FROM messages WHERE nick = ?),
+	(SELECT COUNT(*) FROM profiles WHERE nick = ?)
+	FROM users WHERE nick = ?`), key, key, key).Scan(&scheduled, &messages, &profiles)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	} else if err != nil {
+		return err
+	}
+
+	// Messages, profiles, shares and aliases go with the users row through ON DELETE CASCADE;
+	// messages they appended to others' profiles lose their author.
+	if _, err = tx.Exec(s.dialect.rebind("DELETE FROM users WHERE nick = ?"), key); err != nil {
+		return err
+	}
+	_, err = tx.Exec(s.dialect.rebind("INSERT INTO deletion_audit (nick, kind, messages, profiles, scheduled) VALUES (?, 'full', ?, ?, ?)"),
+		key, messages, profiles, scheduled)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLStore) User(key string) (User, error) {
+	user := User{Key: key}
+	var registered, deletion sql.NullTime
+	err := s.queryRow("SELECT registered, opt, deletion FROM users WHERE nick = ?", key).Scan(&registered, &user.Opt, &deletion)
+	if err == sql.ErrNoRows {
+		return User{}, ErrNotFound
+	} else if err != nil {
+		return User{}, err
+	}
+
+	user.Registered = registered.Time
+	if deletion.Valid {
+		user.Deletion = &deletion.Time
+	}
+
+	return user, nil
+}
+
+func (s *SQLStore) ConsentHistory(key string) ([]ConsentChange, error) {
+	res, err := s.query("SELECT opt, changed FROM consent_history WHERE nick = ? ORDER BY id", key)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	changes := []ConsentChange{}
+	for res.Next() {
+		var change ConsentChange
+		if err := res.Scan(&change.Opt, &change.Changed); err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+
+	return changes, res.Err()
+}
+
+func (s *SQLStore) WalkMessages(key string, fn func(Message) error) error {
+	res, err := s.query("SELECT nick, channel, message, time FROM messages WHERE nick = ? ORDER BY id", key)
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+
+	for res.Next() {
+		var message Message
+		var t sql.NullTime
+		if err := res.Scan(&message.Nick, &message.Channel, &message.Content, &t); err != nil {
+			return err
+		}
+		message.Timestamp = t.Time
+		if err := fn(message); err != nil {
+			return err
+		}
+	}
+
+	return res.Err()
+}
+
+// matching returns a condition selecting key's messages that filter matches, and its arguments.
+func (s *SQLStore) matching(key string, filter MessageFilter) (string, []any) {
+	where := "nick = ?"
+	args := []any{key}
+	if filter.Channel != "" {
+		where += " AND LOWER(channel) = LOWER(?)"
+		args = append(args, filter.Channel)
+	}
+	if !filter.Since.IsZero() {
+		where += " AND " + s.dialect.at("time") + " >= " + s.dialect.at("?")
+		args = append(args, filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		where += " AND " + s.dialect.at("time") + " < " + s.dialect.at("?")
+		args = append(args, filter.Until)
+	}
+	if filter.Last > 0 {
+		where = "id IN (SELECT id FROM messages WHERE " + where + " ORDER BY " + s.dialect.at("time") + " DESC, id DESC LIMIT ?)"
+		args = append(args, filter.Last)
+	}
+
+	return where, args
+}
+
+func (s *SQLStore) CountMatching(key string, filter MessageFilter) (int, error) {
+	where, args := s.matching(key, filter)
+	var count int
+	err := s.queryRow("SELECT COUNT(*) FROM messages WHERE "+where, args...).Scan(&count)
+	return count, err
+}
+
+func (s *SQLStore) ScheduleForget(key string, filter MessageFilter, at time.Time) error {
+	var since sql.NullTime
+	if !filter.Since.IsZero() {
+		since = sql.NullTime{Time: filter.Since, Valid: true}
+	}
+
+	_, err := s.exec("INSERT INTO scheduled_forgets (nick, channel, since, until, last_count, due) VALUES (?, ?, ?, ?, ?, ?)",
+		key, filter.Channel, since, filter.Until, filter.Last, at)
+	return err
+}
+
+func (s *SQLStore) CancelForgets(key string) (int, error) {
+	res, err := s.exec("DELETE FROM scheduled_forgets WHERE nick = ?", key)
+	if err != nil {
+		return 0, err
+	}
+
+	rA, err := res.RowsAffected()
+	return int(rA), err
+}
+
+func (s *SQLStore) ForgetsDue(now time.Time) ([]ScheduledForget, error) {
+	res, err := s.query("SELECT id, nick, channel, since, until, last_count, due FROM scheduled_forgets WHERE "+
+		s.dialect.at("due")+" <= "+s.dialect.at("?")+" ORDER BY id", now)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	forgets := []ScheduledForget{}
+	for res.Next() {
+		var forget ScheduledForget
+		var since sql.NullTime
+		err := res.Scan(&forget.ID, &forget.Key, &forget.Filter.Channel, &since, &forget.Filter.Until, &forget.Filter.Last, &forget.Due)
+		if err != nil {
+			return nil, err
+		}
+		forget.Filter.Since = since.Time
+		forgets = append(forgets, forget)
+	}
+
+	return forgets, res.Err()
+}
+
+func (s *SQLStore) Forget(id int64) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var key string
+	var filter MessageFilter
+	var since, due sql.NullTime
+	err = tx.QueryRow(s.dialect.rebind("SELECT nick, channel, since, until, last_count, due FROM scheduled_forgets WHERE id = ?"), id).
+		Scan(&key, &filter.Channel, &since, &filter.Until, &filter.Last, &due)
+	if err == sql.ErrNoRows {
+		return 0, ErrNotFound
+	} else if err != nil {
+		return 0, err
+	}
+	filter.Since = since.Time
+
+	where, args := s.matching(key, filter)
+	res, err := tx.Exec(s.dialect.rebind("DELETE FROM messages WHERE "+where), args...)
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if _, err = tx.Exec(s.dialect.rebind("DELETE FROM scheduled_forgets WHERE id = ?"), id); err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec(s.dialect.rebind("INSERT INTO deletion_audit (nick, kind, messages, profiles, scheduled) VALUES (?, 'selective', ?, 0, ?)"),
+		key, deleted, due)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(deleted), tx.Commit()
+}
+
+func (s *SQLStore) SubmitMessages(messages []Message) error {
+	_, err := s.insertMessages(messages)
+	return err
+}
+
+func (s *SQLStore) SubmitMessagesOnce(messages []Message) (int, error) {
+	return s.insertMessages(messages)
+}
+
+// insertMessages writes messages in one transaction, letting the unique index on
+// messages.hash skip any that are already stored, and returns how many were written.
+func (s *SQLStore) insertMessages(messages []Message) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	userInsertionStmt, err := tx.Prepare(s.dialect.rebind("INSERT INTO users (nick, registered, opt, deletion) VALUES (?, ?, ?, ?) ON CONFLICT DO NOTHING"))
+	if err != nil {
+		return 0, err
+	}
+	defer userInsertionStmt.Close()
+
+	messagesStmt, err := tx.Prepare(s.dialect.rebind("INSERT INTO messages (nick, channel, message, time, hash) VALUES (?, ?, ?, ?, ?) ON CONFLICT (hash) DO NOTHING"))
+	if err != nil {
+		return 0, err
+	}
+	defer messagesStmt.Close()
+
+	written := 0
+	for _, message := range dedupeMessages(messages) {
+		if _, err := userInsertionStmt.Exec(message.Nick, message.Timestamp, false, nil); err != nil {
+			return 0, err
+		}
+
+		res, err := messagesStmt.Exec(message.Nick, message.Channel, strings.TrimSpace(message.Content), message.Timestamp, message.Hash())
+		if err != nil {
+			return 0, err
+		}
+		if rA, err := res.RowsAffected(); err != nil {
+			return 0, err
+		} else if rA > 0 {
+			written++
+		}
+	}
+
+	return written, tx.Commit()
+}
+
+func (s *SQLStore) CountStored(messages []Message) (int, error) {
+	checkStmt, err := s.db.Prepare(s.dialect.rebind("SELECT 1 FROM messages WHERE hash = ?"))
+	if err != nil {
+		return 0, err
+	}
+	defer checkStmt.Close()
+
+	stored := 0
+	for _, message := range dedupeMessages(messages) {
+		var placeholder int
+		err := checkStmt.QueryRow(message.Hash()).Scan(&placeholder)
+		if err == nil {
+			stored++
+		} else if err != sql.ErrNoRows {
+			return stored, err
+		}
+	}
+
+	return stored, nil
+}
+
+func (s *SQLStore) CountMessages(key string) (int, error) {
+	var count int
+	err := s.queryRow("SELECT COUNT(*) FROM messages WHERE nick = ? OR nick IN (SELECT alias FROM aliases WHERE canonical = ?)", key, key).Scan(&count)
+	return count, err
+}
+
+// canonicalNickSQL maps messages.nick onto its canonical identity for messages stored before an alias was linked.
+const canonicalNickSQL = "COALESCE((SELECT canonical FROM aliases WHERE alias = messages.nick), messages.nick)"
+
+func (s *SQLStore) CountEligible(quota int) (int, error) {
+	var count int
+	err := s.queryRow(`SELECT COUNT(*) FROM (SELECT canonical
+	FROM (SELECT `+canonicalNickSQL+` AS canonical FROM messages) AS attributed JOIN users ON users.nick = attributed.canonical
+	WHERE users.opt = ? GROUP BY canonical HAVING COUNT(*) >= ?) AS eligible`, true, quota).Scan(&count)
+	return count, err
+}
+
+func (s *SQLStore) NewestMessage() (time.Time, error) {
+	var newest sql.NullTime
+	err := s.queryRow("SELECT time FROM messages ORDER BY " + s.dialect.at("time") + " DESC LIMIT 1").Scan(&newest)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	return newest.Time, err
+}
+
+func (s *SQLStore) EligibleUsers(quota int, since time.Time) ([]EligibleUser, error) {
+	res, err := s.query(`SELECT canonical, COUNT(*), SUM(CASE WHEN `+s.dialect.at("time")+` > `+s.dialect.at("?")+` THEN 1 ELSE 0 END)
+	FROM (SELECT `+canonicalNickSQL+` AS canonical, time FROM messages) AS attributed JOIN users ON users.nick = attributed.canonical
+	WHERE users.opt = ? GROUP BY canonical HAVING COUNT(*) >= ? ORDER BY canonical`, since, true, quota)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	users := []EligibleUser{}
+	for res.Next() {
+		var user EligibleUser
+		if err := res.Scan(&user.Key, &user.Messages, &user.Since); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, res.Err()
+}
+
+func (s *SQLStore) CountProfiles(owner string) (int, error) {
+	var count int
+	err := s.queryRow("SELECT COUNT(*) FROM profiles WHERE nick = ?", owner).Scan(&count)
+	return count, err
+}
+
+func (s *SQLStore) ProfileExists(owner string, name string) (bool, error) {
+	var count int
+	err := s.queryRow("SELECT COUNT(*) FROM profiles WHERE nick = ? AND name = ?", owner, name).Scan(&count)
+	return count > 0, err
+}
+
+func (s *SQLStore) ListProfiles(owner string) ([]ProfileSummary, error) {
+	res, err := s.query("SELECT name, (SELECT COUNT(*) FROM profile_messages WHERE profile = profiles.id) FROM profiles WHERE nick = ? ORDER BY name", owner)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	profiles := []ProfileSummary{}
+	for res.Next() {
+		var profile ProfileSummary
+		if err := res.Scan(&profile.Name, &profile.Messages); err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, profile)
+	}
+
+	return profiles, res.Err()
+}
+
+func (s *SQLStore) CreateProfile(owner string, name string) error {
+	_, err := s.exec("INSERT INTO profiles (nick, name) VALUES (?, ?)", owner, name)
+	return err
+}
+
+func (s *SQLStore) DeleteProfile(owner string, name string) error {
+	// Its messages go with it through ON DELETE CASCADE.
+	res, err := s.exec("DELETE FROM profiles WHERE nick = ? AND name = ?", owner, name)
+	return notFoundIfUnchanged(res, err)
+}
+
+func (s *SQLStore) RenameProfile(owner string, name string, newName string) error {
+	res, err := s.exec("UPDATE profiles SET name = ? WHERE nick = ? AND name = ?", newName, owner, name)
+	return notFoundIfUnchanged(res, err)
+}
+
+// profileIDSQL selects the id of the profile given by owner and name.
+const profileIDSQL = "SELECT id FROM profiles WHERE nick = ? AND name = ?"
+
+func (s *SQLStore) AppendProfile(owner string, name string, author string, message string) error {
+	res, err := s.exec("INSERT INTO profile_messages (profile, message, author) SELECT id, ?, ? FROM profiles WHERE nick = ? AND name = ?", message, author, owner, name)
+	return notFoundIfUnchanged(res, err)
+}
+
+func (s *SQLStore) ProfileMessages(owner string, name string) ([]ProfileMessage, error) {
+	var id int64
+	err := s.queryRow(profileIDSQL, owner, name).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	res, err := s.query("SELECT id, message, author, added FROM profile_messages WHERE profile = ? ORDER BY id", id)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	messages := []ProfileMessage{}
+	for res.Next() {
+		var message ProfileMessage
+		var author sql.NullString
+		if err := res.Scan(&message.ID, &message.Message, &author, &message.Added); err != nil {
+			return nil, err
+		}
+		message.Author = author.String
+		messages = append(messages, message)
+	}
+
+	return messages, res.Err()
+}
+
+func (s *SQLStore) CountProfileMessages(owner string, name string) (int, error) {
+	var count int
+	err := s.queryRow("SELECT COUNT(*) FROM profile_messages WHERE profile IN ("+profileIDSQL+")", owner, name).Scan(&count)
+	return count, err
+}
+
+func (s *SQLStore) RemoveProfileMessage(owner string, name string, id int64) error {
+	res, err := s.exec("DELETE FROM profile_messages WHERE id = ? AND profile IN ("+profileIDSQL+")", id, owner, name)
+	return notFoundIfUnchanged(res, err)
+}
+
+func (s *SQLStore) ClearProfile(owner string, name string) (int, error) {
+	res, err := s.exec("DELETE FROM profile_messages WHERE profile IN ("+profileIDSQL+")", owner, name)
+	if err != nil {
+		return 0, err
+	}
+
+	rA, err := res.RowsAffected()
+	return int(rA), err
+}
+
+func (s *SQLStore) ShareProfile(owner string, name string, key string, permission Permission) error {
+	res, err := s.exec(`INSERT INTO profile_shares (profile, nick, permission)
+	SELECT id, ?, ? FROM profiles WHERE nick = ? AND name = ? AND EXISTS (SELECT 1 FROM users WHERE nick = ?)
+	ON CONFLICT (profile, nick) DO UPDATE SET permission = excluded.permission`, key, string(permission), owner, name, key)
+	return notFoundIfUnchanged(res, err)
+}
+
+func (s *SQLStore) UnshareProfile(owner string, name string, key string) error {
+	res, err := s.exec("DELETE FROM profile_shares WHERE nick = ? AND profile IN ("+profileIDSQL+")", key, owner, name)
+	return notFoundIfUnchanged(res, err)
+}
+
+func (s *SQLStore) ProfilePermission(owner string, name string, key string) (Permission, error) {
+	var permission string
+	err := s.queryRow("SELECT permission FROM profile_shares WHERE nick = ? AND profile IN ("+profileIDSQL+")", key, owner, name).Scan(&permission)
+	if err == sql.ErrNoRows {
+		return PermissionNone, nil
+	}
+
+	return Permission(permission), err
+}
+
+func (s *SQLStore) ListShares(owner string, name string) ([]ProfileShare, error) {
+	res, err := s.query("SELECT nick, permission FROM profile_shares WHERE profile IN ("+profileIDSQL+") ORDER BY nick", owner, name)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	shares := []ProfileShare{}
+	for res.Next() {
+		var share ProfileShare
+		if err := res.Scan(&share.Key, &share.Permission); err != nil {
+			return nil, err
+		}
+		shares = append(shares, share)
+	}
+
+	return shares, res.Err()
+}
+
+func (s *SQLStore) SharedWith(key string) ([]SharedProfile, error) {
+	res, err := s.query(`SELECT p.nick, p.name, ps.permission, (SELECT COUNT(*) FROM profile_messages WHERE profile = p.id)
+	FROM profile_shares ps JOIN profiles p ON p.id = ps.profile
+	WHERE ps.nick = ? ORDER BY p.nick, p.name`, key)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	profiles := []SharedProfile{}
+	for res.Next() {
+		var profile SharedProfile
+		if err := res.Scan(&profile.Owner, &profile.Name, &profile.Permission, &profile.Messages); err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, profile)
+	}
+
+	return profiles, res.Err()
+}
+
+func (s *SQLStore) RecordModelRun(run ModelRun) error {
+	_, err := s.exec(`INSERT INTO model_runs (id, requester, reason, cm, bert, past_days, succeeded, error,
+	started, finished, fit_seconds, labels, samples, accuracy, f1, data_until)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		run.ID, run.Requester, run.Reason, run.ConfusionMatrix, run.Bert, run.PastDays, run.Succeeded, run.Error,
+		run.Started, run.Finished, run.FitSeconds, run.Labels, run.Samples, run.Accuracy, run.F1, run.DataUntil)
+	return err
+}
+
+const modelRunColumns = `id, requester, reason, cm, bert, past_days, succeeded, error,
+	started, finished, fit_seconds, labels, samples, accuracy, f1, data_until`
+
+func scanModelRun(scan func(dest ...any) error) (ModelRun, error) {
+	var run ModelRun
+	var accuracy, f1 sql.NullFloat64
+	var dataUntil sql.NullTime
+	err := scan(&run.ID, &run.Requester, &run.Reason, &run.ConfusionMatrix, &run.Bert, &run.PastDays, &run.Succeeded, &run.Error,
+		&run.Started, &run.Finished, &run.FitSeconds, &run.Labels, &run.Samples, &accuracy, &f1, &dataUntil)
+	if err != nil {
+		return ModelRun{}, err
+	}
+
+	if accuracy.Valid {
+		run.Accuracy = &accuracy.Float64
+	}
+	if f1.Valid {
+		run.F1 = &f1.Float64
+	}
+	if dataUntil.Valid {
+		run.DataUntil = &dataUntil.Time
+	}
+	return run, nil
+}
+
+func (s *SQLStore) ModelRuns(limit int) ([]ModelRun, error) {
+	res, err := s.query("SELECT "+modelRunColumns+" FROM model_runs ORDER BY id DESC LIMIT ?", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	runs := []ModelRun{}
+	for res.Next() {
+		run, err := scanModelRun(res.Scan)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, res.Err()
+}
+
+func (s *SQLStore) ModelRun(id int64) (ModelRun, error) {
+	run, err := scanModelRun(s.queryRow("SELECT "+modelRunColumns+" FROM model_runs WHERE id = ?", id).Scan)
+	if err == sql.ErrNoRows {
+		return ModelRun{}, ErrNotFound
+	}
+	return run, err
+}
+
+func (s *SQLStore) LastModelRun() (ModelRun, bool, error) {
+	run, err := scanModelRun(s.queryRow("SELECT " + modelRunColumns + " FROM model_runs WHERE succeeded ORDER BY id DESC LIMIT 1").Scan)
+	if err == sql.ErrNoRows {
+		return ModelRun{}, false, nil
+	} else if err != nil {
+		return ModelRun{}, false, err
+	}
+	return run, true, nil
+}
+
+func (s *SQLStore) RemovedSince(since time.Time) (int, error) {
+	var removed, optedOut int
+	err := s.queryRow("SELECT COUNT(*) FROM deletion_audit WHERE kind IN ('full', 'selective') AND messages > 0 AND "+
+		s.dialect.at("executed")+" > "+s.dialect.at("?"), since).Scan(&removed)
+	if err != nil {
+		return 0, err
+	}
+	err = s.queryRow(`SELECT COUNT(DISTINCT nick) FROM consent_history AS change WHERE NOT opt AND `+
+		s.dialect.at("changed")+` > `+s.dialect.at("?")+`
+	AND EXISTS (SELECT 1 FROM consent_history AS earlier WHERE earlier.nick = change.nick AND earlier.id < change.id AND earlier.opt)`, since).Scan(&optedOut)
+	return removed + optedOut, err
+}
+
+func (s *SQLStore) LinkAlias(alias string, canonical string, maxProfiles int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = s.mergeProfileNames(tx, alias, canonical, maxProfiles); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(s.dialect.rebind(`INSERT INTO users (nick, registered, opt)
+	SELECT ?, registered, opt FROM users WHERE nick = ? ON CONFLICT DO NOTHING`), canonical, alias)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(s.dialect.rebind("INSERT INTO users (nick) VALUES (?) ON CONFLICT DO NOTHING"), canonical)
+	if err != nil {
+		return err
+	}
+
+	// A deletion pending for either nick still happens, at the earlier time.
+	var aliasDeletion, canonicalDeletion sql.NullTime
+	err = tx.QueryRow(s.dialect.rebind("SELECT (SELECT deletion FROM users WHERE nick = ?), deletion FROM users WHERE nick = ?"),
+		alias, canonical).Scan(&aliasDeletion, &canonicalDeletion)
+	if err != nil {
+		return err
+	}
+	if aliasDeletion.Valid && (!canonicalDeletion.Valid || aliasDeletion.Time.Before(canonicalDeletion.Time)) {
+		if _, err = tx.Exec(s.dialect.rebind("UPDATE users SET deletion = ? WHERE nick = ?"), aliasDeletion.Time, canonical); err != nil {
+			return err
+		}
+	}
+
+	// A profile shared with both nicks keeps the canonical's share.
+	_, err = tx.Exec(s.dialect.rebind("DELETE FROM profile_shares WHERE nick = ? AND profile IN (SELECT profile FROM profile_shares WHERE nick = ?)"), alias, canonical)
+	if err != nil {
+		return err
+	}
+
+	if err = s.moveMessages(tx, alias, canonical); err != nil {
+		return err
+	}
+	for _, query := range []string{
+		"UPDATE profiles SET nick = ? WHERE nick = ?",
+		"UPDATE profile_shares SET nick = ? WHERE nick = ?",
+		"UPDATE profile_messages SET author = ? WHERE author = ?",
+		"UPDATE consent_history SET nick = ? WHERE nick = ?",
+		"UPDATE scheduled_forgets SET nick = ? WHERE nick = ?",
+		"UPDATE aliases SET canonical = ? WHERE canonical = ?",
+	} {
+		if _, err = tx.Exec(s.dialect.rebind(query), canonical, alias); err != nil {
+			return err
+		}
+	}
+
+	if _, err = tx.Exec(s.dialect.rebind("DELETE FROM users WHERE nick = ?"), alias); err != nil {
+		return err
+	}
+	_, err = tx.Exec(s.dialect.rebind("INSERT INTO aliases (alias, canonical) VALUES (?, ?) ON CONFLICT (alias) DO UPDATE SET canonical = excluded.canonical, linked = CURRENT_TIMESTAMP"), alias, canonical)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// mergeProfileNames renames the profiles of alias that canonical has a profile
+// of the same name for, so both survive the link, after checking that the two
+// do not own more than maxProfiles profiles between them.
+func (s *SQLStore) mergeProfileNames(tx *sql.Tx, alias string, canonical string, maxProfiles int) error {
+	res, err := tx.Query(s.dialect.rebind("SELECT nick, name FROM profiles WHERE nick IN (?, ?) ORDER BY name"), alias, canonical)
+	if err != nil {
+		return err
+	}
+
+	owned := make(map[string]bool)
+	moving := make(map[string]bool)
+	aliasNames := []string{}
+	for res.Next() {
+		var nick, name string
+		if err := res.Scan(&nick, &name); err != nil {
+			res.Close()
+			return err
+		}
+		if nick == alias {
+			moving[name] = true
+			aliasNames = append(aliasNames, name)
+		} else {
+			owned[name] = true
+		}
+	}
+	res.Close()
+	if err := res.Err(); err != nil {
+		return err
+	}
+	if len(owned)+len(moving) > maxProfiles {
+		return ErrTooManyProfiles
+	}
+
+	for _, name := range aliasNames {
+		delete(moving, name)
+		merged := mergedProfileName(name, func(candidate string) bool { return owned[candidate] || moving[candidate] })
+		owned[merged] = true
+		if merged == name {
+			continue
+		}
+		_, err := tx.Exec(s.dialect.rebind("UPDATE profiles SET name = ? WHERE nick = ? AND name = ?"), merged, alias, name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// moveMessages gives the messages of alias to canonical along with the hashes
+// that go with the new nick. A message canonical already has keeps a NULL hash,
+// as exact duplicates did when hashes were introduced.
+func (s *SQLStore) moveMessages(tx *sql.Tx, alias string, canonical string) error {
+	res, err := tx.Query(s.dialect.rebind("SELECT id, channel, message, time FROM messages WHERE nick = ?"), alias)
+	if err != nil {
+		return err
+	}
+
+	ids := []int64{}
+	hashes := []string{}
+	for res.Next() {
+		var id int64
+		var t sql.NullTime
+		m := Message{Nick: canonical}
+		if err := res.Scan(&id, &m.Channel, &m.Content, &t); err != nil {
+			res.Close()
+			return err
+		}
+		m.Timestamp = t.Time
+		ids = append(ids, id)
+		hashes = append(hashes, m.Hash())
+	}
+	res.Close()
+	if err := res.Err(); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(s.dialect.rebind("UPDATE messages SET nick = ?, hash = NULL WHERE nick = ?"), canonical, alias); err != nil {
+		return err
+	}
+	for i := range ids {
+		_, err := tx.Exec(s.dialect.rebind("UPDATE messages SET hash = ? WHERE id = ? AND NOT EXISTS (SELECT 1 FROM messages WHERE hash = ?)"), hashes[i], ids[i], hashes[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLStore) ListAliases(canonical string) ([]string, error) {
+	return s.column("SELECT alias FROM aliases WHERE canonical = ? ORDER BY linked", canonical)
+}
+
+func (s *SQLStore) Aliases() (map[string]string, error) {
+	res, err := s.query("SELECT alias, canonical FROM aliases")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	aliases := make(map[string]string)
+	for res.Next() {
+		var alias, canonical string
+		if err := res.Scan(&alias, &canonical); err != nil {
+			return nil, err
+		}
+		aliases[alias] = canonical
+	}
+
+	return aliases, res.Err()
+}
+
+func (s *SQLStore) LegacyNicks() ([]string, error) {
+	return s.column("SELECT nick FROM legacy_nicks")
+}
+
+func (s *SQLStore) DropLegacyNick(nick string) error {
+	_, err := s.exec("DELETE FROM legacy_nicks WHERE nick = ?", nick)
+	return err
+}
+
+// column runs a query returning a single text column.
+func (s *SQLStore) column(query string, args ...any) ([]string, error) {
+	res, err := s.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	list := []string{}
+	for res.Next() {
+		var value string
+		if err := res.Scan(&value); err != nil {
+			return nil, err
+		}
+		list = append(list, value)
+	}
+
+	return list, res.Err()
+}
+
+func notFoundIfUnchanged(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	if rA, err := res.RowsAffected(); err != nil {
+		return err
+	} else if rA == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,243 @@
+package storage
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned when the requested user or profile does not exist.
+var ErrNotFound = errors.New("not found")
+
+// ErrTooManyProfiles is returned when linking an alias would leave its identity
+// with more profiles than allowed.
+var ErrTooManyProfiles = errors.New("too many profiles")
+
+// Store is everything hearsay keeps about its users. Keys are identity keys
+// (see identity.Key), which is what the nick column of every table holds.
+type Store interface {
+	// Users and consent.
+	OptStatus(key string) (bool, error)
+	SetOpt(key string, opt bool) error
+	OptedIn() ([]string, error)
+
+	// Deletions.
+	DeletionScheduled(key string) (time.Time, bool, error)
+	ScheduleDeletion(key string, at time.Time) error
+	CancelDeletion(key string) (bool, error)
+	// DeletionsDue returns the keys whose deletion is at or before now, overdue ones included.
+	DeletionsDue(now time.Time) ([]string, error)
+	// DeleteUser removes the users row and, through it, everything else about key, leaving
+	// only a line in the deletion audit.
+	DeleteUser(key string) error
+
+	// Selective deletions, which remove some of a user's messages on the same schedule.
+	CountMatching(key string, filter MessageFilter) (int, error)
+	ScheduleForget(key string, filter MessageFilter, at time.Time) error
+	// CancelForgets cancels key's selective deletions and returns how many there were.
+	CancelForgets(key string) (int, error)
+	ForgetsDue(now time.Time) ([]ScheduledForget, error)
+	// Forget deletes the messages a scheduled forget matches, and the schedule itself,
+	// and records it in the deletion audit.
+	Forget(id int64) (int, error)
+
+	// Messages and counts.
+	SubmitMessages(messages []Message) error
+	// SubmitMessagesOnce skips messages already stored and returns how many were written.
+	SubmitMessagesOnce(messages []Message) (int, error)
+	// CountStored returns how many of messages are already stored, without writing anything.
+	CountStored(messages []Message) (int, error)
+	CountMessages(key string) (int, error)
+	// CountEligible returns how many opted-in identities have at least quota messages.
+	CountEligible(quota int) (int, error)
+	// NewestMessage returns when the most recent message was sent, or the zero time if there is none.
+	NewestMessage() (time.Time, error)
+	// EligibleUsers returns the opted-in identities with at least quota messages,
+	// with how many of those were sent after since.
+	EligibleUsers(quota int, since time.Time) ([]EligibleUser, error)
+
+	// Profiles.
+	CountProfiles(owner string) (int, error)
+	ProfileExists(owner string, name string) (bool, error)
+	ListProfiles(owner string) ([]ProfileSummary, error)
+	CreateProfile(owner string, name string) error
+	DeleteProfile(owner string, name string) error
+	RenameProfile(owner string, name string, newName string) error
+	AppendProfile(owner string, name string, author string, message string) error
+	ProfileMessages(owner string, name string) ([]ProfileMessage, error)
+	CountProfileMessages(owner string, name string) (int, error)
+	RemoveProfileMessage(owner string, name string, id int64) error
+	// ClearProfile removes every message from a profile and returns how many there were.
+	ClearProfile(owner string, name string) (int, error)
+
+	// Profile sharing. The key a profile is shared with must have a users row.
+	ShareProfile(owner string, name string, key string, permission Permission) error
+	UnshareProfile(owner string, name string, key string) error
+	// ProfilePermission returns what key may do with another's profile, or PermissionNone.
+	ProfilePermission(owner string, name string, key string) (Permission, error)
+	ListShares(owner string, name string) ([]ProfileShare, error)
+	SharedWith(key string) ([]SharedProfile, error)
+
+	// Takeout: everything stored about a user, for them to download.
+	User(key string) (User, error)
+	ConsentHistory(key string) ([]ConsentChange, error)
+	// WalkMessages calls fn with each of key's messages in the order they were stored.
+	WalkMessages(key string, fn func(Message) error) error
+
+	// Model runs, one for every retrain that finished.
+	RecordModelRun(run ModelRun) error
+	// ModelRuns returns the limit most recent runs, newest first.
+	ModelRuns(limit int) ([]ModelRun, error)
+	ModelRun(id int64) (ModelRun, error)
+	// LastModelRun returns the most recent successful run, if there is one.
+	LastModelRun() (ModelRun, bool, error)
+	// RemovedSince counts the purges and selective deletions that removed messages,
+	// and the opted-in users who opted out, after since: the data a model trained
+	// at since may still hold.
+	RemovedSince(since time.Time) (int, error)
+
+	// Aliases.
+	// LinkAlias merges everything stored under alias into canonical. A profile of
+	// alias named like one of canonical's is renamed name-2, name-3 and so on, and
+	// the earlier of their pending deletions is kept. It fails with
+	// ErrTooManyProfiles, changing nothing, if the two own more than maxProfiles
+	// profiles between them.
+	LinkAlias(alias string, canonical string, maxProfiles int) error
+	ListAliases(canonical string) ([]string, error)
+	Aliases() (map[string]string, error)
+	// LegacyNicks lists the users keyed by a bare nick from before accounts were
+	// tracked whose data has not been claimed yet (see ClaimLegacyNick).
+	LegacyNicks() ([]string, error)
+	DropLegacyNick(nick string) error
+
+	Close() error
+}
+
+// MessageFilter selects some of a user's messages. Zero fields do not restrict.
+type MessageFilter struct {
+	// Channel is compared case-insensitively.
+	Channel string
+	// Since is inclusive and Until exclusive.
+	Since time.Time
+	Until time.Time
+	// Last keeps only the most recent messages that match the rest of the filter.
+	Last int
+}
+
+// Matches reports whether m passes the filter, leaving out Last.
+func (f MessageFilter) Matches(m Message) bool {
+	if f.Channel != "" && !strings.EqualFold(f.Channel, m.Channel) {
+		return false
+	}
+	if !f.Since.IsZero() && m.Timestamp.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !m.Timestamp.Before(f.Until) {
+		return false
+	}
+	return true
+}
+
+type ScheduledForget struct {
+	ID     int64
+	Key    string
+	Filter MessageFilter
+	Due    time.Time
+}
+
+// EligibleUser is an identity with enough messages to be part of the model.
+type EligibleUser struct {
+	Key      string
+	Messages int
+	// Since is how many of Messages were sent after the time asked about.
+	Since int
+}
+
+// ModelRun records one retrain, successful or not.
+type ModelRun struct {
+	// ID is the job number users see.
+	ID int64
+	// Requester is empty for runs the scheduler started, whose Reason says why.
+	Requester       string
+	Reason          string
+	ConfusionMatrix bool
+	Bert            bool
+	PastDays        int
+	Succeeded       bool
+	// Error says why a run failed.
+	Error    string
+	Started  time.Time
+	Finished time.Time
+	// FitSeconds, Labels and Samples are what the API reported about the fit.
+	FitSeconds float64
+	Labels     int
+	Samples    int
+	// Accuracy and F1 are only set for runs evaluated with --cm.
+	Accuracy *float64
+	F1       *float64
+	// DataUntil is when the newest message the run could see was sent.
+	DataUntil *time.Time
+}
+
+// User is a users row.
+type User struct {
+	Key        string
+	Registered time.Time
+	Opt        bool
+	// Deletion is when the user's data is scheduled to be purged, if it is.
+	Deletion *time.Time
+}
+
+// ConsentChange records a user opting in or out.
+type ConsentChange struct {
+	Opt     bool
+	Changed time.Time
+}
+
+type ProfileSummary struct {
+	Name     string
+	Messages int
+}
+
+type ProfileMessage struct {
+	ID      int64
+	Message string
+	// Author is who appended the message, or empty once they have been deleted.
+	Author string
+	Added  time.Time
+}
+
+// Permission is what someone a profile is shared with may do with it.
+type Permission string
+
+const (
+	PermissionNone   Permission = ""
+	PermissionRead   Permission = "read"
+	PermissionAppend Permission = "append"
+)
+
+// Allows reports whether p grants at least want. Append implies read.
+func (p Permission) Allows(want Permission) bool {
+	switch want {
+	case PermissionRead:
+		return p == PermissionRead || p == PermissionAppend
+	case PermissionAppend:
+		return p == PermissionAppend
+	}
+	return false
+}
+
+type ProfileShare struct {
+	Key        string
+	Permission Permission
+}
+
+type SharedProfile struct {
+	Owner      string
+	Name       string
+	Permission Permission
+	Messages   int
+}
+
+var _ Store = (*SQLStore)(nil)
+var _ Store = (*MemoryStore)(nil)
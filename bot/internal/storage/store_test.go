@@ -0,0 +1,641 @@
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+// storeKinds are the Store implementations every test in this file runs against,
+// so that MemoryStore keeps behaving like the databases it stands in for. The
+// PostgreSQL ones are skipped when no server can be started.
+var storeKinds = []struct {
+	name string
+	open func(t *testing.T) Store
+}{
+	{"memory", func(t *testing.T) Store { return NewMemoryStore() }},
+	{"sqlite3", func(t *testing.T) Store {
+		store, err := InitStore("sqlite3", filepath.Join(t.TempDir(), "hearsay.db"))
+		if err != nil {
+			t.Fatalf("Failed to open SQLite store: %s", err)
+		}
+		return store
+	}},
+	{"postgres", func(t *testing.T) Store {
+		store, err := InitStore("postgres", postgresDatabase(t))
+		if err != nil {
+			t.Fatalf("Failed to open PostgreSQL store: %s", err)
+		}
+		return store
+	}},
+}
+
+// forEachStore runs test against a fresh store of every kind.
+func forEachStore(t *testing.T, test func(t *testing.T, store Store)) {
+	for _, kind := range storeKinds {
+		t.Run(kind.name, func(t *testing.T) {
+			store := kind.open(t)
+			t.Cleanup(func() { store.Close() })
+			test(t, store)
+		})
+	}
+}
+
+var base = time.Date(2025, 3, 1, 20, 0, 0, 0, time.UTC)
+
+func message(nick string, channel string, content string, minutes int) Message {
+	return Message{Nick: nick, Channel: channel, Content: content, Timestamp: base.Add(time.Duration(minutes) * time.Minute)}
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func optIn(t *testing.T, store Store, keys ...string) {
+	t.Helper()
+	for _, key := range keys {
+		must(t, store.SetOpt(key, true))
+	}
+}
+
+func sorted(list []string) []string {
+	list = slices.Clone(list)
+	slices.Sort(list)
+	return list
+}
+
+func TestStoreConsent(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		if _, err := store.OptStatus("alice"); err != ErrNotFound {
+			t.Errorf("OptStatus of an unknown key = %v, want ErrNotFound", err)
+		}
+		if _, err := store.User("alice"); err != ErrNotFound {
+			t.Errorf("User of an unknown key = %v, want ErrNotFound", err)
+		}
+
+		optIn(t, store, "alice", "alice")
+		must(t, store.SetOpt("bob", false))
+		if opt, err := store.OptStatus("alice"); err != nil || !opt {
+			t.Errorf("OptStatus(alice) = %v, %v, want true", opt, err)
+		}
+		if keys, err := store.OptedIn(); err != nil || !reflect.DeepEqual(keys, []string{"alice"}) {
+			t.Errorf("OptedIn() = %v, %v, want [alice]", keys, err)
+		}
+
+		must(t, store.SetOpt("alice", false))
+		history, err := store.ConsentHistory("alice")
+		must(t, err)
+		opts := []bool{}
+		for _, change := range history {
+			opts = append(opts, change.Opt)
+		}
+		// Setting the same status twice is not a change.
+		if !reflect.DeepEqual(opts, []bool{true, false}) {
+			t.Errorf("ConsentHistory(alice) = %v, want [true false]", opts)
+		}
+		if history, err := store.ConsentHistory("nobody"); err != nil || len(history) != 0 {
+			t.Errorf("ConsentHistory(nobody) = %v, %v, want nothing", history, err)
+		}
+
+		user, err := store.User("alice")
+		must(t, err)
+		if user.Key != "alice" || user.Opt || user.Deletion != nil || user.Registered.IsZero() {
+			t.Errorf("User(alice) = %+v", user)
+		}
+	})
+}
+
+func TestStoreDeletions(t *testing.T) {
+	day := func(n int) time.Time { return base.AddDate(0, 0, n) }
+
+	forEachStore(t, func(t *testing.T, store Store) {
+		if err := store.ScheduleDeletion("alice", day(1)); err != ErrNotFound {
+			t.Errorf("ScheduleDeletion of an unknown key = %v, want ErrNotFound", err)
+		}
+		if _, _, err := store.DeletionScheduled("alice"); err != ErrNotFound {
+			t.Errorf("DeletionScheduled of an unknown key = %v, want ErrNotFound", err)
+		}
+		if cancelled, err := store.CancelDeletion("alice"); err != nil || cancelled {
+			t.Errorf("CancelDeletion of an unknown key = %v, %v", cancelled, err)
+		}
+
+		optIn(t, store, "alice", "bob")
+		must(t, store.SubmitMessages([]Message{message("alice", "#a", "hello", 0), message("bob", "#a", "hi", 1)}))
+		must(t, store.CreateProfile("bob", "q"))
+		must(t, store.AppendProfile("bob", "q", "alice", "from alice"))
+		must(t, store.ShareProfile("bob", "q", "alice", PermissionAppend))
+		must(t, store.CreateProfile("alice", "p"))
+
+		if _, scheduled, err := store.DeletionScheduled("alice"); err != nil || scheduled {
+			t.Errorf("DeletionScheduled(alice) before scheduling = %v, %v", scheduled, err)
+		}
+		must(t, store.ScheduleDeletion("alice", day(1)))
+		must(t, store.ScheduleDeletion("bob", day(3)))
+		if at, scheduled, err := store.DeletionScheduled("alice"); err != nil || !scheduled || !at.Equal(day(1)) {
+			t.Errorf("DeletionScheduled(alice) = %v, %v, %v, want %v", at, scheduled, err, day(1))
+		}
+		if user, err := store.User("alice"); err != nil || user.Deletion == nil || !user.Deletion.Equal(day(1)) {
+			t.Errorf("User(alice) = %+v, %v, want the deletion on %v", user, err, day(1))
+		}
+
+		for _, tt := range []struct {
+			now  time.Time
+			want []string
+		}{
+			{day(0), []string{}},
+			{day(1), []string{"alice"}},
+			{day(5), []string{"alice", "bob"}},
+		} {
+			if keys, err := store.DeletionsDue(tt.now); err != nil || !reflect.DeepEqual(sorted(keys), tt.want) {
+				t.Errorf("DeletionsDue(%v) = %v, %v, want %v", tt.now, keys, err, tt.want)
+			}
+		}
+
+		if cancelled, err := store.CancelDeletion("bob"); err != nil || !cancelled {
+			t.Errorf("CancelDeletion(bob) = %v, %v, want true", cancelled, err)
+		}
+		if cancelled, err := store.CancelDeletion("bob"); err != nil || cancelled {
+			t.Errorf("CancelDeletion(bob) again = %v, %v, want false", cancelled, err)
+		}
+
+		must(t, store.DeleteUser("alice"))
+		if err := store.DeleteUser("alice"); err != ErrNotFound {
+			t.Errorf("DeleteUser of a deleted key = %v, want ErrNotFound", err)
+		}
+		if _, err := store.OptStatus("alice"); err != ErrNotFound {
+			t.Errorf("OptStatus(alice) after deletion = %v, want ErrNotFound", err)
+		}
+		if count, err := store.CountMessages("alice"); err != nil || count != 0 {
+			t.Errorf("CountMessages(alice) after deletion = %d, %v", count, err)
+		}
+		if count, err := store.CountProfiles("alice"); err != nil || count != 0 {
+			t.Errorf("CountProfiles(alice) after deletion = %d, %v", count, err)
+		}
+		if shared, err := store.SharedWith("alice"); err != nil || len(shared) != 0 {
+			t.Errorf("SharedWith(alice) after deletion = %v, %v", shared, err)
+		}
+		// What alice appended to bob's profile stays, without her name on it.
+		messages, err := store.ProfileMessages("bob", "q")
+		must(t, err)
+		if len(messages) != 1 || messages[0].Author != "" {
+			t.Errorf("ProfileMessages(bob, q) after deleting alice = %+v", messages)
+		}
+		if count, err := store.CountMessages("bob"); err != nil || count != 1 {
+			t.Errorf("CountMessages(bob) = %d, %v, want 1", count, err)
+		}
+	})
+}
+
+func TestStoreForgets(t *testing.T) {
+	due := base.AddDate(0, 0, 7)
+
+	forEachStore(t, func(t *testing.T, store Store) {
+		if err := store.ScheduleForget("alice", MessageFilter{}, due); err == nil {
+			t.Errorf("ScheduleForget of an unknown key succeeded")
+		}
+
+		optIn(t, store, "alice")
+		must(t, store.SubmitMessages([]Message{
+			message("alice", "#a", "one", 0),
+			message("alice", "#b", "two", 1),
+			message("alice", "#a", "three", 2),
+			message("alice", "#B", "four", 3),
+			message("alice", "#a", "five", 4),
+			message("bob", "#a", "not alice", 5),
+		}))
+
+		for _, tt := range []struct {
+			name   string
+			filter MessageFilter
+			want   int
+		}{
+			{"everything", MessageFilter{}, 5},
+			{"channel, any case", MessageFilter{Channel: "#A"}, 3},
+			{"since is inclusive", MessageFilter{Since: base.Add(2 * time.Minute)}, 3},
+			{"until is exclusive", MessageFilter{Until: base.Add(2 * time.Minute)}, 2},
+			{"last", MessageFilter{Last: 2}, 2},
+			{"last of a channel", MessageFilter{Channel: "#b", Last: 5}, 2},
+			{"range", MessageFilter{Since: base.Add(time.Minute), Until: base.Add(4 * time.Minute)}, 3},
+		} {
+			if count, err := store.CountMatching("alice", tt.filter); err != nil || count != tt.want {
+				t.Errorf("CountMatching(alice, %s) = %d, %v, want %d", tt.name, count, err, tt.want)
+			}
+		}
+
+		must(t, store.ScheduleForget("alice", MessageFilter{Channel: "#b"}, due))
+		must(t, store.ScheduleForget("alice", MessageFilter{Since: base.Add(4 * time.Minute), Last: 1}, due.AddDate(0, 0, 1)))
+
+		if forgets, err := store.ForgetsDue(due.Add(-time.Second)); err != nil || len(forgets) != 0 {
+			t.Errorf("ForgetsDue before they are due = %+v, %v", forgets, err)
+		}
+		forgets, err := store.ForgetsDue(due)
+		must(t, err)
+		if len(forgets) != 1 || forgets[0].Key != "alice" || forgets[0].Filter.Channel != "#b" ||
+			!forgets[0].Filter.Since.IsZero() || !forgets[0].Filter.Until.IsZero() || !forgets[0].Due.Equal(due) {
+			t.Fatalf("ForgetsDue(%v) = %+v", due, forgets)
+		}
+
+		if deleted, err := store.Forget(forgets[0].ID); err != nil || deleted != 2 {
+			t.Errorf("Forget = %d, %v, want 2", deleted, err)
+		}
+		if _, err := store.Forget(forgets[0].ID); err != ErrNotFound {
+			t.Errorf("Forget of a forget already carried out = %v, want ErrNotFound", err)
+		}
+		if count, err := store.CountMessages("alice"); err != nil || count != 3 {
+			t.Errorf("CountMessages(alice) = %d, %v, want 3", count, err)
+		}
+
+		later, err := store.ForgetsDue(due.AddDate(0, 0, 1))
+		must(t, err)
+		if len(later) != 1 || later[0].Filter.Last != 1 || !later[0].Filter.Since.Equal(base.Add(4*time.Minute)) {
+			t.Errorf("ForgetsDue a day later = %+v", later)
+		}
+		if cancelled, err := store.CancelForgets("alice"); err != nil || cancelled != 1 {
+			t.Errorf("CancelForgets(alice) = %d, %v, want 1", cancelled, err)
+		}
+		if later, err := store.ForgetsDue(due.AddDate(0, 0, 1)); err != nil || len(later) != 0 {
+			t.Errorf("ForgetsDue after cancelling = %+v, %v", later, err)
+		}
+	})
+}
+
+func TestStoreMessages(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		if newest, err := store.NewestMessage(); err != nil || !newest.IsZero() {
+			t.Errorf("NewestMessage() of an empty store = %v, %v", newest, err)
+		}
+
+		batch := []Message{
+			message("alice", "#a", "  padded  ", 0),
+			message("alice", "#a", "padded", 0), // the same message once trimmed
+			message("alice", "#a", "two", 1),
+			message("alice", "#a", "three", 30),
+			message("bob", "#a", "one", 2),
+		}
+		if written, err := store.SubmitMessagesOnce(batch); err != nil || written != 4 {
+			t.Errorf("SubmitMessagesOnce = %d, %v, want 4", written, err)
+		}
+		if written, err := store.SubmitMessagesOnce(batch); err != nil || written != 0 {
+			t.Errorf("SubmitMessagesOnce again = %d, %v, want 0", written, err)
+		}
+		if stored, err := store.CountStored(append(batch, message("bob", "#a", "new", 3))); err != nil || stored != 4 {
+			t.Errorf("CountStored = %d, %v, want 4", stored, err)
+		}
+
+		// Messages are collected before their authors opt in.
+		if opt, err := store.OptStatus("bob"); err != nil || opt {
+			t.Errorf("OptStatus(bob) = %v, %v, want a users row that is not opted in", opt, err)
+		}
+
+		if count, err := store.CountMessages("alice"); err != nil || count != 3 {
+			t.Errorf("CountMessages(alice) = %d, %v, want 3", count, err)
+		}
+		// Only alice opts in, so only she may be part of the model.
+		optIn(t, store, "alice")
+		for quota, want := range map[int]int{1: 1, 3: 1, 4: 0} {
+			if count, err := store.CountEligible(quota); err != nil || count != want {
+				t.Errorf("CountEligible(%d) = %d, %v, want %d", quota, count, err, want)
+			}
+		}
+		if newest, err := store.NewestMessage(); err != nil || !newest.Equal(base.Add(30*time.Minute)) {
+			t.Errorf("NewestMessage() = %v, %v", newest, err)
+		}
+
+		users, err := store.EligibleUsers(1, base.Add(time.Minute))
+		must(t, err)
+		want := []EligibleUser{{"alice", 3, 1}}
+		if !reflect.DeepEqual(users, want) {
+			t.Errorf("EligibleUsers = %+v, want %+v", users, want)
+		}
+
+		walked := []string{}
+		must(t, store.WalkMessages("alice", func(m Message) error {
+			if m.Nick != "alice" || m.Channel != "#a" {
+				t.Errorf("WalkMessages(alice) gave %+v", m)
+			}
+			walked = append(walked, m.Content)
+			return nil
+		}))
+		if !reflect.DeepEqual(walked, []string{"padded", "two", "three"}) {
+			t.Errorf("WalkMessages(alice) = %v", walked)
+		}
+		stop := errors.New("stop")
+		if err := store.WalkMessages("alice", func(Message) error { return stop }); err != stop {
+			t.Errorf("WalkMessages returned %v, want the callback's error", err)
+		}
+	})
+}
+
+func TestStoreProfiles(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		if err := store.CreateProfile("alice", "a"); err == nil {
+			t.Errorf("CreateProfile for an unknown owner succeeded")
+		}
+
+		optIn(t, store, "alice")
+		must(t, store.CreateProfile("alice", "a"))
+		must(t, store.CreateProfile("alice", "b"))
+		if count, err := store.CountProfiles("alice"); err != nil || count != 2 {
+			t.Errorf("CountProfiles(alice) = %d, %v, want 2", count, err)
+		}
+		if exists, err := store.ProfileExists("alice", "a"); err != nil || !exists {
+			t.Errorf("ProfileExists(alice, a) = %v, %v", exists, err)
+		}
+		if exists, err := store.ProfileExists("alice", "z"); err != nil || exists {
+			t.Errorf("ProfileExists(alice, z) = %v, %v", exists, err)
+		}
+
+		must(t, store.AppendProfile("alice", "a", "alice", "one"))
+		must(t, store.AppendProfile("alice", "a", "alice", "two"))
+		if err := store.AppendProfile("alice", "z", "alice", "three"); err != ErrNotFound {
+			t.Errorf("AppendProfile to a missing profile = %v, want ErrNotFound", err)
+		}
+		if _, err := store.ProfileMessages("alice", "z"); err != ErrNotFound {
+			t.Errorf("ProfileMessages of a missing profile = %v, want ErrNotFound", err)
+		}
+		if count, err := store.CountProfileMessages("alice", "z"); err != nil || count != 0 {
+			t.Errorf("CountProfileMessages of a missing profile = %d, %v", count, err)
+		}
+
+		messages, err := store.ProfileMessages("alice", "a")
+		must(t, err)
+		if len(messages) != 2 || messages[0].Message != "one" || messages[1].Message != "two" ||
+			messages[0].Author != "alice" || messages[0].Added.IsZero() {
+			t.Fatalf("ProfileMessages(alice, a) = %+v", messages)
+		}
+		must(t, store.RemoveProfileMessage("alice", "a", messages[0].ID))
+		if err := store.RemoveProfileMessage("alice", "a", messages[0].ID); err != ErrNotFound {
+			t.Errorf("RemoveProfileMessage of a removed message = %v, want ErrNotFound", err)
+		}
+		if err := store.RemoveProfileMessage("alice", "b", messages[1].ID); err != ErrNotFound {
+			t.Errorf("RemoveProfileMessage from the wrong profile = %v, want ErrNotFound", err)
+		}
+		if count, err := store.CountProfileMessages("alice", "a"); err != nil || count != 1 {
+			t.Errorf("CountProfileMessages(alice, a) = %d, %v, want 1", count, err)
+		}
+
+		must(t, store.RenameProfile("alice", "a", "c"))
+		if err := store.RenameProfile("alice", "a", "d"); err != ErrNotFound {
+			t.Errorf("RenameProfile of a missing profile = %v, want ErrNotFound", err)
+		}
+		if profiles, err := store.ListProfiles("alice"); err != nil || !reflect.DeepEqual(profiles, []ProfileSummary{{"b", 0}, {"c", 1}}) {
+			t.Errorf("ListProfiles(alice) = %+v, %v", profiles, err)
+		}
+
+		if removed, err := store.ClearProfile("alice", "c"); err != nil || removed != 1 {
+			t.Errorf("ClearProfile(alice, c) = %d, %v, want 1", removed, err)
+		}
+		if removed, err := store.ClearProfile("alice", "z"); err != nil || removed != 0 {
+			t.Errorf("ClearProfile of a missing profile = %d, %v", removed, err)
+		}
+
+		must(t, store.DeleteProfile("alice", "b"))
+		if err := store.DeleteProfile("alice", "b"); err != ErrNotFound {
+			t.Errorf("DeleteProfile of a deleted profile = %v, want ErrNotFound", err)
+		}
+		if profiles, err := store.ListProfiles("alice"); err != nil || !reflect.DeepEqual(profiles, []ProfileSummary{{"c", 0}}) {
+			t.Errorf("ListProfiles(alice) = %+v, %v", profiles, err)
+		}
+	})
+}
+
+func TestStoreSharing(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		optIn(t, store, "alice", "bob")
+		must(t, store.CreateProfile("alice", "p"))
+		must(t, store.AppendProfile("alice", "p", "alice", "one"))
+
+		if err := store.ShareProfile("alice", "p", "carol", PermissionRead); err != ErrNotFound {
+			t.Errorf("ShareProfile with an unknown key = %v, want ErrNotFound", err)
+		}
+		if err := store.ShareProfile("alice", "z", "bob", PermissionRead); err != ErrNotFound {
+			t.Errorf("ShareProfile of a missing profile = %v, want ErrNotFound", err)
+		}
+
+		must(t, store.ShareProfile("alice", "p", "bob", PermissionRead))
+		if permission, err := store.ProfilePermission("alice", "p", "bob"); err != nil || permission != PermissionRead {
+			t.Errorf("ProfilePermission(alice, p, bob) = %q, %v, want read", permission, err)
+		}
+		must(t, store.ShareProfile("alice", "p", "bob", PermissionAppend))
+		if shares, err := store.ListShares("alice", "p"); err != nil || !reflect.DeepEqual(shares, []ProfileShare{{"bob", PermissionAppend}}) {
+			t.Errorf("ListShares(alice, p) = %+v, %v", shares, err)
+		}
+		if shared, err := store.SharedWith("bob"); err != nil || !reflect.DeepEqual(shared, []SharedProfile{{"alice", "p", PermissionAppend, 1}}) {
+			t.Errorf("SharedWith(bob) = %+v, %v", shared, err)
+		}
+		if permission, err := store.ProfilePermission("alice", "p", "carol"); err != nil || permission != PermissionNone {
+			t.Errorf("ProfilePermission(alice, p, carol) = %q, %v, want none", permission, err)
+		}
+
+		must(t, store.UnshareProfile("alice", "p", "bob"))
+		if err := store.UnshareProfile("alice", "p", "bob"); err != ErrNotFound {
+			t.Errorf("UnshareProfile of a profile no longer shared = %v, want ErrNotFound", err)
+		}
+		if shared, err := store.SharedWith("bob"); err != nil || len(shared) != 0 {
+			t.Errorf("SharedWith(bob) after unsharing = %+v, %v", shared, err)
+		}
+	})
+}
+
+func TestStoreModelRuns(t *testing.T) {
+	accuracy, f1 := 0.75, 0.5
+	dataUntil := base.Add(-time.Hour)
+	succeeded := ModelRun{ID: 1, Requester: "alice", ConfusionMatrix: true, PastDays: 30, Succeeded: true,
+		Started: base, Finished: base.Add(time.Minute), FitSeconds: 12.5, Labels: 3, Samples: 300,
+		Accuracy: &accuracy, F1: &f1, DataUntil: &dataUntil}
+	failed := ModelRun{ID: 2, Reason: "the data changed", Error: "timeout",
+		Started: base.Add(time.Hour), Finished: base.Add(2 * time.Hour)}
+
+	forEachStore(t, func(t *testing.T, store Store) {
+		if runs, err := store.ModelRuns(5); err != nil || len(runs) != 0 {
+			t.Errorf("ModelRuns of an empty store = %+v, %v", runs, err)
+		}
+		if _, ok, err := store.LastModelRun(); err != nil || ok {
+			t.Errorf("LastModelRun of an empty store = %v, %v", ok, err)
+		}
+		if _, err := store.ModelRun(1); err != ErrNotFound {
+			t.Errorf("ModelRun of a missing run = %v, want ErrNotFound", err)
+		}
+
+		must(t, store.RecordModelRun(succeeded))
+		must(t, store.RecordModelRun(failed))
+
+		runs, err := store.ModelRuns(5)
+		must(t, err)
+		if len(runs) != 2 || runs[0].ID != 2 || runs[1].ID != 1 {
+			t.Errorf("ModelRuns(5) = %+v, want runs 2 and 1", runs)
+		}
+		if runs, err := store.ModelRuns(1); err != nil || len(runs) != 1 || runs[0].ID != 2 {
+			t.Errorf("ModelRuns(1) = %+v, %v, want run 2", runs, err)
+		}
+
+		last, ok, err := store.LastModelRun()
+		if err != nil || !ok || last.ID != 1 {
+			t.Fatalf("LastModelRun() = %+v, %v, %v, want run 1", last, ok, err)
+		}
+		if last.Requester != "alice" || !last.ConfusionMatrix || last.Bert || last.PastDays != 30 ||
+			!last.Started.Equal(base) || !last.Finished.Equal(base.Add(time.Minute)) ||
+			last.FitSeconds != 12.5 || last.Labels != 3 || last.Samples != 300 ||
+			last.Accuracy == nil || *last.Accuracy != accuracy || last.F1 == nil || *last.F1 != f1 ||
+			last.DataUntil == nil || !last.DataUntil.Equal(dataUntil) {
+			t.Errorf("LastModelRun() = %+v", last)
+		}
+
+		run, err := store.ModelRun(2)
+		must(t, err)
+		if run.Succeeded || run.Error != "timeout" || run.Reason != "the data changed" || run.Accuracy != nil || run.DataUntil != nil {
+			t.Errorf("ModelRun(2) = %+v", run)
+		}
+	})
+}
+
+func TestStoreRemovedSince(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		before := time.Now().Add(-time.Hour)
+		after := time.Now().Add(time.Hour)
+
+		optIn(t, store, "alice", "bob")
+		must(t, store.SetOpt("carol", false))
+		must(t, store.SubmitMessages([]Message{
+			message("alice", "#a", "one", 0),
+			message("alice", "#b", "two", 1),
+			message("dave", "#a", "three", 2),
+		}))
+		must(t, store.SetOpt("erin", true))
+
+		// Counted: bob opting out, a selective deletion that removed a message and
+		// dave's purge. Not counted: carol, who never opted in, a selective deletion
+		// that matched nothing and erin's purge, who had no messages.
+		must(t, store.SetOpt("bob", false))
+		must(t, store.ScheduleForget("alice", MessageFilter{Channel: "#b"}, base))
+		must(t, store.ScheduleForget("alice", MessageFilter{Channel: "#c"}, base))
+		forgets, err := store.ForgetsDue(base)
+		must(t, err)
+		for _, forget := range forgets {
+			_, err := store.Forget(forget.ID)
+			must(t, err)
+		}
+		must(t, store.DeleteUser("dave"))
+		must(t, store.DeleteUser("erin"))
+
+		if removed, err := store.RemovedSince(before); err != nil || removed != 3 {
+			t.Errorf("RemovedSince(an hour ago) = %d, %v, want 3", removed, err)
+		}
+		if removed, err := store.RemovedSince(after); err != nil || removed != 0 {
+			t.Errorf("RemovedSince(in an hour) = %d, %v, want 0", removed, err)
+		}
+	})
+}
+
+func TestStoreAliases(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		optIn(t, store, "alice_")
+		must(t, store.SubmitMessages([]Message{
+			message("alice_", "#a", "one", 0),
+			message("alice_", "#a", "two", 1),
+			message("old", "#a", "three", 2),
+		}))
+		must(t, store.CreateProfile("alice_", "p"))
+		must(t, store.ScheduleForget("alice_", MessageFilter{Last: 1}, base))
+		must(t, store.LinkAlias("old", "alice_", 3))
+
+		// alice has no users row of her own yet; she takes over alice_'s consent.
+		must(t, store.LinkAlias("alice_", "alice", 3))
+		if opt, err := store.OptStatus("alice"); err != nil || !opt {
+			t.Errorf("OptStatus(alice) = %v, %v, want true", opt, err)
+		}
+		if _, err := store.OptStatus("alice_"); err != ErrNotFound {
+			t.Errorf("OptStatus(alice_) = %v, want ErrNotFound", err)
+		}
+		if history, err := store.ConsentHistory("alice"); err != nil || len(history) != 1 || !history[0].Opt {
+			t.Errorf("ConsentHistory(alice) = %+v, %v", history, err)
+		}
+		if exists, err := store.ProfileExists("alice", "p"); err != nil || !exists {
+			t.Errorf("ProfileExists(alice, p) = %v, %v", exists, err)
+		}
+		if forgets, err := store.ForgetsDue(base); err != nil || len(forgets) != 1 || forgets[0].Key != "alice" {
+			t.Errorf("ForgetsDue = %+v, %v, want alice's", forgets, err)
+		}
+		if count, err := store.CountMessages("alice"); err != nil || count != 3 {
+			t.Errorf("CountMessages(alice) = %d, %v, want 3", count, err)
+		}
+
+		// Moved messages are deduplicated under their new nick.
+		moved := []Message{message("alice", "#a", "one", 0), message("alice", "#a", "three", 2)}
+		if stored, err := store.CountStored(moved); err != nil || stored != 2 {
+			t.Errorf("CountStored of moved messages = %d, %v, want 2", stored, err)
+		}
+		if written, err := store.SubmitMessagesOnce(moved); err != nil || written != 0 {
+			t.Errorf("SubmitMessagesOnce of moved messages = %d, %v, want 0", written, err)
+		}
+		if written, err := store.SubmitMessagesOnce([]Message{message("alice_", "#a", "one", 0)}); err != nil || written != 1 {
+			t.Errorf("SubmitMessagesOnce under the alias = %d, %v, want 1", written, err)
+		}
+
+		// A message both nicks already had is kept twice rather than failing the link.
+		must(t, store.LinkAlias("alice_", "alice", 3))
+		if count, err := store.CountMessages("alice"); err != nil || count != 4 {
+			t.Errorf("CountMessages(alice) after linking a duplicate = %d, %v, want 4", count, err)
+		}
+
+		if aliases, err := store.ListAliases("alice"); err != nil || !reflect.DeepEqual(sorted(aliases), []string{"alice_", "old"}) {
+			t.Errorf("ListAliases(alice) = %v, %v", aliases, err)
+		}
+		if aliases, err := store.Aliases(); err != nil || !reflect.DeepEqual(aliases, map[string]string{"alice_": "alice", "old": "alice"}) {
+			t.Errorf("Aliases() = %v, %v", aliases, err)
+		}
+
+		must(t, store.DeleteUser("alice"))
+		if aliases, err := store.Aliases(); err != nil || len(aliases) != 0 {
+			t.Errorf("Aliases() after deleting alice = %v, %v", aliases, err)
+		}
+	})
+}
+
+func TestStoreLinkAliasMerge(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		optIn(t, store, "alice")
+		optIn(t, store, "old")
+		for _, name := range []string{"p", "q"} {
+			must(t, store.CreateProfile("alice", name))
+		}
+		for _, name := range []string{"p", "p-2"} {
+			must(t, store.CreateProfile("old", name))
+			must(t, store.AppendProfile("old", name, "old", "from "+name))
+		}
+		must(t, store.ScheduleDeletion("old", base.Add(time.Hour)))
+
+		if err := store.LinkAlias("old", "alice", 3); err != ErrTooManyProfiles {
+			t.Fatalf("LinkAlias over the profile limit = %v, want ErrTooManyProfiles", err)
+		}
+		if count, err := store.CountProfiles("old"); err != nil || count != 2 {
+			t.Errorf("CountProfiles(old) after a refused link = %d, %v, want 2", count, err)
+		}
+
+		must(t, store.LinkAlias("old", "alice", 4))
+		want := []ProfileSummary{{"p", 0}, {"p-2", 1}, {"p-3", 1}, {"q", 0}}
+		if profiles, err := store.ListProfiles("alice"); err != nil || !reflect.DeepEqual(profiles, want) {
+			t.Errorf("ListProfiles(alice) = %+v, %v, want %+v", profiles, err, want)
+		}
+		if messages, err := store.ProfileMessages("alice", "p-3"); err != nil || len(messages) != 1 || messages[0].Message != "from p" {
+			t.Errorf("ProfileMessages(alice, p-3) = %+v, %v, want old's p", messages, err)
+		}
+		if at, scheduled, err := store.DeletionScheduled("alice"); err != nil || !scheduled || !at.Equal(base.Add(time.Hour)) {
+			t.Errorf("DeletionScheduled(alice) = %v, %v, %v, want old's deletion", at, scheduled, err)
+		}
+
+		// The earlier of two pending deletions is kept.
+		optIn(t, store, "older")
+		must(t, store.ScheduleDeletion("older", base.Add(2*time.Hour)))
+		must(t, store.LinkAlias("older", "alice", 4))
+		if at, _, err := store.DeletionScheduled("alice"); err != nil || !at.Equal(base.Add(time.Hour)) {
+			t.Errorf("DeletionScheduled(alice) after a later one = %v, %v, want %v", at, err, base.Add(time.Hour))
+		}
+	})
+}
@@ -0,0 +1,153 @@
+// Package takeout lets users download everything hearsay stores about them. An
+// export is a ZIP archive of JSON Lines files, written to disk and served by an
+// embedded HTTP server under a random token until it expires.
+package takeout
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"hearsay/internal/storage"
+	"io"
+	"time"
+)
+
+type userRecord struct {
+	Nick       string     `json:"nick"`
+	Registered time.Time  `json:"registered"`
+	OptedIn    bool       `json:"opted_in"`
+	Deletion   *time.Time `json:"deletion,omitempty"`
+	Aliases    []string   `json:"aliases"`
+	Exported   time.Time  `json:"exported"`
+}
+
+type messageRecord struct {
+	Channel string    `json:"channel"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+type profileRecord struct {
+	Name     string                 `json:"name"`
+	Messages []profileMessageRecord `json:"messages"`
+	Shares   []shareRecord          `json:"shared_with"`
+}
+
+type profileMessageRecord struct {
+	Message string    `json:"message"`
+	Author  string    `json:"author,omitempty"`
+	Added   time.Time `json:"added"`
+}
+
+type shareRecord struct {
+	Nick       string `json:"nick"`
+	Permission string `json:"permission"`
+}
+
+type consentRecord struct {
+	OptedIn bool      `json:"opted_in"`
+	Changed time.Time `json:"changed"`
+}
+
+// Write writes key's data to w as a ZIP archive holding user.json, messages.jsonl,
+// profiles.jsonl and consent.jsonl.
+func Write(w io.Writer, key string, store storage.Store) error {
+	user, err := store.User(key)
+	if err != nil {
+		return err
+	}
+	aliases, err := store.ListAliases(key)
+	if err != nil {
+		return err
+	}
+
+	archive := zip.NewWriter(w)
+	err = writeJSON(archive, "user.json", userRecord{key, user.Registered, user.Opt, user.Deletion, aliases, time.Now()})
+	if err != nil {
+		return err
+	}
+
+	enc, err := jsonLines(archive, "messages.jsonl")
+	if err != nil {
+		return err
+	}
+	err = store.WalkMessages(key, func(m storage.Message) error {
+		return enc.Encode(messageRecord{m.Channel, m.Content, m.Timestamp})
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := writeProfiles(archive, key, store); err != nil {
+		return err
+	}
+
+	changes, err := store.ConsentHistory(key)
+	if err != nil {
+		return err
+	}
+	if enc, err = jsonLines(archive, "consent.jsonl"); err != nil {
+		return err
+	}
+	for _, change := range changes {
+		if err := enc.Encode(consentRecord{change.Opt, change.Changed}); err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
+
+func writeProfiles(archive *zip.Writer, key string, store storage.Store) error {
+	profiles, err := store.ListProfiles(key)
+	if err != nil {
+		return err
+	}
+	enc, err := jsonLines(archive, "profiles.jsonl")
+	if err != nil {
+		return err
+	}
+
+	for _, profile := range profiles {
+		messages, err := store.ProfileMessages(key, profile.Name)
+		if err != nil {
+			return err
+		}
+		shares, err := store.ListShares(key, profile.Name)
+		if err != nil {
+			return err
+		}
+
+		record := profileRecord{profile.Name, []profileMessageRecord{}, []shareRecord{}}
+		for _, message := range messages {
+			record.Messages = append(record.Messages, profileMessageRecord{message.Message, message.Author, message.Added})
+		}
+		for _, share := range shares {
+			record.Shares = append(record.Shares, shareRecord{share.Key, string(share.Permission)})
+		}
+		if err := enc.Encode(record); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func jsonLines(archive *zip.Writer, name string) (*json.Encoder, error) {
+	f, err := archive.Create(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.NewEncoder(f), nil
+}
+
+func writeJSON(archive *zip.Writer, name string, v any) error {
+	f, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -0,0 +1,182 @@
+package takeout
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"hearsay/internal/storage"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type export struct {
+	key     string
+	path    string
+	expires time.Time
+}
+
+var (
+	mu      sync.Mutex
+	dir     = "data/exports"
+	baseURL = ""
+	ttl     = 15 * time.Minute
+	pending = make(map[string]export) // token -> export
+	now     = time.Now
+)
+
+// Configure sets where archives are written, the public URL the server is reached
+// at and how long a link stays valid. An empty url disables exports.
+func Configure(directory string, url string, lifetime time.Duration) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	dir = directory
+	baseURL = strings.TrimRight(url, "/")
+	ttl = lifetime
+}
+
+// Enabled reports whether exports can be handed out.
+func Enabled() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return baseURL != ""
+}
+
+// Publish writes key's archive and returns the link it can be downloaded from until
+// it expires. Any earlier export of key is revoked.
+func Publish(key string, store storage.Store) (string, time.Time, error) {
+	Revoke(key)
+
+	mu.Lock()
+	directory := dir
+	mu.Unlock()
+	if err := os.MkdirAll(directory, 0o700); err != nil {
+		return "", time.Time{}, err
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", time.Time{}, err
+	}
+	token := hex.EncodeToString(raw)
+	path := filepath.Join(directory, token+".zip")
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	err = Write(f, key, store)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", time.Time{}, err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	expires := now().Add(ttl)
+	pending[token] = export{key, path, expires}
+
+	return baseURL + "/export/" + token, expires, nil
+}
+
+// Revoke removes every export of key, such as when their data is purged.
+func Revoke(key string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for token, e := range pending {
+		if e.key == key {
+			remove(token)
+		}
+	}
+}
+
+// remove forgets an export and deletes its archive. Callers hold mu.
+func remove(token string) {
+	if err := os.Remove(pending[token].path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Failed to remove export archive: %s\n", err.Error())
+	}
+	delete(pending, token)
+}
+
+// expire removes exports whose link has run out. Callers hold mu.
+func expire() {
+	for token, e := range pending {
+		if !now().Before(e.expires) {
+			remove(token)
+		}
+	}
+}
+
+// Serve runs the download server on addr until ctx is canceled. Archives left
+// behind by an earlier run are deleted first, since their tokens died with it.
+func Serve(ctx context.Context, addr string) error {
+	mu.Lock()
+	leftovers, _ := filepath.Glob(filepath.Join(dir, "*.zip"))
+	mu.Unlock()
+	for _, path := range leftovers {
+		os.Remove(path)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /export/{token}", download)
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				server.Shutdown(shutdown)
+				cancel()
+				return
+			case <-ticker.C:
+				mu.Lock()
+				expire()
+				mu.Unlock()
+			}
+		}
+	}()
+
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+func download(w http.ResponseWriter, r *http.Request) {
+	mu.Lock()
+	expire()
+	e, ok := pending[r.PathValue("token")]
+	mu.Unlock()
+	if !ok {
+		http.Error(w, "This export does not exist or has expired.", http.StatusNotFound)
+		return
+	}
+
+	f, err := os.Open(e.path)
+	if err != nil {
+		log.Printf("Failed to open export archive: %s\n", err.Error())
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="hearsay-export.zip"`)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	http.ServeContent(w, r, "", time.Time{}, f)
+}